@@ -10,6 +10,7 @@ require (
 	github.com/imroc/req v0.3.2
 	github.com/shopspring/decimal v0.0.0-20200105231215-408a2507e114
 	github.com/tidwall/gjson v1.9.3
+	go.etcd.io/bbolt v1.3.3
 )
 
 require (
@@ -39,7 +40,6 @@ require (
 	github.com/tidwall/pretty v1.2.0 // indirect
 	github.com/tklauser/go-sysconf v0.3.5 // indirect
 	github.com/tklauser/numcpus v0.2.2 // indirect
-	go.etcd.io/bbolt v1.3.3 // indirect
 	golang.org/x/crypto v0.33.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
 	gopkg.in/natefinch/npipe.v2 v2.0.0-20160621034901-c1b8fa8bdcce // indirect
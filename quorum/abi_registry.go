@@ -0,0 +1,322 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 默认内存缓存ABI数量
+	DefaultABICacheSize = 1000
+
+	abiRegistryAddressDir  = "address"
+	abiRegistryCodeHashDir = "codehash"
+)
+
+// ABIRegistry 本地ABI注册表，按合约地址和代码hash持久化到文件，内存中按LRU淘汰
+type ABIRegistry struct {
+	dir         string
+	cache       *lruCache //子目录/文件名 -> ABI，为空表示本地没有记录
+	hasCodeHash bool      //是否记录了代码hash的ABI
+	sync.Mutex
+}
+
+// NewABIRegistry 创建ABI注册表
+func NewABIRegistry(dir string, capacity int) (*ABIRegistry, error) {
+
+	if capacity <= 0 {
+		capacity = DefaultABICacheSize
+	}
+
+	for _, sub := range []string{abiRegistryAddressDir, abiRegistryCodeHashDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &ABIRegistry{
+		dir:   dir,
+		cache: newLRUCache(capacity, 0),
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, abiRegistryCodeHashDir))
+	r.hasCodeHash = len(files) > 0
+
+	return r, nil
+}
+
+// Save 保存合约ABI，address和codeHash可只填其一
+func (r *ABIRegistry) Save(address, codeHash, abiJSON string) error {
+
+	if len(address) == 0 && len(codeHash) == 0 {
+		return fmt.Errorf("address and code hash are both empty")
+	}
+
+	if _, err := abi.JSON(strings.NewReader(abiJSON)); err != nil {
+		return fmt.Errorf("abi json is invalid, err: %v", err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if len(address) > 0 {
+		if err := r.write(abiRegistryAddressDir, strings.ToLower(address), abiJSON); err != nil {
+			return err
+		}
+	}
+	if len(codeHash) > 0 {
+		if err := r.write(abiRegistryCodeHashDir, strings.ToLower(codeHash), abiJSON); err != nil {
+			return err
+		}
+		r.hasCodeHash = true
+	}
+	return nil
+}
+
+// GetByAddress 通过合约地址获取ABI
+func (r *ABIRegistry) GetByAddress(address string) string {
+	r.Lock()
+	defer r.Unlock()
+	abiJSON, _ := r.read(abiRegistryAddressDir, strings.ToLower(address))
+	return abiJSON
+}
+
+// GetByCodeHash 通过合约代码hash获取ABI
+func (r *ABIRegistry) GetByCodeHash(codeHash string) string {
+	r.Lock()
+	defer r.Unlock()
+	abiJSON, _ := r.read(abiRegistryCodeHashDir, strings.ToLower(codeHash))
+	return abiJSON
+}
+
+// HasCodeHash 是否记录了代码hash的ABI
+func (r *ABIRegistry) HasCodeHash() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.hasCodeHash
+}
+
+// LoadDir 从目录预加载JSON ABI文件，文件名为合约地址或代码hash，
+// 文件内容可以是ABI数组，或包含abi，address，codeHash字段的对象
+func (r *ABIRegistry) LoadDir(dir string) (int, error) {
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+
+		content, readErr := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if readErr != nil {
+			return count, readErr
+		}
+
+		var (
+			result   = gjson.ParseBytes(content)
+			abiJSON  = result.Raw
+			address  string
+			codeHash string
+			name     = strings.ToLower(strings.TrimSuffix(f.Name(), ".json"))
+		)
+
+		if result.IsObject() {
+			abiJSON = result.Get("abi").Raw
+			address = result.Get("address").String()
+			codeHash = result.Get("codeHash").String()
+		}
+
+		if len(address) == 0 && len(codeHash) == 0 {
+			//文件名：20字节为地址，32字节为代码hash
+			switch len(removeOxFromHex(name)) {
+			case 40:
+				address = AppendOxToAddress(name)
+			case 64:
+				codeHash = AppendOxToAddress(name)
+			default:
+				return count, fmt.Errorf("abi file %s has no address or code hash", f.Name())
+			}
+		}
+
+		if saveErr := r.Save(address, codeHash, abiJSON); saveErr != nil {
+			return count, fmt.Errorf("abi file %s save failed, err: %v", f.Name(), saveErr)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// remember 只在内存缓存中记录，不写入文件
+func (r *ABIRegistry) remember(address, abiJSON string) {
+	r.cache.Add(abiRegistryAddressDir+"/"+strings.ToLower(address), abiJSON)
+}
+
+// lookup 查找地址记录，known表示已有记录（包括内存中记录的没有结果）
+func (r *ABIRegistry) lookup(address string) (abiJSON string, known bool) {
+	r.Lock()
+	defer r.Unlock()
+	return r.read(abiRegistryAddressDir, strings.ToLower(address))
+}
+
+func (r *ABIRegistry) write(sub, name, abiJSON string) error {
+	err := writeFileAtomic(filepath.Join(r.dir, sub, name+".json"), []byte(abiJSON))
+	if err != nil {
+		return err
+	}
+	r.cache.Add(sub+"/"+name, abiJSON)
+	return nil
+}
+
+func (r *ABIRegistry) read(sub, name string) (string, bool) {
+	key := sub + "/" + name
+	if cached, ok := r.cache.Get(key); ok {
+		return cached.(string), true
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(r.dir, sub, name+".json"))
+	if err != nil {
+		return "", false
+	}
+	r.cache.Add(key, string(content))
+	return string(content), true
+}
+
+// GetCodeHash 获取合约代码hash
+func (wm *WalletManager) GetCodeHash(address string) (string, error) {
+	params := []interface{}{
+		AppendOxToAddress(wm.CustomAddressDecodeFunc(address)),
+		"latest",
+	}
+
+	result, err := wm.WalletClient.Call("eth_getCode", params)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := hexutil.Decode(result.String())
+	if err != nil {
+		return "", err
+	}
+	if len(code) == 0 {
+		return "", nil
+	}
+
+	return crypto.Keccak256Hash(code).String(), nil
+}
+
+// ResolveContractABI 从ABI注册表查找合约ABI，依次按合约地址，代理合约的逻辑合约地址，代码hash查找
+func (wm *WalletManager) ResolveContractABI(address string) string {
+
+	if wm.ABIRegistry == nil || len(address) == 0 {
+		return ""
+	}
+
+	r := wm.ABIRegistry
+	abiJSON, known := r.lookup(address)
+	if known {
+		return abiJSON
+	}
+
+	if implementation := wm.GetProxyImplementation(address); len(implementation) > 0 {
+		//逻辑合约可以升级，只记录在内存
+		abiJSON = r.GetByAddress(implementation)
+		if len(abiJSON) > 0 {
+			r.remember(address, abiJSON)
+			return abiJSON
+		}
+	}
+
+	if r.HasCodeHash() {
+		codeHash, err := wm.GetCodeHash(address)
+		if err != nil {
+			wm.Log.Debugf("get contract %s code hash failed, err: %v", address, err)
+			return ""
+		}
+		if len(codeHash) > 0 {
+			abiJSON = r.GetByCodeHash(codeHash)
+			if len(abiJSON) > 0 {
+				r.Save(address, "", abiJSON)
+				return abiJSON
+			}
+		}
+	}
+
+	r.remember(address, "")
+	return ""
+}
+
+// resolveContractWithABI 合约没有ABI时从ABI注册表补充，找不到时返回原合约
+func (wm *WalletManager) resolveContractWithABI(address string, contract *openwallet.SmartContract) *openwallet.SmartContract {
+
+	abiJSON := wm.ResolveContractABI(address)
+	if len(abiJSON) == 0 {
+		return contract
+	}
+
+	var resolved openwallet.SmartContract
+	if contract != nil {
+		//复制合约信息，避免修改外部对象
+		resolved = *contract
+	} else {
+		resolved = openwallet.SmartContract{
+			ContractID: openwallet.GenContractID(wm.Symbol(), address),
+			Symbol:     wm.Symbol(),
+			Address:    address,
+			Decimals:   0,
+		}
+	}
+	resolved.SetABI(abiJSON)
+	return &resolved
+}
+
+// saveContractABI 记录外部提供的合约ABI，重启后可继续使用，通用代币ABI不记录
+func (wm *WalletManager) saveContractABI(address, abiJSON string) {
+	if wm.ABIRegistry == nil || len(abiJSON) == 0 || isGenericTokenABI(abiJSON) {
+		return
+	}
+	if wm.ABIRegistry.GetByAddress(address) == abiJSON {
+		return
+	}
+	if err := wm.ABIRegistry.Save(address, "", abiJSON); err != nil {
+		wm.Log.Debugf("save contract %s abi failed, err: %v", address, err)
+	}
+}
+
+// isGenericTokenABI 是否内置的ERC20，ERC721，ERC1155 ABI
+func isGenericTokenABI(abiJSON string) bool {
+	switch abiJSON {
+	case ERC20_ABI_JSON, ERC721_ABI_JSON, ERC1155_ABI_JSON:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestABIRegistry(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := NewABIRegistry(filepath.Join(dir, "abi"), 2)
+	if err != nil {
+		t.Errorf("NewABIRegistry error: %v", err)
+		return
+	}
+
+	addresses := []string{
+		"0x550cdb1020046b3115a4f8ccebddfb28b66beb27",
+		"0x7ceb23fd6bc0add59e62ac25578270cff1b9f619",
+		"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+	}
+	for _, address := range addresses {
+		if err = registry.Save(address, "", ERC20_ABI_JSON); err != nil {
+			t.Errorf("Save error: %v", err)
+			return
+		}
+	}
+	if registry.cache.Len() != 2 {
+		t.Errorf("lru cache size expected 2, got %d", registry.cache.Len())
+	}
+	//淘汰的记录从文件重新加载
+	if registry.GetByAddress(addresses[0]) != ERC20_ABI_JSON {
+		t.Errorf("evicted abi should be reloaded from file")
+	}
+	if err = registry.Save(addresses[0], "", "not json"); err == nil {
+		t.Errorf("invalid abi json should not be saved")
+	}
+
+	//预加载目录
+	preload := filepath.Join(dir, "preload")
+	codeHash := "0x" + "11223344556677889900aabbccddeeff11223344556677889900aabbccddeeff"
+	os.MkdirAll(preload, os.ModePerm)
+	if err = ioutil.WriteFile(filepath.Join(preload, codeHash+".json"), []byte(ERC721_ABI_JSON), 0644); err != nil {
+		t.Errorf("write preload file error: %v", err)
+		return
+	}
+	count, err := registry.LoadDir(preload)
+	if err != nil || count != 1 {
+		t.Errorf("LoadDir unexpected, count: %d, err: %v", count, err)
+		return
+	}
+	if !registry.HasCodeHash() || registry.GetByCodeHash(codeHash) != ERC721_ABI_JSON {
+		t.Errorf("preload abi by code hash failed")
+	}
+}
+
+func TestWalletManager_saveContractABI(t *testing.T) {
+	wm := NewWalletManager()
+	wm.ABIRegistry, _ = NewABIRegistry(t.TempDir(), 0)
+
+	//通用代币ABI不记录
+	address := "0x550cdb1020046b3115a4f8ccebddfb28b66beb27"
+	wm.saveContractABI(address, ERC20_ABI_JSON)
+	if abiJSON, known := wm.ABIRegistry.lookup(address); known {
+		t.Errorf("generic token abi should not be saved, got: %s", abiJSON)
+	}
+
+	//外部提供的ABI记录到注册表
+	wm.saveContractABI(address, DISPERSE_ABI_JSON)
+	if wm.ABIRegistry.GetByAddress(address) != DISPERSE_ABI_JSON {
+		t.Errorf("provided abi should be saved")
+	}
+}
@@ -30,18 +30,19 @@ import (
 const (
 	//BLOCK_CHAIN_BUCKET = "blockchain" //区块链数据集合
 	//periodOfTask      = 5 * time.Second //定时任务执行隔间
-	MAX_EXTRACTING_SIZE = 20 //并发的扫描线程数
+	MAX_EXTRACTING_SIZE  = 20    //并发的扫描线程数
+	logContractCacheSize = 10000 //内存中缓存的日志合约数量
 
 )
 
 type BlockScanner struct {
 	*openwallet.BlockScannerBase
-	CurrentBlockHeight   uint64                               //当前区块高度
-	extractingCH         chan struct{}                        //扫描工作令牌
-	wm                   *WalletManager                       //钱包管理者
-	IsScanMemPool        bool                                 //是否扫描交易池
-	RescanLastBlockCount uint64                               //重扫上N个区块数量
-	logContractsMap      map[string]*openwallet.SmartContract //纪录合约信息避免重复查找合约ABI
+	CurrentBlockHeight   uint64         //当前区块高度
+	extractingCH         chan struct{}  //扫描工作令牌
+	wm                   *WalletManager //钱包管理者
+	IsScanMemPool        bool           //是否扫描交易池
+	RescanLastBlockCount uint64         //重扫上N个区块数量
+	logContracts         *lruCache      //纪录合约信息避免重复查找合约ABI
 	sync.RWMutex
 }
 
@@ -71,7 +72,7 @@ func NewBlockScanner(wm *WalletManager) *BlockScanner {
 	bs.wm = wm
 	bs.IsScanMemPool = false
 	bs.RescanLastBlockCount = 0
-	bs.logContractsMap = make(map[string]*openwallet.SmartContract)
+	bs.logContracts = newLRUCache(logContractCacheSize, 0)
 
 	//设置扫描任务
 	bs.SetTask(bs.ScanBlockTask)
@@ -79,6 +80,16 @@ func NewBlockScanner(wm *WalletManager) *BlockScanner {
 	return &bs
 }
 
+// getLogContract 获取内存中记录的日志合约信息
+func (bs *BlockScanner) getLogContract(address string) *openwallet.SmartContract {
+	cached, ok := bs.logContracts.Get(address)
+	if !ok {
+		return nil
+	}
+	contract, _ := cached.(*openwallet.SmartContract)
+	return contract
+}
+
 // SetRescanBlockHeight 重置区块链扫描高度
 func (bs *BlockScanner) SetRescanBlockHeight(height uint64) error {
 	height = height - 1
@@ -375,9 +386,7 @@ func (bs *BlockScanner) fetchContractsInfo(txs []*BlockTransaction) error {
 					contract.SetABI(ERC721_ABI_JSON)
 				}
 				if contract != nil {
-					bs.Lock()
-					bs.logContractsMap[address] = contract
-					bs.Unlock()
+					bs.logContracts.Add(address, contract)
 				}
 			}
 		}
@@ -390,7 +399,7 @@ func (bs *BlockScanner) fetchContractsInfo(txs []*BlockTransaction) error {
 	for _, tx := range txs {
 		for _, log := range tx.Receipt.ETHReceipt.Logs {
 			logContractAddress := strings.ToLower(log.Address.String())
-			if bs.logContractsMap[logContractAddress] != nil {
+			if bs.getLogContract(logContractAddress) != nil {
 				continue
 			}
 			contracts.Add(logContractAddress)
@@ -696,6 +705,12 @@ func (bs *BlockScanner) ExtractTransaction(tx *BlockTransaction) ExtractResult {
 	// 提取智能合约交易单
 	bs.extractSmartContractTransaction(tx, &result)
 
+	// 提取扫描地址的ERC20授权
+	bs.extractERC20Approval(tx, &result)
+
+	// 标记被替换的交易
+	bs.extractReplacement(tx, &result)
+
 	return result
 }
 
@@ -1013,14 +1028,10 @@ func (bs *BlockScanner) extractERC20Transaction(tx *BlockTransaction, contractAd
 	if logContract == nil {
 		if bs.wm.Config.DetectUnknownContracts == 1 {
 			// 读取缓存是否已记录合约信息
-			bs.RLock()
-			logContract = bs.logContractsMap[contractAddress]
-			bs.RUnlock()
+			logContract = bs.getLogContract(contractAddress)
 			if logContract == nil {
 				logContract, _ = bs.wm.GetSmartContractDecoder().GetTokenMetadata(contractAddress)
-				bs.Lock()
-				bs.logContractsMap[contractAddress] = logContract
-				bs.Unlock()
+				bs.logContracts.Add(contractAddress, logContract)
 			}
 		} else {
 			logContract = &openwallet.SmartContract{
@@ -1204,9 +1215,7 @@ func (bs *BlockScanner) extractSmartContractTransaction(tx *BlockTransaction, re
 			eventName          string
 			logJSON            string
 		)
-		bs.RLock()
-		logContract = bs.logContractsMap[logContractAddress]
-		bs.RUnlock()
+		logContract = bs.getLogContract(logContractAddress)
 		//合约信息不在内存，查找外部数据源
 		if logContract == nil {
 			logTargetResult := tx.FilterFunc(openwallet.ScanTargetParam{
@@ -1221,6 +1230,8 @@ func (bs *BlockScanner) extractSmartContractTransaction(tx *BlockTransaction, re
 					return
 				}
 				logContract = logContractExisted
+				//记录外部提供的合约ABI，通用代币ABI不需要记录
+				bs.wm.saveContractABI(logContractAddress, logContract.GetABI())
 			} else {
 				// DetectUnknownContracts = 1, 开启探测未知合约
 				if bs.wm.Config.DetectUnknownContracts == 1 {
@@ -1229,6 +1240,15 @@ func (bs *BlockScanner) extractSmartContractTransaction(tx *BlockTransaction, re
 				}
 			}
 
+			//代理合约优先使用逻辑合约的ABI
+			if logContract != nil {
+				logContract = bs.resolveProxyContractABI(tx, logContract)
+			}
+
+			//合约没有ABI，从本地ABI注册表查找
+			if logContract == nil || len(logContract.GetABI()) == 0 {
+				logContract = bs.wm.resolveContractWithABI(logContractAddress, logContract)
+			}
 		}
 
 		//没有纪录ABI，不处理提取
@@ -1249,6 +1269,10 @@ func (bs *BlockScanner) extractSmartContractTransaction(tx *BlockTransaction, re
 				_, eventName, logJSON, _ = bs.wm.DecodeReceiptLogResult(ERC1155_ABI, *log)
 				if len(eventName) == 0 {
 					_, eventName, logJSON, _ = bs.wm.DecodeReceiptLogResult(ERC20_ABI, *log)
+					if len(eventName) == 0 {
+						//通过签名库解码未知事件
+						_, eventName, logJSON, _ = bs.wm.DecodeLogBySignature(*log)
+					}
 					if len(eventName) == 0 {
 						continue
 					}
@@ -1257,9 +1281,7 @@ func (bs *BlockScanner) extractSmartContractTransaction(tx *BlockTransaction, re
 			//bs.wm.Log.Debugf("Found a contract that looks like ERC721 or ERC1155 or ERC20, event name: %v", eventName)
 		} else {
 			//解析
-			bs.Lock()
-			bs.logContractsMap[logContractAddress] = logContract
-			bs.Unlock()
+			bs.logContracts.Add(logContractAddress, logContract)
 			abiInstance, logErr := abi.JSON(strings.NewReader(logContract.GetABI()))
 			if logErr != nil {
 				bs.wm.Log.Errorf("abi decode json failed, err: %v", logErr)
@@ -17,6 +17,7 @@ package quorum
 import (
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/common/file"
+	"github.com/blocktree/quorum-adapter/quorum_rpc"
 	"math/big"
 	"path/filepath"
 	"strings"
@@ -47,22 +48,76 @@ type WalletConfig struct {
 	FixGasPrice *big.Int
 	//补偿gasPrice值
 	OffsetsGasPrice *big.Int
-	//nonce计算方式, 0: auto-increment nonce, 1: latest nonce
+	//nonce计算方式, 0: 本地记录已广播的nonce, 1: 只使用节点pending nonce
 	NonceComputeMode int64
-	//Broadcast node RPC API
+	//Broadcast node RPC API, 多个节点用逗号分隔
 	BroadcastAPI string
+	//多个广播节点的发送策略, parallel: 并行发送, sequential: 逐个发送
+	BroadcastPolicy string
 	// Use QuickNode Single Flight RPC
 	UseQNSingleFlightRPC int64
 	// Detect unknown contracts
 	DetectUnknownContracts int64
 	// 是否用Moralis解析区块
 	UseMoralisAPIParseBlock int64
+	// 广播前模拟执行交易, 0: 关闭, 1: 开启
+	SimulateBeforeBroadcast int64
+	// 内存缓存ABI数量
+	ABICacheSize int64
+	// 预加载ABI文件目录
+	ABIPreloadDir string
+	// 导入事件和方法签名文件
+	SignatureDBFile string
+	// NFT元数据IPFS网关
+	NFTIPFSGateway string
+	// NFT元数据Arweave网关
+	NFTArweaveGateway string
+	// NFT元数据最大字节数
+	NFTMetadataMaxSize int64
+	// 查询日志起始区块，-1: 未设置
+	LogScanStartBlock int64
+	// 分段查询日志的区块范围
+	LogScanBlockRange int64
+	// Multicall3合约地址
+	MulticallAddress string
+	// 合约能力缓存有效期，秒
+	ContractCapabilityTTL int64
+	// 替换交易手续费至少提高的百分比
+	ReplacementPriceBump int64
+	// nonce预留有效期，秒，超时未广播自动释放
+	NonceReservationTTL int64
+	// 是否记录已广播交易日志，0: 不记录，1: 记录
+	EnableTxJournal int64
+	// 检查已广播交易的间隔，秒，0: 不启动重新广播
+	TxRebroadcastInterval int64
+	// 交易不在节点交易池多久后重新广播，秒
+	TxRebroadcastTimeout int64
+	// 交易提交多久后仍未上链标记为丢弃，秒
+	TxDropTimeout int64
+	// 等待交易结果时需要的确认数，区块重组只在达到确认数前检测，检测重组需要设置为2以上
+	AwaitConfirmations uint64
+	// 多个接收方批量转账使用的disperse合约地址
+	DisperseAddress string
 }
 
 func NewConfig(symbol string) *WalletConfig {
 	c := WalletConfig{}
 	c.Symbol = symbol
 	c.CurveType = CurveType
+	c.BroadcastPolicy = quorum_rpc.BroadcastPolicyParallel
+	c.NFTIPFSGateway = DefaultIPFSGateway
+	c.NFTArweaveGateway = DefaultArweaveGateway
+	c.NFTMetadataMaxSize = DefaultNFTMetadataMaxSize
+	c.MulticallAddress = DefaultMulticallAddress
+	c.LogScanStartBlock = -1
+	c.LogScanBlockRange = DefaultLogScanBlockRange
+	c.ContractCapabilityTTL = DefaultContractCapabilityTTL
+	c.ReplacementPriceBump = DefaultReplacementPriceBump
+	c.NonceReservationTTL = DefaultNonceReservationTTL
+	c.TxRebroadcastInterval = DefaultTxRebroadcastInterval
+	c.TxRebroadcastTimeout = DefaultTxRebroadcastTimeout
+	c.TxDropTimeout = DefaultTxDropTimeout
+	c.AwaitConfirmations = DefaultAwaitConfirmations
 	return &c
 }
 
@@ -0,0 +1,327 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/quorum-adapter/quorum_rpc"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// 默认合约能力缓存有效期，秒
+	DefaultContractCapabilityTTL = 24 * 60 * 60
+
+	contractCapabilityCacheSize   = 10000
+	contractCapabilityAddressDir  = "address"
+	contractCapabilityCodeHashDir = "codehash"
+
+	// ERC721 接口ID
+	ERC721InterfaceID = "0x80ac58cd"
+	// ERC1155 接口ID
+	ERC1155InterfaceID = "0xd9b67a26"
+)
+
+// ContractInfo LoadContractInfo查询到的合约信息
+type ContractInfo struct {
+	Valid    bool   `json:"valid"` //false表示不是支持的合约，LoadContractInfo返回nil
+	Protocol string `json:"protocol"`
+	ERC20    bool   `json:"erc20"`
+	Decimals uint64 `json:"decimals"`
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+	Royalty  bool   `json:"royalty"` //NFT合约是否支持ERC2981版税
+
+	Implementation string `json:"implementation,omitempty"` //代理合约的逻辑合约地址
+}
+
+// ContractCapability 合约能力，记录ERC165检查结果和代币信息
+type ContractCapability struct {
+	Address    string          `json:"address,omitempty"`
+	CodeHash   string          `json:"codeHash,omitempty"`
+	Protocol   string          `json:"protocol"`       //SupportsInterface的结果，为空表示未检查
+	Interfaces map[string]bool `json:"interfaces"`     //ERC165接口ID是否支持
+	Info       *ContractInfo   `json:"info,omitempty"` //为nil表示未加载
+	UpdatedAt  int64           `json:"updatedAt"`
+}
+
+func (c *ContractCapability) clone() *ContractCapability {
+	cp := *c
+	cp.Interfaces = make(map[string]bool, len(c.Interfaces))
+	for k, v := range c.Interfaces {
+		cp.Interfaces[k] = v
+	}
+	if c.Info != nil {
+		info := *c.Info
+		cp.Info = &info
+	}
+	return &cp
+}
+
+// ContractCapabilityCache 合约能力缓存，按合约地址和代码hash持久化到文件，过期后重新检查
+type ContractCapabilityCache struct {
+	dir   string //为空则只缓存在内存
+	ttl   time.Duration
+	cache *lruCache
+}
+
+// NewContractCapabilityCache 创建合约能力缓存，dir为空则不持久化
+func NewContractCapabilityCache(dir string, ttl time.Duration) (*ContractCapabilityCache, error) {
+
+	if len(dir) > 0 {
+		for _, sub := range []string{contractCapabilityAddressDir, contractCapabilityCodeHashDir} {
+			if err := os.MkdirAll(filepath.Join(dir, sub), os.ModePerm); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	c := &ContractCapabilityCache{
+		dir:   dir,
+		ttl:   ttl,
+		cache: newLRUCache(contractCapabilityCacheSize, ttl),
+	}
+	return c, nil
+}
+
+// GetByAddress 通过合约地址获取合约能力
+func (c *ContractCapabilityCache) GetByAddress(address string) *ContractCapability {
+	return c.get(contractCapabilityAddressDir, strings.ToLower(address))
+}
+
+// GetByCodeHash 通过代码hash获取合约能力，只包含ERC165检查结果
+func (c *ContractCapabilityCache) GetByCodeHash(codeHash string) *ContractCapability {
+	return c.get(contractCapabilityCodeHashDir, strings.ToLower(codeHash))
+}
+
+// Save 保存合约能力，shareCodeHash为true时同时按代码hash保存ERC165检查结果
+func (c *ContractCapabilityCache) Save(capability *ContractCapability, shareCodeHash bool) {
+
+	capability = capability.clone()
+	capability.Address = strings.ToLower(capability.Address)
+	capability.CodeHash = strings.ToLower(capability.CodeHash)
+	capability.UpdatedAt = time.Now().Unix()
+
+	if len(capability.Address) > 0 {
+		c.put(contractCapabilityAddressDir, capability.Address, capability)
+	}
+	if shareCodeHash && len(capability.CodeHash) > 0 {
+		//代币信息与合约地址相关，不按代码hash共享
+		shared := &ContractCapability{
+			CodeHash:   capability.CodeHash,
+			Protocol:   capability.Protocol,
+			Interfaces: capability.Interfaces,
+			UpdatedAt:  capability.UpdatedAt,
+		}
+		c.put(contractCapabilityCodeHashDir, capability.CodeHash, shared)
+	}
+}
+
+// Remove 删除合约地址的缓存
+func (c *ContractCapabilityCache) Remove(address string) {
+	address = strings.ToLower(address)
+	c.cache.Remove(contractCapabilityAddressDir + "/" + address)
+	if len(c.dir) > 0 {
+		os.Remove(filepath.Join(c.dir, contractCapabilityAddressDir, address+".json"))
+	}
+}
+
+func (c *ContractCapabilityCache) get(sub, key string) *ContractCapability {
+
+	cacheKey := sub + "/" + key
+	if v, ok := c.cache.Get(cacheKey); ok {
+		return v.(*ContractCapability).clone()
+	}
+	if len(c.dir) == 0 {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(c.dir, sub, key+".json"))
+	if err != nil {
+		return nil
+	}
+	var capability ContractCapability
+	if err = json.Unmarshal(content, &capability); err != nil {
+		return nil
+	}
+	//文件记录已过期
+	if c.ttl > 0 && time.Since(time.Unix(capability.UpdatedAt, 0)) > c.ttl {
+		return nil
+	}
+	if capability.Interfaces == nil {
+		capability.Interfaces = make(map[string]bool)
+	}
+	c.cache.Add(cacheKey, &capability)
+	return capability.clone()
+}
+
+func (c *ContractCapabilityCache) put(sub, key string, capability *ContractCapability) {
+	c.cache.Add(sub+"/"+key, capability)
+	if len(c.dir) == 0 {
+		return
+	}
+	content, err := json.Marshal(capability)
+	if err != nil {
+		log.Errorf("marshal contract capability %s failed, err: %v", key, err)
+		return
+	}
+	if err = writeFileAtomic(filepath.Join(c.dir, sub, key+".json"), content); err != nil {
+		log.Errorf("save contract capability %s failed, err: %v", key, err)
+	}
+}
+
+// getContractCapability 获取合约地址记录的合约能力，没有记录返回空的合约能力
+func (wm *WalletManager) getContractCapability(address string) *ContractCapability {
+
+	address = strings.ToLower(address)
+	if capability := wm.ContractCapabilities.GetByAddress(address); capability != nil {
+		return capability
+	}
+
+	return &ContractCapability{
+		Address:    address,
+		Interfaces: make(map[string]bool),
+	}
+}
+
+// loadSharedCapability 需要ERC165检查结果时才查询代码hash，使用相同代码合约的检查结果。
+// 代码hash记录在地址缓存中，每个地址只查询一次
+func (wm *WalletManager) loadSharedCapability(capability *ContractCapability) {
+	if len(capability.CodeHash) > 0 {
+		return
+	}
+	codeHash, err := wm.GetCodeHash(capability.Address)
+	if err != nil || len(codeHash) == 0 {
+		return
+	}
+	capability.CodeHash = codeHash
+	if shared := wm.ContractCapabilities.GetByCodeHash(codeHash); shared != nil {
+		if len(capability.Protocol) == 0 {
+			capability.Protocol = shared.Protocol
+		}
+		for id, support := range shared.Interfaces {
+			if _, exist := capability.Interfaces[id]; !exist {
+				capability.Interfaces[id] = support
+			}
+		}
+	}
+	wm.ContractCapabilities.Save(capability, false)
+}
+
+// saveContractCapability 保存合约能力，代理合约的ERC165结果取决于逻辑合约，不按代码hash共享
+func (wm *WalletManager) saveContractCapability(capability *ContractCapability) {
+	shareCodeHash := false
+	if len(capability.CodeHash) > 0 {
+		if info := capability.Info; info != nil && info.Valid {
+			//合约信息已记录代理检测结果
+			shareCodeHash = len(info.Implementation) == 0
+		} else {
+			proxy, err := wm.DetectProxyContract(capability.Address)
+			shareCodeHash = err == nil && proxy == nil
+		}
+	}
+	wm.ContractCapabilities.Save(capability, shareCodeHash)
+}
+
+// SupportsInterfaceID 合约是否支持ERC165接口，结果会被缓存，调用失败返回false且不缓存
+func (wm *WalletManager) SupportsInterfaceID(contractAddr string, interfaceID string) bool {
+
+	capability := wm.getContractCapability(contractAddr)
+	if support, ok := capability.Interfaces[interfaceID]; ok {
+		return support
+	}
+	wm.loadSharedCapability(capability)
+	if support, ok := capability.Interfaces[interfaceID]; ok {
+		return support
+	}
+
+	support, err := wm.callSupportsInterface(contractAddr, interfaceID)
+	if err != nil {
+		return false
+	}
+	capability.Interfaces[interfaceID] = support
+	wm.saveContractCapability(capability)
+	return support
+}
+
+// callSupportsInterface 调用supportsInterface，合约回滚视为不支持，网络错误返回error
+func (wm *WalletManager) callSupportsInterface(contractAddr string, interfaceID string) (bool, error) {
+	result, reverted, err := wm.callABIResult(contractAddr, ERC721_ABI, "supportsInterface", interfaceID)
+	if err != nil {
+		if reverted {
+			return false, nil
+		}
+		return false, err
+	}
+	support, ok := result[""].(bool)
+	return ok && support, nil
+}
+
+// callABIResult 调用合约方法，reverted为true表示合约回滚或返回值无法解析，结果可缓存
+func (wm *WalletManager) callABIResult(contractAddr string, abiInstance abi.ABI, abiParam ...string) (map[string]interface{}, bool, error) {
+
+	methodName := ""
+	if len(abiParam) > 0 {
+		methodName = abiParam[0]
+	}
+
+	data, err := wm.EncodeABIParam(abiInstance, abiParam...)
+	if err != nil {
+		return nil, false, err
+	}
+	callMsg := CallMsg{
+		From:  ethcom.HexToAddress("0x00"),
+		To:    ethcom.HexToAddress(wm.CustomAddressDecodeFunc(contractAddr)),
+		Data:  data,
+		Value: big.NewInt(0),
+	}
+	result, err := wm.EthCall(callMsg, "latest")
+	if err != nil {
+		return nil, quorum_rpc.IsExecutionReverted(err), err
+	}
+
+	rMap, _, err := wm.DecodeABIResult(abiInstance, methodName, result)
+	if err != nil {
+		return nil, true, err
+	}
+	return rMap, false, nil
+}
+
+// GetContractInfo 获取LoadContractInfo记录的合约信息，未加载或不是支持的合约返回nil
+func (wm *WalletManager) GetContractInfo(address string) *ContractInfo {
+	info := wm.getContractCapability(address).Info
+	if info == nil || !info.Valid {
+		return nil
+	}
+	cp := *info
+	return &cp
+}
+
+// saveContractInfo 记录LoadContractInfo的结果
+func (wm *WalletManager) saveContractInfo(address string, info *ContractInfo) {
+	capability := wm.getContractCapability(address)
+	capability.Info = info
+	wm.saveContractCapability(capability)
+}
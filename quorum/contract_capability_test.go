@@ -0,0 +1,285 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+func TestContractCapabilityCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "capability")
+	cache, err := NewContractCapabilityCache(dir, time.Hour)
+	if err != nil {
+		t.Errorf("NewContractCapabilityCache error: %v", err)
+		return
+	}
+
+	address := "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"
+	codeHash := "0x1111111111111111111111111111111111111111111111111111111111111111"
+	cache.Save(&ContractCapability{
+		Address:    address,
+		CodeHash:   codeHash,
+		Protocol:   openwallet.InterfaceTypeERC721,
+		Interfaces: map[string]bool{ERC721InterfaceID: true},
+		Info:       &ContractInfo{Valid: true, Protocol: openwallet.InterfaceTypeERC721, Token: "BAYC"},
+	}, true)
+
+	//新实例从文件加载
+	reloaded, _ := NewContractCapabilityCache(dir, time.Hour)
+	capability := reloaded.GetByAddress(address)
+	if capability == nil || capability.Protocol != openwallet.InterfaceTypeERC721 || capability.Info == nil || capability.Info.Token != "BAYC" {
+		t.Errorf("capability by address unexpected: %+v", capability)
+		return
+	}
+	//按代码hash只共享ERC165结果
+	shared := reloaded.GetByCodeHash(codeHash)
+	if shared == nil || !shared.Interfaces[ERC721InterfaceID] || shared.Info != nil {
+		t.Errorf("capability by code hash unexpected: %+v", shared)
+		return
+	}
+
+	//返回的是副本，修改不影响缓存
+	capability.Interfaces[ERC1155InterfaceID] = true
+	if _, ok := reloaded.GetByAddress(address).Interfaces[ERC1155InterfaceID]; ok {
+		t.Errorf("cached capability should not be modified")
+	}
+
+	//文件原子写入，不留下临时文件
+	files, _ := ioutil.ReadDir(filepath.Join(dir, contractCapabilityAddressDir))
+	if len(files) != 1 {
+		t.Errorf("capability files: %d, expected: 1", len(files))
+	}
+
+	//过期的文件记录不再使用
+	capability.UpdatedAt = time.Now().Add(-2 * time.Hour).Unix()
+	content, _ := json.Marshal(capability)
+	ioutil.WriteFile(filepath.Join(dir, contractCapabilityAddressDir, capability.Address+".json"), content, 0644)
+	expired, _ := NewContractCapabilityCache(dir, time.Hour)
+	if expired.GetByAddress(address) != nil {
+		t.Errorf("expired capability should be ignored")
+	}
+}
+
+func TestWalletManager_SupportsInterface_CodeHash(t *testing.T) {
+
+	var (
+		mu    sync.Mutex
+		calls = make(map[string]int)
+	)
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[method]++
+		switch method {
+		case "eth_call":
+			//只支持ERC721
+			return testRPCResult(`"0x0000000000000000000000000000000000000000000000000000000000000001"`)
+		case "eth_getCode":
+			return testRPCResult(`"0x6080"`)
+		case "eth_getStorageAt":
+			return testRPCResult(`"0x0000000000000000000000000000000000000000000000000000000000000000"`)
+		default:
+			return ""
+		}
+	})
+	first := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+	second := "0x60e4d786628fea6478f785a6d7e704777c86a7c6"
+
+	//只读取合约信息不查询代码hash
+	wm.GetContractInfo(first)
+	if calls["eth_getCode"] != 0 {
+		t.Errorf("contract info lookup should not query code")
+	}
+
+	if wm.SupportsInterface(first) != openwallet.InterfaceTypeERC721 {
+		t.Errorf("first contract should support ERC721")
+	}
+	if calls["eth_getCode"] != 1 || calls["eth_call"] != 1 || calls["eth_getStorageAt"] != 3 {
+		t.Errorf("cold SupportsInterface calls unexpected: %v", calls)
+	}
+
+	//相同代码的合约使用代码hash共享的结果，已缓存的地址不再查询
+	if wm.SupportsInterface(second) != openwallet.InterfaceTypeERC721 || wm.SupportsInterface(first) != openwallet.InterfaceTypeERC721 {
+		t.Errorf("contracts with the same code should share the result")
+	}
+	wm.SupportsInterface(second)
+	if calls["eth_getCode"] != 2 || calls["eth_call"] != 1 || calls["eth_getStorageAt"] != 3 {
+		t.Errorf("cached SupportsInterface calls unexpected: %v", calls)
+	}
+}
+
+func TestWalletManager_SupportsInterfaceID_TransientError(t *testing.T) {
+
+	var (
+		mu           sync.Mutex
+		callResponse string
+	)
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch method {
+		case "eth_call":
+			return callResponse
+		case "eth_getCode":
+			return testRPCResult(`"0x6080"`)
+		case "eth_getStorageAt":
+			return testRPCResult(`"0x0000000000000000000000000000000000000000000000000000000000000000"`)
+		default:
+			return ""
+		}
+	})
+	address := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+
+	//限流等节点错误不是回滚，不缓存结果
+	callResponse = `{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`
+	if wm.SupportsInterfaceID(address, ERC721InterfaceID) {
+		t.Errorf("supportsInterface should be false when node errors")
+	}
+	if _, cached := wm.getContractCapability(address).Interfaces[ERC721InterfaceID]; cached {
+		t.Errorf("node error should not be cached")
+	}
+
+	//合约回滚视为不支持并缓存
+	callResponse = `{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted"}}`
+	wm.SupportsInterfaceID(address, ERC721InterfaceID)
+	if support, cached := wm.getContractCapability(address).Interfaces[ERC721InterfaceID]; !cached || support {
+		t.Errorf("revert should be cached as not supported")
+	}
+}
+
+func TestWalletManager_DetectProxyContract_Cache(t *testing.T) {
+
+	var (
+		mu           sync.Mutex
+		storageCalls int
+	)
+	proxyAddress := "0x87870bca3f3fd6335c3f4ce8392d69350b4fa4e2"
+	implementation := "0xc13e21b648a5ee794902342038ff3adab66be987"
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch method {
+		case "eth_getStorageAt":
+			storageCalls++
+			if params[0] == proxyAddress && params[1] == EIP1967ImplementationSlot {
+				return testRPCResult(`"0x000000000000000000000000c13e21b648a5ee794902342038ff3adab66be987"`)
+			}
+			return testRPCResult(`"0x0000000000000000000000000000000000000000000000000000000000000000"`)
+		case "eth_call":
+			//supportsInterface无法解析，decimals返回18
+			return testRPCResult(`"0x0000000000000000000000000000000000000000000000000000000000000012"`)
+		default:
+			return ""
+		}
+	})
+	wm.proxyContracts = newLRUCache(10, 50*time.Millisecond)
+	other := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+
+	//代理合约和非代理合约的检测结果都会缓存
+	for i := 0; i < 2; i++ {
+		if wm.GetProxyImplementation(proxyAddress) != implementation {
+			t.Errorf("proxy implementation should be %s", implementation)
+		}
+		if wm.GetProxyImplementation(other) != "" {
+			t.Errorf("non proxy contract should not have implementation")
+		}
+	}
+	if storageCalls != 4 {
+		t.Errorf("storage calls: %d, expected: 4", storageCalls)
+	}
+
+	//过期后重新检测
+	time.Sleep(100 * time.Millisecond)
+	wm.GetProxyImplementation(other)
+	if storageCalls != 7 {
+		t.Errorf("storage calls after expiry: %d, expected: 7", storageCalls)
+	}
+
+	//合约信息记录逻辑合约地址
+	if contract := wm.LoadContractInfo(proxyAddress); contract == nil {
+		t.Errorf("proxy token contract should be loaded")
+		return
+	}
+	if info := wm.getContractCapability(proxyAddress).Info; info == nil || info.Implementation != implementation {
+		t.Errorf("contract info should record the implementation address")
+	}
+	if wm.contractImplementation(proxyAddress) != implementation {
+		t.Errorf("contract implementation should be read from contract info")
+	}
+
+	//通用代币ABI替换为注册表中的逻辑合约ABI
+	implABI := `[{"anonymous":false,"inputs":[{"indexed":true,"name":"user","type":"address"}],"name":"Supply","type":"event"}]`
+	wm.ABIRegistry, _ = NewABIRegistry(t.TempDir(), 0)
+	wm.ABIRegistry.Save(implementation, "", implABI)
+	tx := &BlockTransaction{FilterFunc: func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		return openwallet.ScanTargetResult{}
+	}}
+	bs := NewBlockScanner(wm)
+	resolved := bs.resolveProxyContractABI(tx, wm.LoadContractInfo(proxyAddress))
+	if resolved.GetABI() != implABI {
+		t.Errorf("generic abi should be replaced by implementation abi, got: %s", resolved.GetABI())
+	}
+
+	//代理合约自身的ABI与逻辑合约ABI合并
+	proxyContract := &openwallet.SmartContract{Address: proxyAddress}
+	proxyContract.SetABI(`[{"anonymous":false,"inputs":[{"indexed":true,"name":"implementation","type":"address"}],"name":"Upgraded","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"name":"user","type":"address"}],"name":"Supply","type":"event"}]`)
+	merged, _ := abi.JSON(strings.NewReader(bs.resolveProxyContractABI(tx, proxyContract).GetABI()))
+	if len(merged.Events) != 2 {
+		t.Errorf("merged abi events: %d, expected: 2", len(merged.Events))
+	}
+}
+
+func TestWalletManager_LoadContractInfo_Royalty(t *testing.T) {
+
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		switch method {
+		case "eth_call":
+			data, _ := params[0].(map[string]interface{})["data"].(string)
+			//只支持ERC721和ERC2981接口，其余方法回滚
+			if strings.HasPrefix(data, "0x01ffc9a7") {
+				if strings.HasPrefix(data[10:], "80ac58cd") || strings.HasPrefix(data[10:], "2a55205a") {
+					return testRPCResult(`"0x0000000000000000000000000000000000000000000000000000000000000001"`)
+				}
+				return testRPCResult(`"0x0000000000000000000000000000000000000000000000000000000000000000"`)
+			}
+			return testRPCError(3, "execution reverted")
+		case "eth_getStorageAt":
+			return testRPCResult(`"0x0000000000000000000000000000000000000000000000000000000000000000"`)
+		default:
+			return ""
+		}
+	})
+	address := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+
+	if contract := wm.LoadContractInfo(address); contract == nil || contract.Protocol != openwallet.InterfaceTypeERC721 {
+		t.Errorf("ERC721 contract should be loaded")
+		return
+	}
+	//缓存的合约信息保留版税支持结果
+	info := wm.GetContractInfo(address)
+	if info == nil || !info.Royalty {
+		t.Errorf("contract info should record ERC2981 support")
+	}
+}
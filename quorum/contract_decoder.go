@@ -16,10 +16,10 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/tidwall/gjson"
 	"math/big"
 	"strconv"
 	"strings"
-	"time"
 )
 
 type EthContractDecoder struct {
@@ -162,6 +162,9 @@ func (decoder *EthContractDecoder) EncodeRawTransactionCallMsg(wrapper openwalle
 		return &callMsg, nil, nil
 	} else {
 		abiJSON := rawTx.Coin.Contract.GetABI()
+		if len(abiJSON) == 0 {
+			abiJSON = decoder.wm.ResolveContractABI(decoder.wm.CustomAddressDecodeFunc(rawTx.Coin.Contract.Address))
+		}
 		if len(abiJSON) == 0 {
 			return nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "abi json is empty")
 		}
@@ -220,7 +223,24 @@ func (decoder *EthContractDecoder) CallSmartContractABI(wrapper openwallet.Walle
 		Method: methodName,
 	}
 
-	result, err := decoder.wm.EthCall(*callMsg, "latest")
+	//扩展参数：区块选择，from覆盖，gas，状态覆盖
+	extParam := gjson.Parse(rawTx.ExtParam)
+	callOpts, optErr := decoder.wm.parseEthCallOptions(extParam)
+	if optErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "ext param invalid, err: %v", optErr)
+	}
+	if from := extParam.Get("from").String(); len(from) > 0 {
+		callMsg.From = ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(from))
+	}
+	if gas := extParam.Get("gas"); gas.Exists() {
+		gasLimit, gasErr := parseNumParam(gas.String())
+		if gasErr != nil {
+			return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "ext param gas invalid, err: %v", gasErr)
+		}
+		callMsg.Gas = gasLimit.Uint64()
+	}
+
+	result, err := decoder.wm.EthCallWithOptions(*callMsg, callOpts)
 	if err != nil {
 		callResult.Status = openwallet.SmartContractCallResultStatusFail
 		callResult.Exception = err.Error()
@@ -304,7 +324,10 @@ func (decoder *EthContractDecoder) CreateSmartContractRawTransaction(wrapper ope
 		return openwallet.NewError(openwallet.ErrAccountNotAddress, err.Error())
 	}
 
-	nonce := decoder.wm.GetAddressNonce(wrapper, strings.ToLower(callMsg.From.String()))
+	nonce, reserved, err := decoder.wm.selectTxNonce(strings.ToLower(callMsg.From.String()), gjson.Parse(rawTx.ExtParam), 1)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrNonceInvaild, "reserve address nonce failed, err: %v", err)
+	}
 	signer := types.NewEIP155Signer(big.NewInt(int64(decoder.wm.Config.ChainID)))
 	gasLimit := fee.GasLimit.Uint64()
 
@@ -315,6 +338,7 @@ func (decoder *EthContractDecoder) CreateSmartContractRawTransaction(wrapper ope
 	rawHex, err := rlp.EncodeToBytes(tx)
 	if err != nil {
 		decoder.wm.Log.Error("Transaction RLP encode failed, err:", err)
+		decoder.wm.releaseTxNonce(strings.ToLower(callMsg.From.String()), nonce, 1, reserved)
 		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
 	}
 
@@ -379,6 +403,15 @@ func (decoder *EthContractDecoder) SubmitSmartContractRawTransaction(wrapper ope
 		return nil, openwallet.Errorf(openwallet.ErrSubmitRawSmartContractTransactionFailed, "tx with signature failed. ")
 	}
 
+	//广播前模拟执行，执行失败不广播
+	if decoder.wm.needSimulate(gjson.Parse(rawTx.ExtParam)) {
+		err = decoder.wm.SimulateTransaction(tx)
+		if err != nil {
+			decoder.wm.Log.Std.Error("simulate tx failed, err=%v", err)
+			return nil, openwallet.Errorf(openwallet.ErrSubmitRawSmartContractTransactionFailed, "simulate tx failed. %v", err)
+		}
+	}
+
 	rawTxPara, err := rlp.EncodeToBytes(tx)
 	if err != nil {
 		decoder.wm.Log.Std.Error("encode tx to rlp failed, err=%v ", err)
@@ -388,13 +421,14 @@ func (decoder *EthContractDecoder) SubmitSmartContractRawTransaction(wrapper ope
 	txid, err := decoder.wm.SendRawTransaction(hexutil.Encode(rawTxPara))
 	if err != nil {
 		decoder.wm.Log.Std.Error("sent raw tx faild, err=%v", err)
-		//交易失败重置地址nonce
-		decoder.wm.UpdateAddressNonce(wrapper, from, 0)
+		//交易失败释放预留的nonce
+		decoder.wm.NonceManager.Release(from, tx.Nonce())
 		return nil, openwallet.Errorf(openwallet.ErrSubmitRawSmartContractTransactionFailed, "sent raw tx faild. unexpected error: %v", err)
 	}
 
-	//交易成功，地址nonce+1并记录到缓存
-	decoder.wm.UpdateAddressNonce(wrapper, from, tx.Nonce()+1)
+	//交易成功，记录nonce已使用
+	decoder.wm.NonceManager.Commit(from, tx.Nonce(), txid)
+	decoder.wm.journalTransaction(from, tx, txid)
 
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
@@ -410,6 +444,11 @@ func (decoder *EthContractDecoder) SubmitSmartContractRawTransaction(wrapper ope
 
 	owtx.GenWxID()
 
+	//部署合约交易，上链后返回部署的合约
+	if isDeployRawTransaction(rawTx) {
+		return decoder.submitDeployResult(rawTx, owtx), nil
+	}
+
 	decoder.wm.Log.Infof("rawTx.AwaitResult = %v", rawTx.AwaitResult)
 	//等待出块结果返回交易回执
 	if rawTx.AwaitResult {
@@ -436,48 +475,24 @@ func (decoder *EthContractDecoder) SubmitSmartContractRawTransaction(wrapper ope
 			return openwallet.ScanTargetResult{SourceKey: "", Exist: false, TargetInfo: nil}
 		}
 
-		//默认超时90秒
-		if rawTx.AwaitTimeout == 0 {
-			rawTx.AwaitTimeout = 90
+		//等待交易达到确认数后提取回执
+		if _, awaitErr := decoder.wm.awaitTransaction(owtx.TxID, rawTx.AwaitTimeout); awaitErr != nil {
+			decoder.wm.Log.Errorf("await transaction %s failed, err: %v", owtx.TxID, awaitErr)
+			//交易已广播，标记为待确认，不能当作失败重新发送
+			owtx.Status = TxStatusPending
+			owtx.Reason = fmt.Sprintf("await transaction result failed: %v", awaitErr)
+			return owtx, nil
 		}
 
-		sleepSecond := 2 * time.Second
-
-		//计算过期时间
-		currentServerTime := time.Now()
-		expiredTime := currentServerTime.Add(time.Duration(rawTx.AwaitTimeout) * time.Second)
-
-		//等待交易单报块结果
-		for {
-
-			//当前重试时间
-			currentReDoTime := time.Now()
-
-			//decoder.wm.Log.Debugf("currentReDoTime = %s", currentReDoTime.String())
-			//decoder.wm.Log.Debugf("expiredTime = %s", expiredTime.String())
-
-			//超时终止
-			if currentReDoTime.Unix() >= expiredTime.Unix() {
-				break
-			}
-
-			_, contractResult, extractErr := bs.ExtractTransactionAndReceiptData(owtx.TxID, scanTargetFunc)
-			if extractErr != nil {
-				decoder.wm.Log.Errorf("ExtractTransactionAndReceiptData failed, err: %v", extractErr)
-				return owtx, nil
-			}
-
-			//tx := txResult[contract.ContractID]
-			receipt := contractResult[contract.ContractID]
-
-			if receipt != nil {
-				return receipt, nil
-			}
-
-			//等待sleepSecond秒重试
-			time.Sleep(sleepSecond)
+		_, contractResult, extractErr := bs.ExtractTransactionAndReceiptData(owtx.TxID, scanTargetFunc)
+		if extractErr != nil {
+			decoder.wm.Log.Errorf("ExtractTransactionAndReceiptData failed, err: %v", extractErr)
+			return owtx, nil
 		}
 
+		if receipt := contractResult[contract.ContractID]; receipt != nil {
+			return receipt, nil
+		}
 	}
 
 	return owtx, nil
@@ -587,3 +602,124 @@ func (decoder *EthContractDecoder) GetTokenMetadata(contract string) (*openwalle
 
 	return contractInfo, nil
 }
+
+// parseEthCallOptions 解析扩展参数中的eth_call调用选项
+// {"blockNumber":"0x10","blockHash":"0x...","stateOverride":{"0xaddr":{"balance":"0x1","nonce":"0x1","code":"0x...","state":{"0xslot":"0xvalue"},"stateDiff":{}}}}
+func (wm *WalletManager) parseEthCallOptions(extParam gjson.Result) (*EthCallOptions, error) {
+	opts := &EthCallOptions{
+		BlockNumber: extParam.Get("blockNumber").String(),
+		BlockHash:   extParam.Get("blockHash").String(),
+	}
+
+	overrides := extParam.Get("stateOverride")
+	if !overrides.Exists() {
+		return opts, nil
+	}
+	if !overrides.IsObject() {
+		return nil, fmt.Errorf("stateOverride must be an object")
+	}
+
+	opts.StateOverride = make(map[string]*OverrideAccount)
+	var parseErr error
+	overrides.ForEach(func(key, value gjson.Result) bool {
+		//与from，to一致，支持自定义地址格式
+		address := AppendOxToAddress(wm.CustomAddressDecodeFunc(key.String()))
+		account := &OverrideAccount{}
+		if balance := value.Get("balance"); balance.Exists() {
+			b, err := parseNumParam(balance.String())
+			if err != nil {
+				parseErr = fmt.Errorf("%s balance invalid, err: %v", address, err)
+				return false
+			}
+			account.Balance = (*hexutil.Big)(b)
+		}
+		if nonce := value.Get("nonce"); nonce.Exists() {
+			n, err := parseNumParam(nonce.String())
+			if err != nil {
+				parseErr = fmt.Errorf("%s nonce invalid, err: %v", address, err)
+				return false
+			}
+			u := hexutil.Uint64(n.Uint64())
+			account.Nonce = &u
+		}
+		if code := value.Get("code"); code.Exists() {
+			c, err := hexutil.Decode(AppendOxToAddress(code.String()))
+			if err != nil {
+				parseErr = fmt.Errorf("%s code invalid, err: %v", address, err)
+				return false
+			}
+			b := hexutil.Bytes(c)
+			account.Code = &b
+		}
+		if state := value.Get("state"); state.IsObject() {
+			storage, err := parseStorageOverride(state)
+			if err != nil {
+				parseErr = fmt.Errorf("%s state invalid, err: %v", address, err)
+				return false
+			}
+			account.State = storage
+		}
+		if stateDiff := value.Get("stateDiff"); stateDiff.IsObject() {
+			storage, err := parseStorageOverride(stateDiff)
+			if err != nil {
+				parseErr = fmt.Errorf("%s stateDiff invalid, err: %v", address, err)
+				return false
+			}
+			account.StateDiff = storage
+		}
+		if account.State != nil && account.StateDiff != nil {
+			parseErr = fmt.Errorf("%s state and stateDiff can not be set at the same time", address)
+			return false
+		}
+		opts.StateOverride[address] = account
+		return true
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return opts, nil
+}
+
+// parseStorageOverride 解析存储槽覆盖，存储槽和值都必须是0x开头的32字节hex
+func parseStorageOverride(obj gjson.Result) (map[ethcom.Hash]ethcom.Hash, error) {
+	storage := make(map[ethcom.Hash]ethcom.Hash)
+	var parseErr error
+	obj.ForEach(func(key, value gjson.Result) bool {
+		slot, err := parseHash32(key.String())
+		if err != nil {
+			parseErr = fmt.Errorf("slot %s invalid, err: %v", key.String(), err)
+			return false
+		}
+		val, err := parseHash32(value.String())
+		if err != nil {
+			parseErr = fmt.Errorf("slot %s value %s invalid, err: %v", key.String(), value.String(), err)
+			return false
+		}
+		storage[slot] = val
+		return true
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return storage, nil
+}
+
+// parseHash32 解析0x开头的32字节hex，HexToHash会截断或补齐不合法的输入
+func parseHash32(s string) (ethcom.Hash, error) {
+	b, err := hexutil.Decode(s)
+	if err != nil {
+		return ethcom.Hash{}, err
+	}
+	if len(b) != ethcom.HashLength {
+		return ethcom.Hash{}, fmt.Errorf("length %d, expected %d bytes", len(b), ethcom.HashLength)
+	}
+	return ethcom.BytesToHash(b), nil
+}
+
+// parseNumParam 解析10进制或0x开头的16进制数字
+func parseNumParam(param string) (*big.Int, error) {
+	if strings.HasPrefix(param, "0x") {
+		return common.StringValueToBigInt(param, 16)
+	}
+	return common.StringValueToBigInt(param, 10)
+}
@@ -16,8 +16,16 @@
 package quorum
 
 import (
+	"fmt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tidwall/gjson"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -52,3 +60,177 @@ func TestWalletManager_GetTokenMetadata(t *testing.T) {
 	}
 	log.Infof("token metadata: %+v", tokenData)
 }
+
+func TestParseEthCallOptions(t *testing.T) {
+	wm := NewWalletManager()
+	slot := "0x0000000000000000000000000000000000000000000000000000000000000001"
+	value := "0x0000000000000000000000000000000000000000000000000000000000000002"
+	extParam := gjson.Parse(fmt.Sprintf(`{"blockNumber":"1024","stateOverride":{"0x550cdb1020046b3115a4f8ccebddfb28b66beb27":{"balance":"1000000000000000000","code":"0x6080","stateDiff":{"%s":"%s"}}}}`, slot, value))
+	opts, err := wm.parseEthCallOptions(extParam)
+	if err != nil {
+		t.Errorf("parseEthCallOptions unexpected error: %v", err)
+		return
+	}
+	if opts.BlockParam() != "0x400" {
+		t.Errorf("block param expected 0x400, got %v", opts.BlockParam())
+	}
+	account := opts.StateOverride["0x550cdb1020046b3115a4f8ccebddfb28b66beb27"]
+	if account == nil || account.Balance == nil || account.Code == nil || len(account.StateDiff) != 1 {
+		t.Errorf("state override parse failed: %+v", account)
+		return
+	}
+	log.Infof("state override: %+v", account)
+
+	_, err = wm.parseEthCallOptions(gjson.Parse(fmt.Sprintf(`{"stateOverride":{"0x01":{"state":{"%s":"%s"},"stateDiff":{"%s":"%s"}}}}`, slot, value, slot, value)))
+	if err == nil {
+		t.Errorf("state and stateDiff should not be set at the same time")
+	}
+
+	//存储槽和值必须是32字节hex
+	for _, storage := range []string{`{"0x01":"` + value + `"}`, `{"` + slot + `":"0x02"}`, `{"` + slot + `":"0xzz"}`} {
+		_, err = wm.parseEthCallOptions(gjson.Parse(`{"stateOverride":{"0x01":{"state":` + storage + `}}}`))
+		if err == nil {
+			t.Errorf("malformed storage override should be rejected: %s", storage)
+		}
+	}
+
+	//账户地址使用自定义地址解码
+	wm.CustomAddressDecodeFunc = func(address string) string {
+		return strings.Replace(address, "xdc", "0x", 1)
+	}
+	opts, err = wm.parseEthCallOptions(gjson.Parse(`{"stateOverride":{"xdc550cdb1020046b3115a4f8ccebddfb28b66beb27":{"balance":"1"}}}`))
+	if err != nil || opts.StateOverride["0x550cdb1020046b3115a4f8ccebddfb28b66beb27"] == nil {
+		t.Errorf("state override address should be decoded, err: %v", err)
+	}
+}
+
+func TestDecodeRevertReason(t *testing.T) {
+	//Error(string): "not owner"
+	reason := decodeRevertReason(hexutil.MustDecode("0x08c379a0000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000000096e6f74206f776e65720000000000000000000000000000000000000000000000"))
+	if reason != "not owner" {
+		t.Errorf("Error(string) expected 'not owner', got '%s'", reason)
+	}
+	//Panic(uint256): 0x11
+	reason = decodeRevertReason(hexutil.MustDecode("0x4e487b710000000000000000000000000000000000000000000000000000000000000011"))
+	if reason != "panic: arithmetic underflow or overflow (0x11)" {
+		t.Errorf("Panic(uint256) decode unexpected: '%s'", reason)
+	}
+	//自定义错误
+	reason = decodeRevertReason(hexutil.MustDecode("0x82b42900"))
+	if reason != "custom error 0x82b42900" {
+		t.Errorf("custom error decode unexpected: '%s'", reason)
+	}
+}
+
+func TestWalletManager_SimulateTransaction(t *testing.T) {
+
+	var callResult string
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		if method != "eth_call" {
+			return testRPCError(-32601, "method not found")
+		}
+		return callResult
+	})
+	wm.Config.ChainID = 1
+
+	key, _ := crypto.GenerateKey()
+	tx := types.NewTransaction(0, ethcom.HexToAddress("0x1111111111111111111111111111111111111111"), big.NewInt(0), 21000, big.NewInt(1000000000), nil)
+	tx, _ = types.SignTx(tx, types.NewEIP155Signer(big.NewInt(1)), key)
+
+	//节点异常不阻止广播
+	callResult = `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`
+	if err := wm.SimulateTransaction(tx); err != nil {
+		t.Errorf("node error should not block broadcast, err: %v", err)
+	}
+
+	//合约回滚阻止广播
+	callResult = `{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted: not owner","data":"0x08c379a0000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000000096e6f74206f776e65720000000000000000000000000000000000000000000000"}}`
+	err := wm.SimulateTransaction(tx)
+	simErr, ok := err.(*SimulationError)
+	if !ok {
+		t.Errorf("revert should return SimulationError, err: %v", err)
+		return
+	}
+	if simErr.Reason != "not owner" {
+		t.Errorf("revert reason: %s, expected: not owner", simErr.Reason)
+	}
+}
+
+func TestSignatureDB_DecodeCallData(t *testing.T) {
+	db := NewSignatureDB()
+	//transfer(address,uint256)
+	decoded, err := db.DecodeCallData(hexutil.MustDecode("0xa9059cbb000000000000000000000000550cdb1020046b3115a4f8ccebddfb28b66beb2700000000000000000000000000000000000000000000000000000000000003e8"))
+	if err != nil {
+		t.Errorf("DecodeCallData unexpected error: %v", err)
+		return
+	}
+	if decoded.Method != "transfer" || decoded.Signature != "transfer(address,uint256)" {
+		t.Errorf("DecodeCallData unexpected method: %+v", decoded)
+	}
+	log.Infof("decoded: %s %s", decoded.Signature, decoded.ArgsJSON)
+
+	//tuple数组
+	if _, _, err = parseSignature("aggregate((address,bytes)[])"); err != nil {
+		t.Errorf("parseSignature tuple unexpected error: %v", err)
+	}
+	if err = db.Add("broken(uint256"); err == nil {
+		t.Errorf("invalid signature should not be added")
+	}
+}
+
+func TestWalletManager_GetERC20Allowances(t *testing.T) {
+	wm := testNewWalletManager()
+
+	contract := openwallet.SmartContract{
+		Address:  "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+		Symbol:   "ETH",
+		Name:     "USD Coin",
+		Token:    "USDC",
+		Decimals: 6,
+	}
+	owner := "0x47ac0fb4f2d84898e4d9e7b4dab3c24507a6d503"
+	spenders := []string{
+		"0x7a250d5630b4cf539739df2c5dacb4c659f2488d",
+		"0x000000000022d473030f116ddee9f6b43ac78ba3",
+	}
+
+	decoder := wm.ContractDecoder.(*EthContractDecoder)
+	allowances, err := decoder.GetERC20Allowances(contract, owner, spenders...)
+	if err != nil {
+		t.Errorf("GetERC20Allowances unexpected error: %v", err)
+		return
+	}
+	for _, allowance := range allowances {
+		log.Infof("spender: %s, allowance: %s, unlimited: %v", allowance.Spender, allowance.Allowance, allowance.Unlimited)
+	}
+}
+
+func TestTransactionReceipt_ParseApprovalEvent(t *testing.T) {
+	token := ethcom.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+	owner := ethcom.HexToAddress("0x47ac0fb4f2d84898e4d9e7b4dab3c24507a6d503")
+	spender := ethcom.HexToAddress("0x7a250d5630b4cf539739df2c5dacb4c659f2488d")
+	approvalID := ERC20_ABI.Events["Approval"].ID
+
+	receipt := &TransactionReceipt{ETHReceipt: &types.Receipt{Logs: []*types.Log{
+		{
+			Address: token,
+			Topics:  []ethcom.Hash{approvalID, ethcom.BytesToHash(owner.Bytes()), ethcom.BytesToHash(spender.Bytes())},
+			Data:    ethcom.LeftPadBytes(big.NewInt(1000000).Bytes(), 32),
+		},
+		//ERC721 Approval有4个topics，不应解析
+		{
+			Address: token,
+			Topics:  []ethcom.Hash{approvalID, ethcom.BytesToHash(owner.Bytes()), ethcom.BytesToHash(spender.Bytes()), ethcom.BigToHash(big.NewInt(1))},
+		},
+	}}}
+
+	events := receipt.ParseApprovalEvent()
+	approvals := events[strings.ToLower(token.String())]
+	if len(approvals) != 1 {
+		t.Errorf("approval events expected 1, got %d", len(approvals))
+		return
+	}
+	if approvals[0].TokenOwner != strings.ToLower(owner.String()) || approvals[0].TokenSpender != strings.ToLower(spender.String()) || approvals[0].Value.Int64() != 1000000 {
+		t.Errorf("approval event unexpected: %+v", approvals[0])
+	}
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/quorum-adapter/quorum_addrdec"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 扩展参数：部署合约的字节码
+	bytecodeExtParamKey = "bytecode"
+	// 扩展参数：标记为部署合约交易
+	deployExtParamKey = "deploy"
+	// 扩展参数：部署后的合约地址
+	contractAddressExtParamKey = "contractAddress"
+)
+
+// EncodeDeployData 编码部署数据，字节码后拼接ABI编码的构造函数参数
+func (wm *WalletManager) EncodeDeployData(abiJSON, bytecode string, args ...string) ([]byte, error) {
+
+	code, err := hexutil.Decode(AppendOxToAddress(bytecode))
+	if err != nil || len(code) == 0 {
+		return nil, fmt.Errorf("contract bytecode is invalid")
+	}
+
+	if len(abiJSON) == 0 {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("abi json is empty, can not encode constructor arguments")
+		}
+		return code, nil
+	}
+
+	abiInstance, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, err
+	}
+	inputs := abiInstance.Constructor.Inputs
+	if len(inputs) != len(args) {
+		return nil, fmt.Errorf("constructor input arguments is: %d, except is : %d", len(args), len(inputs))
+	}
+	params := make([]interface{}, 0, len(args))
+	for i, input := range inputs {
+		a, convErr := convertStringParamToABIParam(input.Type, args[i])
+		if convErr != nil {
+			return nil, convErr
+		}
+		params = append(params, a)
+	}
+	packed, err := abiInstance.Pack("", params...)
+	if err != nil {
+		return nil, err
+	}
+	return append(code, packed...), nil
+}
+
+// PredictContractAddress 预测地址以nonce通过CREATE部署的合约地址
+func (wm *WalletManager) PredictContractAddress(from string, nonce uint64) (string, error) {
+	address, err := quorum_addrdec.CreateAddress(AppendOxToAddress(wm.CustomAddressDecodeFunc(from)), nonce)
+	if err != nil {
+		return "", err
+	}
+	return wm.CustomAddressEncodeFunc(address), nil
+}
+
+// CreateDeployContractRawTransaction 创建部署合约交易单。
+// 字节码放在扩展参数bytecode，构造函数ABI放在Coin.Contract，构造函数参数按顺序放在ABIParam
+func (decoder *EthContractDecoder) CreateDeployContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) *openwallet.Error {
+
+	extParam := gjson.Parse(rawTx.ExtParam)
+	abiJSON := rawTx.Coin.Contract.GetABI()
+	data, err := decoder.wm.EncodeDeployData(abiJSON, extParam.Get(bytecodeExtParamKey).String(), rawTx.ABIParam...)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	defAddress, getErr := decoder.GetAssetsAccountDefAddress(wrapper, rawTx.Account.AccountID)
+	if getErr != nil {
+		return getErr
+	}
+	from := strings.ToLower(decoder.wm.CustomAddressDecodeFunc(defAddress.Address))
+	amount := common.StringNumToBigIntWithExp(rawTx.Value, decoder.wm.Decimal())
+
+	//to为空估算部署消耗的gas
+	fee, feeErr := decoder.wm.GetTransactionFeeEstimated(from, "", amount, data)
+	if feeErr != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, feeErr.Error())
+	}
+	if rawTx.FeeRate != "" {
+		fee.GasPrice = common.StringNumToBigIntWithExp(rawTx.FeeRate, decoder.wm.Decimal())
+		fee.CalcFee()
+	}
+
+	//检查部署地址是否有足够余额
+	coinBalance, err := decoder.wm.GetAddrBalance(from, "pending")
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+	totalCost := new(big.Int).Add(fee.Fee, amount)
+	if coinBalance.Cmp(totalCost) < 0 {
+		coinBalance := common.BigIntToDecimals(coinBalance, decoder.wm.Decimal())
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "the [%s] balance: %s is not enough to deploy smart contract", rawTx.Coin.Symbol, coinBalance.String())
+	}
+
+	addr, err := wrapper.GetAddress(from)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrAccountNotAddress, err.Error())
+	}
+
+	nonce, reserved, err := decoder.wm.selectTxNonce(from, gjson.Parse(rawTx.ExtParam), 1)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrNonceInvaild, "reserve address nonce failed, err: %v", err)
+	}
+
+	//构建部署交易，to为空
+	tx := types.NewContractCreation(nonce, amount, fee.GasLimit.Uint64(), fee.GasPrice, data)
+	rawHex, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		decoder.wm.Log.Error("Transaction RLP encode failed, err:", err)
+		decoder.wm.releaseTxNonce(from, nonce, 1, reserved)
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+
+	signer := types.NewEIP155Signer(big.NewInt(int64(decoder.wm.Config.ChainID)))
+	msg := signer.Hash(tx)
+	contractAddress, err := decoder.wm.PredictContractAddress(from, nonce)
+	if err != nil {
+		decoder.wm.releaseTxNonce(from, nonce, 1, reserved)
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+
+	ext := make(map[string]interface{})
+	if len(rawTx.ExtParam) > 0 {
+		json.Unmarshal([]byte(rawTx.ExtParam), &ext)
+	}
+	ext[deployExtParamKey] = true
+	ext[contractAddressExtParamKey] = contractAddress
+	extContent, _ := json.Marshal(ext)
+
+	rawTx.Signatures = map[string][]*openwallet.KeySignature{
+		rawTx.Account.AccountID: {
+			&openwallet.KeySignature{
+				EccType: decoder.wm.Config.CurveType,
+				Nonce:   "0x" + strconv.FormatUint(nonce, 16),
+				Address: addr,
+				Message: hex.EncodeToString(msg[:]),
+				RSV:     true,
+			},
+		},
+	}
+	rawTx.Raw = hex.EncodeToString(rawHex)
+	rawTx.RawType = openwallet.TxRawTypeHex
+	rawTx.ExtParam = string(extContent)
+	rawTx.FeeRate = common.BigIntToDecimals(fee.GasPrice, decoder.wm.Decimal()).String()
+	rawTx.Fees = common.BigIntToDecimals(fee.Fee, decoder.wm.Decimal()).String()
+	rawTx.TxFrom = from
+	rawTx.TxTo = contractAddress
+	rawTx.IsBuilt = true
+
+	return nil
+}
+
+// isDeployRawTransaction 是否部署合约交易单
+func isDeployRawTransaction(rawTx *openwallet.SmartContractRawTransaction) bool {
+	return gjson.Parse(rawTx.ExtParam).Get(deployExtParamKey).Bool()
+}
+
+// GetDeployedContract 通过部署交易回执获取合约地址，登记合约ABI并返回合约信息
+func (decoder *EthContractDecoder) GetDeployedContract(txid, abiJSON string) (*openwallet.SmartContract, error) {
+
+	receipt, err := decoder.wm.GetTransactionReceipt(txid)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.ETHReceipt.Status != types.ReceiptStatusSuccessful {
+		return nil, fmt.Errorf("deploy transaction %s is failed", txid)
+	}
+	if receipt.ETHReceipt.ContractAddress == (ethcom.Address{}) {
+		return nil, fmt.Errorf("transaction %s is not a contract deployment", txid)
+	}
+	address := decoder.wm.CustomAddressEncodeFunc(strings.ToLower(receipt.ETHReceipt.ContractAddress.String()))
+
+	if len(abiJSON) > 0 && decoder.wm.ABIRegistry != nil {
+		if saveErr := decoder.wm.ABIRegistry.Save(address, "", abiJSON); saveErr != nil {
+			decoder.wm.Log.Errorf("save contract %s abi failed, err: %v", address, saveErr)
+		}
+	}
+
+	//代币和NFT合约加载代币信息，其他合约按未知协议登记
+	contract := decoder.wm.LoadContractInfo(address)
+	if contract == nil {
+		contract = &openwallet.SmartContract{
+			ContractID: openwallet.GenContractID(decoder.wm.Symbol(), address),
+			Symbol:     decoder.wm.Symbol(),
+			Address:    address,
+			Protocol:   openwallet.InterfaceTypeUnknown,
+		}
+	}
+	if len(abiJSON) > 0 {
+		contract.SetABI(abiJSON)
+	}
+	return contract, nil
+}
+
+// submitDeployResult 部署交易广播后，需要等待结果时返回部署的合约
+func (decoder *EthContractDecoder) submitDeployResult(rawTx *openwallet.SmartContractRawTransaction, owtx *openwallet.SmartContractReceipt) *openwallet.SmartContractReceipt {
+
+	owtx.To = gjson.Parse(rawTx.ExtParam).Get(contractAddressExtParamKey).String()
+	if !rawTx.AwaitResult {
+		return owtx
+	}
+
+	event, err := decoder.wm.awaitTransaction(owtx.TxID, rawTx.AwaitTimeout)
+	if err != nil {
+		decoder.wm.Log.Errorf("await transaction %s failed, err: %v", owtx.TxID, err)
+		owtx.Status = TxStatusPending
+		owtx.Reason = fmt.Sprintf("await transaction result failed: %v", err)
+		return owtx
+	}
+	owtx.BlockHash = event.BlockHash
+	owtx.BlockHeight = event.BlockHeight
+	owtx.ConfirmTime = time.Now().Unix()
+	owtx.RawReceipt = event.Receipt.Raw
+	owtx.Status = txStatus(event.Success)
+	if !event.Success {
+		owtx.Reason = "contract deployment failed"
+		return owtx
+	}
+
+	contract, err := decoder.GetDeployedContract(owtx.TxID, rawTx.Coin.Contract.GetABI())
+	if err != nil {
+		decoder.wm.Log.Errorf("get deployed contract failed, err: %v", err)
+		return owtx
+	}
+	if !strings.EqualFold(contract.Address, owtx.To) {
+		decoder.wm.Log.Warningf("deployed contract address %s is different from predicted %s", contract.Address, owtx.To)
+	}
+	owtx.To = contract.Address
+	rawTx.Coin.IsContract = true
+	rawTx.Coin.ContractID = contract.ContractID
+	rawTx.Coin.Contract = *contract
+	owtx.Coin = rawTx.Coin
+	return owtx
+}
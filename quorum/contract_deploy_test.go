@@ -0,0 +1,64 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestWalletManager_PredictContractAddress(t *testing.T) {
+	wm := NewWalletManager()
+	from := "0x6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0"
+	expected := []string{
+		"0xcd234a471b72ba2f1ccf0a70fcaba648a5eecd8d",
+		"0x343c43a37d37dff08ae8c4a11544c718abb4fcf8",
+		"0xf778b86fa74e846c4f0a1fbd1335fe81c00a0c91",
+	}
+	for nonce, address := range expected {
+		if predicted, _ := wm.PredictContractAddress(from, uint64(nonce)); predicted != address {
+			t.Errorf("nonce %d predicted: %s, expected: %s", nonce, predicted, address)
+		}
+	}
+}
+
+func TestWalletManager_EncodeDeployData(t *testing.T) {
+	wm := NewWalletManager()
+	abiJSON := `[{"inputs":[{"internalType":"string","name":"name","type":"string"},{"internalType":"uint256","name":"supply","type":"uint256"}],"stateMutability":"nonpayable","type":"constructor"}]`
+	bytecode := "0x6080604052"
+
+	data, err := wm.EncodeDeployData(abiJSON, bytecode, "Token", "1000")
+	if err != nil {
+		t.Errorf("EncodeDeployData failed, err: %v", err)
+		return
+	}
+	expected := bytecode +
+		"0000000000000000000000000000000000000000000000000000000000000040" +
+		"00000000000000000000000000000000000000000000000000000000000003e8" +
+		"0000000000000000000000000000000000000000000000000000000000000005" +
+		"546f6b656e000000000000000000000000000000000000000000000000000000"
+	if hexutil.Encode(data) != expected {
+		t.Errorf("deploy data: %s, expected: %s", hexutil.Encode(data), expected)
+	}
+
+	if _, err = wm.EncodeDeployData(abiJSON, bytecode, "Token"); err == nil {
+		t.Errorf("EncodeDeployData should fail with missing constructor arguments")
+	}
+	if _, err = wm.EncodeDeployData("", "0x"); err == nil {
+		t.Errorf("EncodeDeployData should fail with empty bytecode")
+	}
+}
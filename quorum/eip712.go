@@ -0,0 +1,307 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	EIP712_VERSION_ABI_JSON = `[{"inputs":[],"name":"version","outputs":[{"internalType":"string","name":"","type":"string"}],"stateMutability":"view","type":"function"}]`
+
+	// EIP-2612未规定version，大部分合约使用"1"
+	defaultPermitVersion = "1"
+)
+
+var (
+	EIP712_VERSION_ABI, _ = abi.JSON(strings.NewReader(EIP712_VERSION_ABI_JSON))
+
+	// EIP-2612 permit的类型定义
+	erc20PermitTypes = apitypes.Types{
+		"EIP712Domain": {
+			{Name: "name", Type: "string"},
+			{Name: "version", Type: "string"},
+			{Name: "chainId", Type: "uint256"},
+			{Name: "verifyingContract", Type: "address"},
+		},
+		"Permit": {
+			{Name: "owner", Type: "address"},
+			{Name: "spender", Type: "address"},
+			{Name: "value", Type: "uint256"},
+			{Name: "nonce", Type: "uint256"},
+			{Name: "deadline", Type: "uint256"},
+		},
+	}
+)
+
+// TypedDataSignature EIP-712签名结果
+type TypedDataSignature struct {
+	Address   string `json:"address"`   //签名地址
+	Hash      string `json:"hash"`      //签名的摘要，hex
+	Signature string `json:"signature"` //r+s+v，v为27或28，hex带0x
+	R         string `json:"r"`
+	S         string `json:"s"`
+	V         uint8  `json:"v"`
+}
+
+// ERC20Permit EIP-2612 permit签名结果，可用于调用合约的permit方法
+type ERC20Permit struct {
+	Contract  string              `json:"contract"`
+	Owner     string              `json:"owner"`
+	Spender   string              `json:"spender"`
+	Value     string              `json:"value"` //最小单位
+	Nonce     string              `json:"nonce"`
+	Deadline  uint64              `json:"deadline"`
+	TypedData *apitypes.TypedData `json:"typedData"`
+	*TypedDataSignature
+}
+
+// HashTypedData 计算EIP-712摘要，keccak256("\x19\x01" ‖ domainSeparator ‖ hashStruct(message))
+func HashTypedData(typedData *apitypes.TypedData) ([]byte, error) {
+	domainSeparator, err := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
+	if err != nil {
+		return nil, fmt.Errorf("hash EIP712Domain failed, err: %v", err)
+	}
+	messageHash, err := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
+	if err != nil {
+		return nil, fmt.Errorf("hash %s failed, err: %v", typedData.PrimaryType, err)
+	}
+	rawData := []byte(fmt.Sprintf("\x19\x01%s%s", string(domainSeparator), string(messageHash)))
+	return crypto.Keccak256(rawData), nil
+}
+
+// ParseTypedData 解析eth_signTypedData_v4格式的JSON
+func ParseTypedData(typedDataJSON string) (*apitypes.TypedData, error) {
+
+	content := []byte(typedDataJSON)
+	//钱包通常传数字类型的chainId，转为字符串才能解析为HexOrDecimal256
+	if chainID := gjson.GetBytes(content, "domain.chainId"); chainID.Type == gjson.Number {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(content, &fields); err != nil {
+			return nil, fmt.Errorf("typed data json is invalid, err: %v", err)
+		}
+		var domain map[string]json.RawMessage
+		if err := json.Unmarshal(fields["domain"], &domain); err != nil {
+			return nil, fmt.Errorf("typed data domain is invalid, err: %v", err)
+		}
+		domain["chainId"], _ = json.Marshal(chainID.Raw)
+		fields["domain"], _ = json.Marshal(domain)
+		content, _ = json.Marshal(fields)
+	}
+
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal(content, &typedData); err != nil {
+		return nil, fmt.Errorf("typed data json is invalid, err: %v", err)
+	}
+	return &typedData, nil
+}
+
+// SignTypedData 使用地址的HD私钥对EIP-712结构化数据签名
+func (decoder *EthTransactionDecoder) SignTypedData(wrapper openwallet.WalletDAI, address *openwallet.Address, typedData *apitypes.TypedData) (*TypedDataSignature, error) {
+
+	hash, err := HashTypedData(typedData)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, err.Error())
+	}
+
+	signature, err := decoder.wm.signHashWithHDKey(wrapper, address, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTypedDataSignature(decoder.wm.CustomAddressDecodeFunc(address.Address), hash, signature), nil
+}
+
+// signHashWithHDKey 通过地址的HDPath派生私钥，对32字节摘要签名，返回r+s+v，v为0或1
+func (wm *WalletManager) signHashWithHDKey(wrapper openwallet.WalletDAI, address *openwallet.Address, hash []byte) ([]byte, error) {
+
+	if address == nil || len(address.HDPath) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "address hd path is empty")
+	}
+
+	key, err := wrapper.HDKey()
+	if err != nil {
+		wm.Log.Error("get HDKey from wallet wrapper failed, err=%v", err)
+		return nil, err
+	}
+
+	childKey, err := key.DerivedKeyWithPath(address.HDPath, owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		return nil, openwallet.NewError(openwallet.ErrSignRawTransactionFailed, err.Error())
+	}
+	keyBytes, err := childKey.GetPrivateKeyBytes()
+	if err != nil {
+		return nil, openwallet.NewError(openwallet.ErrSignRawTransactionFailed, err.Error())
+	}
+
+	signature, v, sigErr := owcrypt.Signature(keyBytes, nil, hash, wm.CurveType())
+	if sigErr != owcrypt.SUCCESS {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "message hash sign failed")
+	}
+	return append(signature, v), nil
+}
+
+func newTypedDataSignature(address string, hash, signature []byte) *TypedDataSignature {
+	//以太坊签名的v为27或28
+	v := signature[64] + 27
+	sig := append(append([]byte{}, signature[:64]...), v)
+	return &TypedDataSignature{
+		Address:   strings.ToLower(AppendOxToAddress(address)),
+		Hash:      hexutil.Encode(hash),
+		Signature: hexutil.Encode(sig),
+		R:         hexutil.Encode(signature[:32]),
+		S:         hexutil.Encode(signature[32:64]),
+		V:         v,
+	}
+}
+
+// BuildERC20PermitTypedData 构建EIP-2612 permit的结构化数据，读取合约的name，version，nonces，
+// 并与合约的DOMAIN_SEPARATOR比对，value为最小单位
+func (wm *WalletManager) BuildERC20PermitTypedData(contractAddr, owner, spender string, value *big.Int, deadline uint64) (*apitypes.TypedData, error) {
+
+	contractAddr = AppendOxToAddress(wm.CustomAddressDecodeFunc(contractAddr))
+	owner = AppendOxToAddress(wm.CustomAddressDecodeFunc(owner))
+	spender = AppendOxToAddress(wm.CustomAddressDecodeFunc(spender))
+
+	result, callErr := wm.CallABI(contractAddr, ERC20_ABI, "nonces", owner)
+	if callErr != nil {
+		return nil, fmt.Errorf("get permit nonce failed, err: %v", callErr)
+	}
+	nonce, ok := result[""].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("permit nonce result is invalid")
+	}
+
+	result, callErr = wm.CallABI(contractAddr, ERC20_ABI, "DOMAIN_SEPARATOR")
+	if callErr != nil {
+		return nil, fmt.Errorf("get DOMAIN_SEPARATOR failed, err: %v", callErr)
+	}
+	separator, ok := result[""].([32]byte)
+	if !ok {
+		return nil, fmt.Errorf("DOMAIN_SEPARATOR result is invalid")
+	}
+
+	name := ""
+	result, callErr = wm.CallABI(contractAddr, ERC20_ABI, "name")
+	if callErr == nil {
+		name, _ = result[""].(string)
+	}
+
+	//合约实现了version()则使用，否则使用默认版本
+	version := defaultPermitVersion
+	result, callErr = wm.CallABI(contractAddr, EIP712_VERSION_ABI, "version")
+	if callErr == nil {
+		if v, vOk := result[""].(string); vOk && len(v) > 0 {
+			version = v
+		}
+	}
+
+	chainID := math.HexOrDecimal256(*new(big.Int).SetUint64(wm.Config.ChainID))
+	typedData := &apitypes.TypedData{
+		Types:       erc20PermitTypes,
+		PrimaryType: "Permit",
+		Domain: apitypes.TypedDataDomain{
+			Name:              name,
+			Version:           version,
+			ChainId:           &chainID,
+			VerifyingContract: contractAddr,
+		},
+		Message: apitypes.TypedDataMessage{
+			"owner":    owner,
+			"spender":  spender,
+			"value":    value.String(),
+			"nonce":    nonce.String(),
+			"deadline": new(big.Int).SetUint64(deadline).String(),
+		},
+	}
+
+	domainSeparator, err := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(domainSeparator, separator[:]) {
+		return nil, fmt.Errorf("domain separator mismatch, contract: %s, computed: %s", hex.EncodeToString(separator[:]), hex.EncodeToString(domainSeparator))
+	}
+
+	return typedData, nil
+}
+
+// SignERC20Permit 签名EIP-2612 permit，实现无手续费授权，amount为代币单位
+func (decoder *EthTransactionDecoder) SignERC20Permit(wrapper openwallet.WalletDAI, address *openwallet.Address, contract openwallet.SmartContract, spender string, amount string, deadline uint64) (*ERC20Permit, error) {
+
+	if address == nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "permit owner address is empty")
+	}
+	value := common.StringNumToBigIntWithExp(amount, int32(contract.Decimals))
+	if value.Sign() < 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "permit amount is invalid")
+	}
+
+	typedData, err := decoder.wm.BuildERC20PermitTypedData(contract.Address, address.Address, spender, value, deadline)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "build permit typed data failed, err: %v", err)
+	}
+
+	signature, err := decoder.SignTypedData(wrapper, address, typedData)
+	if err != nil {
+		return nil, err
+	}
+
+	permit := &ERC20Permit{
+		Contract:           typedData.Domain.VerifyingContract,
+		Owner:              typedData.Message["owner"].(string),
+		Spender:            typedData.Message["spender"].(string),
+		Value:              value.String(),
+		Nonce:              typedData.Message["nonce"].(string),
+		Deadline:           deadline,
+		TypedData:          typedData,
+		TypedDataSignature: signature,
+	}
+	return permit, nil
+}
+
+// EncodeERC20Permit 编码permit(owner,spender,value,deadline,v,r,s)调用数据，用于提交permit交易
+func EncodeERC20Permit(permit *ERC20Permit) ([]byte, error) {
+	value, ok := new(big.Int).SetString(permit.Value, 10)
+	if !ok {
+		return nil, fmt.Errorf("permit value is invalid")
+	}
+	var r, s [32]byte
+	copy(r[:], ethcom.FromHex(permit.R))
+	copy(s[:], ethcom.FromHex(permit.S))
+	return ERC20_ABI.Pack("permit",
+		ethcom.HexToAddress(permit.Owner),
+		ethcom.HexToAddress(permit.Spender),
+		value,
+		new(big.Int).SetUint64(permit.Deadline),
+		permit.V, r, s)
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestHashTypedData(t *testing.T) {
+	//EIP-712规范中的示例
+	typedDataJSON := `{
+		"types": {
+			"EIP712Domain": [
+				{"name": "name", "type": "string"},
+				{"name": "version", "type": "string"},
+				{"name": "chainId", "type": "uint256"},
+				{"name": "verifyingContract", "type": "address"}
+			],
+			"Person": [
+				{"name": "name", "type": "string"},
+				{"name": "wallet", "type": "address"}
+			],
+			"Mail": [
+				{"name": "from", "type": "Person"},
+				{"name": "to", "type": "Person"},
+				{"name": "contents", "type": "string"}
+			]
+		},
+		"primaryType": "Mail",
+		"domain": {
+			"name": "Ether Mail",
+			"version": "1",
+			"chainId": 1,
+			"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+		},
+		"message": {
+			"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+			"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+			"contents": "Hello, Bob!"
+		}
+	}`
+
+	typedData, err := ParseTypedData(typedDataJSON)
+	if err != nil {
+		t.Errorf("ParseTypedData error: %v", err)
+		return
+	}
+	hash, err := HashTypedData(typedData)
+	if err != nil {
+		t.Errorf("HashTypedData error: %v", err)
+		return
+	}
+	expected := "0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2"
+	if hexutil.Encode(hash) != expected {
+		t.Errorf("typed data hash expected %s, got %s", expected, hexutil.Encode(hash))
+	}
+}
+
+func TestWalletManager_BuildERC20PermitTypedData(t *testing.T) {
+	wm := testNewWalletManager()
+
+	//USDC
+	contract := "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	owner := "0x47ac0fb4f2d84898e4d9e7b4dab3c24507a6d503"
+	spender := "0x7a250d5630b4cf539739df2c5dacb4c659f2488d"
+
+	typedData, err := wm.BuildERC20PermitTypedData(contract, owner, spender, big.NewInt(1000000), 1893456000)
+	if err != nil {
+		t.Errorf("BuildERC20PermitTypedData error: %v", err)
+		return
+	}
+	hash, err := HashTypedData(typedData)
+	if err != nil {
+		t.Errorf("HashTypedData error: %v", err)
+		return
+	}
+	log.Infof("domain: %+v", typedData.Domain)
+	log.Infof("permit hash: %s", hexutil.Encode(hash))
+}
@@ -0,0 +1,317 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+const (
+	// 设置授权额度
+	ERC20ApproveMethodApprove = "approve"
+	// 增加授权额度，OpenZeppelin ERC20扩展方法
+	ERC20ApproveMethodIncrease = "increaseAllowance"
+	// 减少授权额度，OpenZeppelin ERC20扩展方法
+	ERC20ApproveMethodDecrease = "decreaseAllowance"
+
+	ERC20_ALLOWANCE_ABI_JSON = `[{"inputs":[{"internalType":"address","name":"spender","type":"address"},{"internalType":"uint256","name":"addedValue","type":"uint256"}],"name":"increaseAllowance","outputs":[{"internalType":"bool","name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"internalType":"address","name":"spender","type":"address"},{"internalType":"uint256","name":"subtractedValue","type":"uint256"}],"name":"decreaseAllowance","outputs":[{"internalType":"bool","name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"}]`
+)
+
+var (
+	ERC20_ALLOWANCE_ABI, _ = abi.JSON(strings.NewReader(ERC20_ALLOWANCE_ABI_JSON))
+)
+
+// ERC20ApproveParam ERC20授权参数
+type ERC20ApproveParam struct {
+	Account   *openwallet.AssetsAccount //授权账户
+	Contract  openwallet.SmartContract  //代币合约
+	Spender   string                    //被授权地址
+	Method    string                    //approve，increaseAllowance，decreaseAllowance，为空默认approve
+	Amount    string                    //授权数量，单位为代币，Unlimited为true时忽略
+	Unlimited bool                      //approve无限额度
+	FeeRate   string                    //gasPrice，为空自动估算
+}
+
+// ERC20Allowance ERC20授权额度
+type ERC20Allowance struct {
+	Contract  *openwallet.SmartContract `json:"-"`
+	Owner     string                    `json:"owner"`
+	Spender   string                    `json:"spender"`
+	Allowance string                    `json:"allowance"` //单位为代币
+	Unlimited bool                      `json:"unlimited"` //额度为uint256最大值
+}
+
+// CreateERC20ApproveRawTransaction 创建ERC20 approve/increaseAllowance/decreaseAllowance交易单，返回待签名的交易单
+func (decoder *EthContractDecoder) CreateERC20ApproveRawTransaction(wrapper openwallet.WalletDAI, param *ERC20ApproveParam) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if param == nil || param.Account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "approve account is empty")
+	}
+	if len(param.Contract.Address) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "token contract address is empty")
+	}
+	if len(param.Spender) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "spender address is empty")
+	}
+
+	method := param.Method
+	if len(method) == 0 {
+		method = ERC20ApproveMethodApprove
+	}
+
+	defAddress, addrErr := decoder.GetAssetsAccountDefAddress(wrapper, param.Account.AccountID)
+	if addrErr != nil {
+		return nil, addrErr
+	}
+	owner := decoder.wm.CustomAddressDecodeFunc(defAddress.Address)
+	spender := ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(param.Spender))
+
+	amount := math.MaxBig256
+	if !param.Unlimited || method != ERC20ApproveMethodApprove {
+		if len(param.Amount) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "approve amount is empty")
+		}
+		amount = common.StringNumToBigIntWithExp(param.Amount, int32(param.Contract.Decimals))
+		if amount.Sign() < 0 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "approve amount is invalid")
+		}
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch method {
+	case ERC20ApproveMethodApprove:
+		data, err = ERC20_ABI.Pack("approve", spender, amount)
+	case ERC20ApproveMethodIncrease:
+		data, err = ERC20_ALLOWANCE_ABI.Pack("increaseAllowance", spender, amount)
+	case ERC20ApproveMethodDecrease:
+		//减少的额度不能超过当前额度，否则合约会回滚
+		allowance, allowanceErr := decoder.wm.ERC20GetAllowance(param.Contract.Address, owner, param.Spender)
+		if allowanceErr != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "get allowance failed, err: %v", allowanceErr)
+		}
+		if allowance.Cmp(amount) < 0 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "decreased amount exceeds current allowance: %s",
+				common.BigIntToDecimals(allowance, int32(param.Contract.Decimals)).String())
+		}
+		data, err = ERC20_ALLOWANCE_ABI.Pack("decreaseAllowance", spender, amount)
+	default:
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "approve method %s is not support", method)
+	}
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "approve abi pack failed, err: %v", err)
+	}
+
+	callMsg := CallMsg{
+		From:  ethcom.HexToAddress(owner),
+		To:    ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(param.Contract.Address)),
+		Data:  data,
+		Value: big.NewInt(0),
+	}
+	raw, err := callMsg.MarshalJSON()
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+
+	rawTx := &openwallet.SmartContractRawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     decoder.wm.Symbol(),
+			IsContract: true,
+			ContractID: param.Contract.ContractID,
+			Contract:   param.Contract,
+		},
+		Account: param.Account,
+		Raw:     string(raw),
+		RawType: openwallet.TxRawTypeJSON,
+		Value:   "0",
+		FeeRate: param.FeeRate,
+	}
+
+	//估算手续费，检查手续费余额，生成待签名消息
+	if createErr := decoder.CreateSmartContractRawTransaction(wrapper, rawTx); createErr != nil {
+		return nil, createErr
+	}
+
+	return rawTx, nil
+}
+
+// CreateERC20RevokeRawTransaction 创建撤销ERC20授权的交易单，即approve(spender, 0)
+func (decoder *EthContractDecoder) CreateERC20RevokeRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, contract openwallet.SmartContract, spender string, feeRate string) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+	return decoder.CreateERC20ApproveRawTransaction(wrapper, &ERC20ApproveParam{
+		Account:  account,
+		Contract: contract,
+		Spender:  spender,
+		Method:   ERC20ApproveMethodApprove,
+		Amount:   "0",
+		FeeRate:  feeRate,
+	})
+}
+
+// GetERC20Allowances 批量查询owner对多个spender的授权额度，结果与spenders顺序一致
+func (decoder *EthContractDecoder) GetERC20Allowances(contract openwallet.SmartContract, owner string, spenders ...string) ([]*ERC20Allowance, *openwallet.Error) {
+
+	calls := make([]MulticallCall, 0, len(spenders))
+	ownerAddr := ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(owner))
+	for _, spender := range spenders {
+		data, err := ERC20_ABI.Pack("allowance", ownerAddr, ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(spender)))
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrSystemException, "allowance abi pack failed, err: %v", err)
+		}
+		calls = append(calls, MulticallCall{Target: contract.Address, CallData: data})
+	}
+
+	results := decoder.wm.CallBatch(calls)
+	allowances := make([]*ERC20Allowance, 0, len(spenders))
+	for i, spender := range spenders {
+		if results[i].Err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "get allowance of spender %s failed, err: %v", spender, results[i].Err)
+		}
+		if !results[i].Success {
+			return nil, openwallet.Errorf(openwallet.ErrSystemException, "get allowance of spender %s failed", spender)
+		}
+		out, err := ERC20_ABI.Unpack("allowance", results[i].ReturnData)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrSystemException, "get allowance of spender %s failed, err: %v", spender, err)
+		}
+		allowance := out[0].(*big.Int)
+		allowances = append(allowances, &ERC20Allowance{
+			Contract:  &contract,
+			Owner:     owner,
+			Spender:   spender,
+			Allowance: common.BigIntToDecimals(allowance, int32(contract.Decimals)).String(),
+			Unlimited: allowance.Cmp(math.MaxBig256) == 0,
+		})
+	}
+	return allowances, nil
+}
+
+// ERC20GetAllowance 查询授权额度，最小单位
+func (wm *WalletManager) ERC20GetAllowance(contractAddr, owner, spender string) (*big.Int, error) {
+	result, err := wm.CallABI(wm.CustomAddressDecodeFunc(contractAddr), ERC20_ABI, "allowance",
+		wm.CustomAddressDecodeFunc(owner), wm.CustomAddressDecodeFunc(spender))
+	if err != nil {
+		return nil, err
+	}
+	allowance, ok := result[""].(*big.Int)
+	if !ok {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "allowance result is invalid")
+	}
+	return allowance, nil
+}
+
+// extractERC20Approval 提取授权人为扫描地址的ERC20 Approval事件，合约不是扫描目标时也能审计授权
+func (bs *BlockScanner) extractERC20Approval(tx *BlockTransaction, result *ExtractResult) {
+
+	approvalEvents := tx.Receipt.ParseApprovalEvent()
+	if len(approvalEvents) == 0 {
+		return
+	}
+
+	var (
+		createAt = time.Now().Unix()
+		receipts = make(map[string]*openwallet.SmartContractReceipt)
+		txTo     = strings.ToLower(tx.To)
+	)
+
+	for contractAddress, approvals := range approvalEvents {
+		contract := bs.approvalContract(tx, contractAddress)
+		for _, approval := range approvals {
+			targetResult := tx.FilterFunc(openwallet.ScanTargetParam{
+				ScanTarget:     bs.wm.CustomAddressEncodeFunc(approval.TokenOwner),
+				Symbol:         bs.wm.Symbol(),
+				ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+			if !targetResult.Exist {
+				continue
+			}
+
+			receipt := receipts[targetResult.SourceKey]
+			if receipt == nil {
+				//合约作为扫描目标时已记录了回执
+				if _, ok := result.extractContractData[targetResult.SourceKey]; ok {
+					continue
+				}
+				toContract := bs.approvalContract(tx, txTo)
+				receipt = &openwallet.SmartContractReceipt{
+					Coin: openwallet.Coin{
+						Symbol:     bs.wm.Symbol(),
+						IsContract: true,
+						ContractID: toContract.ContractID,
+						Contract:   *toContract,
+					},
+					TxID:        tx.Hash,
+					From:        tx.From,
+					To:          tx.To,
+					Fees:        tx.GetTxFeeEthString(),
+					Value:       tx.GetAmountEthString(),
+					RawReceipt:  tx.Receipt.Raw,
+					Events:      make([]*openwallet.SmartContractEvent, 0),
+					BlockHash:   tx.BlockHash,
+					BlockHeight: tx.BlockHeight,
+					ConfirmTime: createAt,
+					Status:      common.NewString(tx.Status).String(),
+					Reason:      "",
+				}
+				receipts[targetResult.SourceKey] = receipt
+			}
+
+			value, _ := json.Marshal(map[string]string{
+				"owner":   approval.TokenOwner,
+				"spender": approval.TokenSpender,
+				"value":   approval.Value.String(),
+			})
+			receipt.Events = append(receipt.Events, &openwallet.SmartContractEvent{
+				Contract: contract,
+				Event:    "Approval",
+				Value:    string(value),
+			})
+		}
+	}
+
+	for sourceKey, receipt := range receipts {
+		receipt.GenWxID()
+		result.extractContractData[sourceKey] = receipt
+	}
+}
+
+// approvalContract 查找合约信息，不是扫描目标则填充未知合约
+func (bs *BlockScanner) approvalContract(tx *BlockTransaction, contractAddress string) *openwallet.SmartContract {
+	targetResult := tx.FilterFunc(openwallet.ScanTargetParam{
+		ScanTarget:     contractAddress,
+		Symbol:         bs.wm.Symbol(),
+		ScanTargetType: openwallet.ScanTargetTypeContractAddress})
+	if targetResult.Exist {
+		if contract, ok := targetResult.TargetInfo.(*openwallet.SmartContract); ok {
+			return contract
+		}
+	}
+	return &openwallet.SmartContract{
+		ContractID: openwallet.GenContractID(bs.wm.Symbol(), contractAddress),
+		Symbol:     bs.wm.Symbol(),
+		Address:    contractAddress,
+		Decimals:   0,
+	}
+}
@@ -37,6 +37,7 @@ import (
 	//	"log"
 	"math/big"
 	"strings"
+	"time"
 )
 
 type WalletManager struct {
@@ -55,6 +56,17 @@ type WalletManager struct {
 	CustomAddressEncodeFunc func(address string) string     //自定义地址转换算法
 	CustomAddressDecodeFunc func(address string) string     //自定义地址转换算法
 	MoralisSDK              *quorum_moralis.MoralisSDK      //MoralisSDK
+	ABIRegistry             *ABIRegistry                    //本地ABI注册表
+	SignatureDB             *SignatureDB                    //事件和方法签名库
+	ContractCapabilities    *ContractCapabilityCache        //合约能力缓存
+	TxReplacements          *TxReplacementStore             //替换交易记录
+	NonceManager            *NonceManager                   //地址nonce管理
+	TxJournal               *TxJournal                      //已广播交易日志
+
+	traceCallUnsupported int32     //节点不支持debug_traceCall
+	proxyContracts       *lruCache //代理合约缓存
+	nftMetadataCache     *lruCache //NFT元数据缓存
+	txJournalQuit        chan struct{}
 }
 
 func NewWalletManager() *WalletManager {
@@ -68,6 +80,12 @@ func NewWalletManager() *WalletManager {
 	wm.Log = log.NewOWLogger(wm.Symbol())
 	wm.CustomAddressEncodeFunc = CustomAddressEncode
 	wm.CustomAddressDecodeFunc = CustomAddressDecode
+	wm.SignatureDB = NewSignatureDB()
+	wm.nftMetadataCache = newLRUCache(nftMetadataCacheSize, nftMetadataCacheTTL)
+	wm.proxyContracts = newLRUCache(proxyContractCacheSize, proxyContractCacheTTL)
+	wm.ContractCapabilities, _ = NewContractCapabilityCache("", DefaultContractCapabilityTTL*time.Second)
+	wm.TxReplacements, _ = NewTxReplacementStore("")
+	wm.NonceManager, _ = NewNonceManager(&wm, "", DefaultNonceReservationTTL*time.Second)
 
 	return &wm
 }
@@ -83,6 +101,12 @@ func NewWalletManagerWithSymbol(symbol string) *WalletManager {
 	wm.Log = log.NewOWLogger(symbol)
 	wm.CustomAddressEncodeFunc = CustomAddressEncode
 	wm.CustomAddressDecodeFunc = CustomAddressDecode
+	wm.SignatureDB = NewSignatureDB()
+	wm.nftMetadataCache = newLRUCache(nftMetadataCacheSize, nftMetadataCacheTTL)
+	wm.proxyContracts = newLRUCache(proxyContractCacheSize, proxyContractCacheTTL)
+	wm.ContractCapabilities, _ = NewContractCapabilityCache("", DefaultContractCapabilityTTL*time.Second)
+	wm.TxReplacements, _ = NewTxReplacementStore("")
+	wm.NonceManager, _ = NewNonceManager(&wm, "", DefaultNonceReservationTTL*time.Second)
 
 	return &wm
 }
@@ -321,9 +345,12 @@ func (wm *WalletManager) GetGasEstimated(from string, to string, value *big.Int,
 	//toAddr := ethcom.HexToAddress(to)
 	callMsg := map[string]interface{}{
 		"from": wm.CustomAddressDecodeFunc(from),
-		"to":   wm.CustomAddressDecodeFunc(to),
 		"data": hexutil.Encode(data),
 	}
+	//to为空表示部署合约
+	if len(to) > 0 {
+		callMsg["to"] = wm.CustomAddressDecodeFunc(to)
+	}
 
 	if value != nil {
 		callMsg["value"] = hexutil.EncodeBig(value)
@@ -460,16 +487,16 @@ func (wm *WalletManager) DecodeReceiptLogResult(abiInstance abi.ABI, log types.L
 }
 
 func (wm *WalletManager) EthCall(callMsg CallMsg, sign string) (string, error) {
-	if callMsg.Value == nil {
-		callMsg.Value = big.NewInt(0)
-	}
-	param := map[string]interface{}{
-		"from":  callMsg.From.String(),
-		"to":    callMsg.To.String(),
-		"value": hexutil.EncodeBig(callMsg.Value),
-		"data":  hexutil.Encode(callMsg.Data),
+	return wm.EthCallWithOptions(callMsg, &EthCallOptions{BlockNumber: sign})
+}
+
+// EthCallWithOptions 指定区块及状态覆盖调用eth_call
+func (wm *WalletManager) EthCallWithOptions(callMsg CallMsg, opts *EthCallOptions) (string, error) {
+	params := []interface{}{callMsg.toCallParam(), opts.BlockParam()}
+	if opts != nil && len(opts.StateOverride) > 0 {
+		params = append(params, opts.StateOverride)
 	}
-	result, err := wm.WalletClient.Call("eth_call", []interface{}{param, sign})
+	result, err := wm.WalletClient.Call("eth_call", params)
 	if err != nil {
 		return "", err
 	}
@@ -478,16 +505,37 @@ func (wm *WalletManager) EthCall(callMsg CallMsg, sign string) (string, error) {
 
 // SendRawTransaction
 func (wm *WalletManager) SendRawTransaction(signedTx string) (string, error) {
-	params := []interface{}{
-		signedTx,
+	result, err := wm.BroadcastRawTransaction(signedTx)
+	if err != nil {
+		return "", err
 	}
 
-	result, err := wm.WalletClient.Call("eth_sendRawTransaction", params)
+	return result.TxID, nil
+}
+
+// BroadcastRawTransaction 按广播策略发送交易，返回各广播节点的结果
+func (wm *WalletManager) BroadcastRawTransaction(signedTx string) (*quorum_rpc.BroadcastResult, error) {
+	if wm.WalletClient == nil {
+		return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "wallet client is not initialized")
+	}
+
+	result, err := wm.WalletClient.SendRawTransaction(signedTx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return result.String(), nil
+	//记录各节点的广播结果，并行广播需等待其余节点返回
+	go func() {
+		for _, outcome := range result.Wait() {
+			if outcome.Accepted {
+				wm.Log.Infof("broadcast transaction %s to %s accepted, already known: %v, elapsed: %dms", result.TxID, outcome.URL, outcome.AlreadyKnown, outcome.Elapsed)
+			} else {
+				wm.Log.Warningf("broadcast transaction %s to %s rejected, err: %s, elapsed: %dms", result.TxID, outcome.URL, outcome.Error, outcome.Elapsed)
+			}
+		}
+	}()
+
+	return result, nil
 }
 
 // IsContract 是否合约
@@ -510,45 +558,25 @@ func (wm *WalletManager) IsContract(address string) (bool, error) {
 
 }
 
-// GetAddressNonce
+// GetAddressNonce 查询地址下一个可用的nonce，不预留，构建交易使用NonceManager.Reserve
+// wrapper参数不再使用，保留以兼容旧接口
 func (wm *WalletManager) GetAddressNonce(wrapper openwallet.WalletDAI, address string) uint64 {
-	var (
-		key           = wm.Symbol() + "-nonce"
-		nonce         uint64
-		nonce_db      interface{}
-		nonce_onchain uint64
-		err           error
-	)
-
-	//NonceComputeMode = 0时，使用外部系统的自增值
-	if wm.Config.NonceComputeMode == 0 {
-		//获取db记录的nonce并确认nonce值
-		nonce_db, _ = wrapper.GetAddressExtParam(address, key)
-
-		//判断nonce_db是否为空,为空则说明当前nonce是0
-		if nonce_db == nil {
-			nonce = 0
-		} else {
-			nonce = common.NewString(nonce_db).UInt64()
-		}
-	}
-
-	nonce_onchain, err = wm.GetTransactionCount(address)
+	nonce, err := wm.NonceManager.Peek(address)
 	if err != nil {
-		return nonce
+		wm.Log.Errorf("get address %s nonce failed, err: %v", address, err)
+		return 0
 	}
+	return nonce
+}
 
-	//如果本地nonce_db > 链上nonce,采用本地nonce,否则采用链上nonce
-	if nonce > nonce_onchain {
-		//wm.Log.Debugf("%s nonce_db=%v > nonce_chain=%v,Use nonce_db...", address, nonce_db, nonce_onchain)
-	} else {
-		nonce = nonce_onchain
-		//wm.Log.Debugf("%s nonce_db=%v <= nonce_chain=%v,Use nonce_chain...", address, nonce_db, nonce_onchain)
+// UpdateAddressNonce 交易广播成功后更新地址下一个nonce，通过NonceManager.Commit记录nonce-1已使用，
+// nonce为0表示交易失败，预留的nonce由构建交易的流程释放，不需要处理
+// wrapper参数不再使用，保留以兼容旧接口
+func (wm *WalletManager) UpdateAddressNonce(wrapper openwallet.WalletDAI, address string, nonce uint64) {
+	if nonce == 0 {
+		return
 	}
-
-	//wm.Log.Debugf("nonce: %v", nonce)
-
-	return nonce
+	wm.NonceManager.Commit(address, nonce-1, "")
 }
 
 func (wm *WalletManager) CallABI(contractAddr string, abiInstance abi.ABI, abiParam ...string) (map[string]interface{}, *openwallet.Error) {
@@ -584,70 +612,94 @@ func (wm *WalletManager) CallABI(contractAddr string, abiInstance abi.ABI, abiPa
 	return rMap, nil
 }
 
+// SupportsInterface 检查合约支持的NFT协议，结果会被缓存
 func (wm *WalletManager) SupportsInterface(contractAddr string) string {
-	//is support erc721
-	result721, _ := wm.CallABI(contractAddr, ERC721_ABI, "supportsInterface", "0x80ac58cd")
-	//if err != nil {
-	//	log.Errorf("SupportsInterface: %+v", err)
-	//}
-	//is support erc1155
-	result1155, _ := wm.CallABI(contractAddr, ERC721_ABI, "supportsInterface", "0xd9b67a26")
-	//if err != nil {
-	//	log.Errorf("SupportsInterface: %+v", err)
-	//}
 
-	support721, ok := result721[""].(bool)
-	if ok && support721 {
-		return openwallet.InterfaceTypeERC721
+	capability := wm.getContractCapability(contractAddr)
+	if len(capability.Protocol) > 0 {
+		return capability.Protocol
+	}
+	wm.loadSharedCapability(capability)
+	if len(capability.Protocol) > 0 {
+		return capability.Protocol
+	}
+
+	protocol := openwallet.InterfaceTypeUnknown
+	if wm.SupportsInterfaceID(contractAddr, ERC721InterfaceID) {
+		protocol = openwallet.InterfaceTypeERC721
+	} else if wm.SupportsInterfaceID(contractAddr, ERC1155InterfaceID) {
+		protocol = openwallet.InterfaceTypeERC1155
 	}
 
-	support1155, ok := result1155[""].(bool)
-	if ok && support1155 {
-		return openwallet.InterfaceTypeERC1155
+	//重新获取，包含SupportsInterfaceID的结果
+	capability = wm.getContractCapability(contractAddr)
+	_, checked721 := capability.Interfaces[ERC721InterfaceID]
+	_, checked1155 := capability.Interfaces[ERC1155InterfaceID]
+	//两个接口都检查成功才记录协议，否则下次重新检查
+	if protocol != openwallet.InterfaceTypeUnknown || (checked721 && checked1155) {
+		capability.Protocol = protocol
+		wm.saveContractCapability(capability)
 	}
 
-	return openwallet.InterfaceTypeUnknown
+	return protocol
 }
 
-// UpdateAddressNonce
-func (wm *WalletManager) UpdateAddressNonce(wrapper openwallet.WalletDAI, address string, nonce uint64) {
-	key := wm.Symbol() + "-nonce"
-	err := wrapper.SetAddressExtParam(address, key, nonce)
-	if err != nil {
-		wm.Log.Errorf("WalletDAI SetAddressExtParam failed, err: %v", err)
-	}
+// interfacesChecked ERC721和ERC1155接口是否都已检查成功
+func (wm *WalletManager) interfacesChecked(addr string) bool {
+	capability := wm.getContractCapability(addr)
+	_, checked721 := capability.Interfaces[ERC721InterfaceID]
+	_, checked1155 := capability.Interfaces[ERC1155InterfaceID]
+	return checked721 && checked1155
 }
 
 // LoadContractInfo 通过地址加载合约信息
 func (wm *WalletManager) LoadContractInfo(addr string) *openwallet.SmartContract {
 	var (
-		contract *openwallet.SmartContract
-		abiInst  abi.ABI
-		abiJSON  = ""
-		token    = ""
-		name     = ""
-		decimals = uint64(0)
+		abiInst abi.ABI
+		info    = &ContractInfo{Valid: true}
 	)
+
+	//合约信息已缓存
+	if cached := wm.getContractCapability(addr).Info; cached != nil {
+		if !cached.Valid {
+			return nil
+		}
+		return wm.newContractWithInfo(addr, cached)
+	}
+
+	//记录代理合约的逻辑合约地址
+	info.Implementation = wm.GetProxyImplementation(addr)
 	inferfaceType := wm.SupportsInterface(addr)
+	//代理合约通过逻辑合约检查接口
+	if inferfaceType == openwallet.InterfaceTypeUnknown && len(info.Implementation) > 0 {
+		inferfaceType = wm.SupportsInterface(info.Implementation)
+	}
+	//NFT合约检查是否支持ERC2981版税
+	if inferfaceType == openwallet.InterfaceTypeERC721 || inferfaceType == openwallet.InterfaceTypeERC1155 {
+		info.Royalty = wm.SupportsRoyalty(addr)
+	}
+	info.Protocol = inferfaceType
 	switch inferfaceType {
 	case openwallet.InterfaceTypeERC721:
-		abiJSON = ERC721_ABI_JSON
 		abiInst = ERC721_ABI
 
 	case openwallet.InterfaceTypeERC1155:
-		abiJSON = ERC1155_ABI_JSON
 		abiInst = ERC1155_ABI
 	default:
 
-		result, err := wm.CallABI(addr, ERC20_ABI, "decimals")
+		result, reverted, err := wm.callABIResult(addr, ERC20_ABI, "decimals")
 		if err == nil {
 			v, ok := result[""].(uint64)
 			if ok {
-				abiJSON = ERC20_ABI_JSON
 				abiInst = ERC20_ABI
-				decimals = v
+				info.ERC20 = true
+				info.Decimals = v
 			}
 		} else {
+			//接口检查都已完成且合约回滚才说明不是代币合约，记录结果避免重复查询
+			if reverted && wm.interfacesChecked(addr) {
+				wm.saveContractInfo(addr, &ContractInfo{Valid: false})
+			}
 			return nil
 		}
 	}
@@ -656,26 +708,42 @@ func (wm *WalletManager) LoadContractInfo(addr string) *openwallet.SmartContract
 	if err == nil {
 		v, ok := result[""].(string)
 		if ok {
-			token = v
+			info.Token = v
 		}
 	}
 	result, err = wm.CallABI(addr, abiInst, "name")
 	if err == nil {
 		v, ok := result[""].(string)
 		if ok {
-			name = v
+			info.Name = v
 		}
 	}
 
+	wm.saveContractInfo(addr, info)
+	return wm.newContractWithInfo(addr, info)
+}
+
+// newContractWithInfo 通过合约信息创建合约
+func (wm *WalletManager) newContractWithInfo(addr string, info *ContractInfo) *openwallet.SmartContract {
+	abiJSON := ""
+	switch {
+	case info.Protocol == openwallet.InterfaceTypeERC721:
+		abiJSON = ERC721_ABI_JSON
+	case info.Protocol == openwallet.InterfaceTypeERC1155:
+		abiJSON = ERC1155_ABI_JSON
+	case info.ERC20:
+		abiJSON = ERC20_ABI_JSON
+	}
+
 	contractId := openwallet.GenContractID(wm.Symbol(), addr)
-	contract = &openwallet.SmartContract{
+	contract := &openwallet.SmartContract{
 		ContractID: contractId,
 		Symbol:     wm.Symbol(),
 		Address:    addr,
-		Decimals:   decimals,
-		Token:      token,
-		Name:       name,
-		Protocol:   inferfaceType,
+		Decimals:   info.Decimals,
+		Token:      info.Token,
+		Name:       info.Name,
+		Protocol:   info.Protocol,
 	}
 	contract.SetABI(abiJSON)
 	return contract
@@ -265,3 +265,24 @@ func TestWalletManager_GetBlockByNum(t *testing.T) {
 	//	//log.Infof("tx.Receipt[%d]: %+v", i, tx.Receipt)
 	//}
 }
+
+func TestWalletManager_DetectProxyContract(t *testing.T) {
+	wm := testNewWalletManager()
+	//Aave V3 Pool, EIP-1967代理合约
+	proxy, err := wm.DetectProxyContract("0x87870bca3f3fd6335c3f4ce8392d69350b4fa4e2")
+	if err != nil {
+		t.Errorf("DetectProxyContract error: %v", err)
+		return
+	}
+	log.Infof("proxy: %+v", proxy)
+}
+
+func TestSlotValueToAddress(t *testing.T) {
+	addr := slotValueToAddress("0x00000000000000000000000043506849d7c04f9138d1a2050bbf3a0c054402dd")
+	if addr != "0x43506849d7c04f9138d1a2050bbf3a0c054402dd" {
+		t.Errorf("slotValueToAddress unexpected: %s", addr)
+	}
+	if addr = slotValueToAddress("0x0000000000000000000000000000000000000000000000000000000000000000"); addr != "" {
+		t.Errorf("empty slot should return empty address, got: %s", addr)
+	}
+}
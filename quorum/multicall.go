@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/blocktree/quorum-adapter/quorum_rpc"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	// 默认Multicall3合约地址，主流EVM链部署地址相同
+	DefaultMulticallAddress = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+	MULTICALL3_ABI_JSON = `[{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+
+	// 每次aggregate3打包的最大调用数
+	maxMulticallBatchSize = 200
+)
+
+var (
+	MULTICALL3_ABI, _ = abi.JSON(strings.NewReader(MULTICALL3_ABI_JSON))
+)
+
+// MulticallCall 批量调用的单个调用
+type MulticallCall struct {
+	Target   string //合约地址
+	CallData []byte //abi编码后的调用数据
+}
+
+// MulticallResult 批量调用的单个结果
+type MulticallResult struct {
+	Success    bool
+	ReturnData []byte
+	Err        error //逐个调用时节点返回的非回滚错误，调用结果未知
+}
+
+// multicall3Call aggregate3的参数结构，字段名需与ABI一致
+type multicall3Call struct {
+	Target       ethcom.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+// Multicall 通过Multicall3.aggregate3批量执行eth_call，单个调用失败不影响其他调用，结果与calls顺序一致
+func (wm *WalletManager) Multicall(calls []MulticallCall) ([]MulticallResult, error) {
+
+	if len(wm.Config.MulticallAddress) == 0 {
+		return nil, fmt.Errorf("multicall address is not configured")
+	}
+
+	results := make([]MulticallResult, 0, len(calls))
+	for start := 0; start < len(calls); start += maxMulticallBatchSize {
+		end := start + maxMulticallBatchSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+		batch, err := wm.multicallBatch(calls[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+	}
+	return results, nil
+}
+
+func (wm *WalletManager) multicallBatch(calls []MulticallCall) ([]MulticallResult, error) {
+
+	params := make([]multicall3Call, 0, len(calls))
+	for _, call := range calls {
+		params = append(params, multicall3Call{
+			Target:       ethcom.HexToAddress(wm.CustomAddressDecodeFunc(call.Target)),
+			AllowFailure: true,
+			CallData:     call.CallData,
+		})
+	}
+
+	data, err := MULTICALL3_ABI.Pack("aggregate3", params)
+	if err != nil {
+		return nil, err
+	}
+
+	callMsg := CallMsg{
+		From:  ethcom.HexToAddress("0x00"),
+		To:    ethcom.HexToAddress(wm.CustomAddressDecodeFunc(wm.Config.MulticallAddress)),
+		Data:  data,
+		Value: big.NewInt(0),
+	}
+	result, err := wm.EthCall(callMsg, "latest")
+	if err != nil {
+		return nil, err
+	}
+
+	returnData, err := hexutil.Decode(result)
+	if err != nil {
+		return nil, err
+	}
+	//合约未部署时eth_call返回空
+	if len(returnData) == 0 {
+		return nil, fmt.Errorf("multicall contract %s is not deployed", wm.Config.MulticallAddress)
+	}
+
+	results := make([]MulticallResult, 0, len(calls))
+	if err = MULTICALL3_ABI.UnpackIntoInterface(&results, "aggregate3", returnData); err != nil {
+		return nil, err
+	}
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("multicall result length %d is not equal to calls length %d", len(results), len(calls))
+	}
+	return results, nil
+}
+
+// CallBatch 批量执行eth_call，优先使用Multicall，Multicall不可用时逐个调用，
+// 合约回滚的调用Success为false，网络等其他错误记录在Err
+func (wm *WalletManager) CallBatch(calls []MulticallCall) []MulticallResult {
+
+	results, err := wm.Multicall(calls)
+	if err == nil {
+		return results
+	}
+	wm.Log.Debugf("multicall failed, call one by one, err: %v", err)
+
+	results = make([]MulticallResult, 0, len(calls))
+	for _, call := range calls {
+		callMsg := CallMsg{
+			From:  ethcom.HexToAddress("0x00"),
+			To:    ethcom.HexToAddress(wm.CustomAddressDecodeFunc(call.Target)),
+			Data:  call.CallData,
+			Value: big.NewInt(0),
+		}
+		result, callErr := wm.EthCall(callMsg, "latest")
+		if callErr != nil {
+			if quorum_rpc.IsExecutionReverted(callErr) {
+				results = append(results, MulticallResult{Success: false})
+			} else {
+				results = append(results, MulticallResult{Success: false, Err: callErr})
+			}
+			continue
+		}
+		returnData, _ := hexutil.Decode(result)
+		results = append(results, MulticallResult{Success: true, ReturnData: returnData})
+	}
+	return results
+}
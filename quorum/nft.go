@@ -78,46 +78,18 @@ func (decoder *NFTContractDecoder) GetNFTBalanceByAddress(nft *openwallet.NFT, o
 }
 
 // GetNFTBalanceByAddressBatch 查询地址NFT余额列表
+// 支持多个合约，ERC721和ERC1155混合，任一项失败则返回错误，需要单项错误使用GetNFTBalanceByAddressMulti
 func (decoder *NFTContractDecoder) GetNFTBalanceByAddressBatch(nft []*openwallet.NFT, owner []string) ([]*openwallet.NFTBalance, *openwallet.Error) {
-	if len(nft) != len(owner) {
-		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT array length is not equal to owner array length")
-	}
-	contractAddress := ""
-	ids := ""
-	owners := ""
-	for i, o := range nft {
-
-		if len(o.TokenID) == 0 {
-			return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT token id is empty")
-		}
-
-		if i == 0 {
-			contractAddress = o.Address
-			ids = o.Address
-			owners = owner[i]
-		} else {
-			ids = ids + "," + o.Address
-			owners = owners + "," + owner[i]
-		}
-		if contractAddress != o.Address {
-			return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFTs contract address is inconsistent")
-		}
-
-	}
-	result, err := decoder.wm.CallABI(contractAddress, ERC1155_ABI, "balanceOfBatch", owners, ids)
+	results, err := decoder.GetNFTBalanceByAddressMulti(nft, owner)
 	if err != nil {
 		return nil, err
 	}
-	balanceRes, ok := result[""].([]*big.Int)
-	balances := make([]*openwallet.NFTBalance, 0)
-	if ok {
-		for i, re := range balanceRes {
-			nftBalance := &openwallet.NFTBalance{
-				NFT:     nft[i],
-				Balance: re.String(),
-			}
-			balances = append(balances, nftBalance)
+	balances := make([]*openwallet.NFTBalance, 0, len(results))
+	for _, result := range results {
+		if result.Error != nil {
+			return nil, result.Error
 		}
+		balances = append(balances, result.Balance)
 	}
 	return balances, nil
 }
@@ -183,6 +155,11 @@ func (decoder *NFTContractDecoder) GetMetaDataOfNFT(nft *openwallet.NFT) (*openw
 		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT interface type is not support")
 	}
 
+	//ERC1155 URI中的{id}替换为tokenID
+	if nft.Protocol == openwallet.InterfaceTypeERC1155 {
+		uri = replaceERC1155ID(uri, nft.TokenID)
+	}
+
 	nftMetaData := &openwallet.NFTMetaData{
 		NFT: nft,
 		URI: uri,
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 单个NFT授权，ERC721 Approval
+	NFTApprovalTypeToken = "Approval"
+	// 全部NFT授权给操作者，ApprovalForAll
+	NFTApprovalTypeOperator = "ApprovalForAll"
+)
+
+// NFTApproval NFT授权记录
+type NFTApproval struct {
+	Type     string          `json:"type"`     //NFTApprovalTypeToken或NFTApprovalTypeOperator
+	Protocol string          `json:"protocol"` //ERC721或ERC1155
+	Contract string          `json:"contract"` //合约地址
+	Owner    string          `json:"owner"`    //授权人
+	Spender  string          `json:"spender"`  //被授权地址，Approval为approved，ApprovalForAll为operator
+	NFT      *openwallet.NFT `json:"nft"`      //授权的NFT，只有Approval有值
+	Approved bool            `json:"approved"` //false表示撤销授权
+}
+
+// GetNFTApproval 从event解析NFT授权信息，支持ERC721 Approval/ApprovalForAll和ERC1155 ApprovalForAll
+func (decoder *NFTContractDecoder) GetNFTApproval(event *openwallet.SmartContractEvent) (*NFTApproval, *openwallet.Error) {
+	if event == nil {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "SmartContractEvent is nil")
+	}
+	if event.Event != NFTApprovalTypeToken && event.Event != NFTApprovalTypeOperator {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT approval event invalid")
+	}
+
+	//ERC20也有Approval事件，需检查合约是否支持nft协议
+	inferfaceType := decoder.wm.SupportsInterface(event.Contract.Address)
+	obj := gjson.ParseBytes([]byte(event.Value))
+
+	approval := &NFTApproval{
+		Type:     event.Event,
+		Protocol: inferfaceType,
+		Contract: strings.ToLower(event.Contract.Address),
+	}
+
+	switch inferfaceType {
+	case openwallet.InterfaceTypeERC721:
+		approval.Owner = obj.Get("owner").String()
+		if event.Event == NFTApprovalTypeToken {
+			//{"owner":"0x1234","approved":"0xabcd","tokenId":1414}
+			approval.Spender = obj.Get("approved").String()
+			approval.Approved = ethcom.HexToAddress(approval.Spender) != (ethcom.Address{})
+			approval.NFT = &openwallet.NFT{
+				Symbol:   event.Contract.Symbol,
+				Address:  event.Contract.Address,
+				Token:    event.Contract.Token,
+				Protocol: inferfaceType,
+				Name:     event.Contract.Name,
+				TokenID:  obj.Get("tokenId").String(),
+			}
+		} else {
+			//{"owner":"0x1234","operator":"0xabcd","approved":true}
+			approval.Spender = obj.Get("operator").String()
+			approval.Approved = obj.Get("approved").Bool()
+		}
+	case openwallet.InterfaceTypeERC1155:
+		if event.Event != NFTApprovalTypeOperator {
+			return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT approval event invalid")
+		}
+		//{"account":"0x1234","operator":"0xabcd","approved":true}
+		approval.Owner = obj.Get("account").String()
+		approval.Spender = obj.Get("operator").String()
+		approval.Approved = obj.Get("approved").Bool()
+	default:
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT interface type is not support")
+	}
+
+	approval.Owner = strings.ToLower(approval.Owner)
+	approval.Spender = strings.ToLower(approval.Spender)
+	return approval, nil
+}
+
+// GetNFTApproved 查询ERC721单个NFT当前的被授权地址，没有授权返回空
+func (decoder *NFTContractDecoder) GetNFTApproved(nft *openwallet.NFT) (string, *openwallet.Error) {
+
+	if len(nft.TokenID) == 0 {
+		return "", openwallet.Errorf(openwallet.ErrSystemException, "NFT token id is empty")
+	}
+	if nft.Protocol != openwallet.InterfaceTypeERC721 {
+		return "", openwallet.Errorf(openwallet.ErrSystemException, "NFT interface type is not support")
+	}
+
+	result, err := decoder.wm.CallABI(nft.Address, ERC721_ABI, "getApproved", nft.TokenID)
+	if err != nil {
+		return "", err
+	}
+	operator, ok := result["operator"].(ethcom.Address)
+	if !ok || operator == (ethcom.Address{}) {
+		return "", nil
+	}
+	return strings.ToLower(operator.String()), nil
+}
+
+// IsNFTApprovedForAll 查询owner是否把合约下全部NFT授权给operator
+func (decoder *NFTContractDecoder) IsNFTApprovedForAll(nft *openwallet.NFT, owner, operator string) (bool, *openwallet.Error) {
+
+	var (
+		result map[string]interface{}
+		err    *openwallet.Error
+	)
+	switch nft.Protocol {
+	case openwallet.InterfaceTypeERC721:
+		result, err = decoder.wm.CallABI(nft.Address, ERC721_ABI, "isApprovedForAll", owner, operator)
+	case openwallet.InterfaceTypeERC1155:
+		result, err = decoder.wm.CallABI(nft.Address, ERC1155_ABI, "isApprovedForAll", owner, operator)
+	default:
+		return false, openwallet.Errorf(openwallet.ErrSystemException, "NFT interface type is not support")
+	}
+	if err != nil {
+		return false, err
+	}
+	approved, ok := result[""].(bool)
+	return ok && approved, nil
+}
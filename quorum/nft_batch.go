@@ -0,0 +1,216 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+)
+
+// NFTBalanceResult 批量查询NFT余额的单项结果
+type NFTBalanceResult struct {
+	Balance *openwallet.NFTBalance
+	Error   *openwallet.Error
+}
+
+// nftBalanceCall 批量查询中的一次合约调用，可对应多个查询项
+type nftBalanceCall struct {
+	call    MulticallCall
+	indexes []int
+	//解析调用结果，返回与indexes对应的余额
+	decode func(data []byte) ([]*big.Int, error)
+	//调用回滚时的余额，为nil则返回错误
+	failBalance *big.Int
+}
+
+// erc1155BalanceGroup 同一ERC1155合约的查询合并为一次balanceOfBatch
+type erc1155BalanceGroup struct {
+	nftBalanceCall
+	accounts []ethcom.Address
+	ids      []*big.Int
+}
+
+// GetNFTBalanceByAddressMulti 批量查询多个合约、多个地址的NFT余额，支持ERC721和ERC1155混合。
+// 同一ERC1155合约的查询合并为balanceOfBatch，所有调用通过Multicall执行，结果与输入顺序一致。
+func (decoder *NFTContractDecoder) GetNFTBalanceByAddressMulti(nfts []*openwallet.NFT, owners []string) ([]*NFTBalanceResult, *openwallet.Error) {
+
+	if len(nfts) != len(owners) {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT array length is not equal to owner array length")
+	}
+
+	var (
+		results   = make([]*NFTBalanceResult, len(nfts))
+		protocols = make(map[string]string)
+		calls     = make([]*nftBalanceCall, 0)
+		//ERC1155按合约分组，保持首次出现的顺序
+		erc1155Groups = make(map[string]*erc1155BalanceGroup)
+		erc1155Order  = make([]string, 0)
+	)
+
+	for i, nft := range nfts {
+		results[i] = &NFTBalanceResult{}
+		if nft == nil || len(nft.Address) == 0 {
+			results[i].Error = openwallet.Errorf(openwallet.ErrSystemException, "NFT contract address is empty")
+			continue
+		}
+
+		contractAddr := strings.ToLower(nft.Address)
+		protocol := nft.Protocol
+		if len(protocol) == 0 {
+			if p, ok := protocols[contractAddr]; ok {
+				protocol = p
+			} else {
+				protocol = decoder.wm.SupportsInterface(nft.Address)
+				protocols[contractAddr] = protocol
+			}
+		}
+		results[i].Balance = &openwallet.NFTBalance{NFT: nft, Balance: "0"}
+		owner := ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(owners[i]))
+
+		var tokenID *big.Int
+		if len(nft.TokenID) > 0 {
+			id, numErr := parseNumParam(nft.TokenID)
+			if numErr != nil {
+				results[i].Error = openwallet.Errorf(openwallet.ErrSystemException, "NFT token id is invalid")
+				continue
+			}
+			tokenID = id
+		}
+
+		switch protocol {
+		case openwallet.InterfaceTypeERC721:
+			if tokenID != nil {
+				//查询tokenID是否属于owner，ownerOf回滚视为不拥有
+				data, _ := ERC721_ABI.Pack("ownerOf", tokenID)
+				calls = append(calls, &nftBalanceCall{
+					call:    MulticallCall{Target: nft.Address, CallData: data},
+					indexes: []int{i},
+					decode: func(data []byte) ([]*big.Int, error) {
+						out, err := ERC721_ABI.Unpack("ownerOf", data)
+						if err != nil {
+							return nil, err
+						}
+						if out[0].(ethcom.Address) == owner {
+							return []*big.Int{big.NewInt(1)}, nil
+						}
+						return []*big.Int{big.NewInt(0)}, nil
+					},
+					failBalance: big.NewInt(0),
+				})
+			} else {
+				data, _ := ERC721_ABI.Pack("balanceOf", owner)
+				calls = append(calls, &nftBalanceCall{
+					call:    MulticallCall{Target: nft.Address, CallData: data},
+					indexes: []int{i},
+					decode: func(data []byte) ([]*big.Int, error) {
+						out, err := ERC721_ABI.Unpack("balanceOf", data)
+						if err != nil {
+							return nil, err
+						}
+						return []*big.Int{out[0].(*big.Int)}, nil
+					},
+				})
+			}
+		case openwallet.InterfaceTypeERC1155:
+			if tokenID == nil {
+				results[i].Error = openwallet.Errorf(openwallet.ErrSystemException, "NFT token id is empty")
+				continue
+			}
+			group, ok := erc1155Groups[contractAddr]
+			if !ok {
+				group = &erc1155BalanceGroup{nftBalanceCall: nftBalanceCall{call: MulticallCall{Target: nft.Address}}}
+				erc1155Groups[contractAddr] = group
+				erc1155Order = append(erc1155Order, contractAddr)
+			}
+			group.indexes = append(group.indexes, i)
+			group.accounts = append(group.accounts, owner)
+			group.ids = append(group.ids, tokenID)
+		default:
+			results[i].Error = openwallet.Errorf(openwallet.ErrSystemException, "NFT interface type is not support")
+		}
+	}
+
+	for _, contractAddr := range erc1155Order {
+		group := erc1155Groups[contractAddr]
+		data, err := ERC1155_ABI.Pack("balanceOfBatch", group.accounts, group.ids)
+		if err != nil {
+			for _, i := range group.indexes {
+				results[i].Error = openwallet.Errorf(openwallet.ErrSystemException, "NFT abi pack failed, err: %v", err)
+			}
+			continue
+		}
+		group.call.CallData = data
+		group.decode = func(data []byte) ([]*big.Int, error) {
+			out, err := ERC1155_ABI.Unpack("balanceOfBatch", data)
+			if err != nil {
+				return nil, err
+			}
+			return out[0].([]*big.Int), nil
+		}
+		calls = append(calls, &group.nftBalanceCall)
+	}
+
+	if len(calls) == 0 {
+		return results, nil
+	}
+
+	multicalls := make([]MulticallCall, 0, len(calls))
+	for _, c := range calls {
+		multicalls = append(multicalls, c.call)
+	}
+	callResults := decoder.wm.CallBatch(multicalls)
+
+	for n, c := range calls {
+		var (
+			balances []*big.Int
+			err      error
+		)
+		if callResults[n].Success {
+			balances, err = c.decode(callResults[n].ReturnData)
+			if err == nil && len(balances) != len(c.indexes) {
+				err = fmt.Errorf("balance result length is invalid")
+			}
+		}
+		for k, i := range c.indexes {
+			switch {
+			case callResults[n].Err != nil:
+				//节点错误不能确定余额
+				results[i].Error = openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "NFT balance call failed, err: %v", callResults[n].Err)
+			case callResults[n].Success && err == nil:
+				results[i].Balance.Balance = balances[k].String()
+			case c.failBalance != nil:
+				results[i].Balance.Balance = c.failBalance.String()
+			case err != nil:
+				results[i].Error = openwallet.Errorf(openwallet.ErrSystemException, "NFT balance decode failed, err: %v", err)
+			default:
+				results[i].Error = openwallet.Errorf(openwallet.ErrSystemException, "NFT balance call failed")
+			}
+		}
+	}
+
+	//查询失败的项不返回余额
+	for _, result := range results {
+		if result.Error != nil {
+			result.Balance = nil
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// ERC721Enumerable 接口ID
+	ERC721EnumerableInterfaceID = "0x780e9d63"
+	// 默认每次eth_getLogs查询的区块范围
+	DefaultLogScanBlockRange = 5000
+	// 单个地址最多枚举的NFT数量
+	maxNFTEnumerateCount = 10000
+)
+
+var (
+	// Transfer(address,address,uint256) 事件topic
+	transferEventTopic = ERC721_ABI.Events["Transfer"].ID
+)
+
+// SupportsERC721Enumerable 合约是否支持ERC721Enumerable，结果会被缓存
+func (wm *WalletManager) SupportsERC721Enumerable(contractAddr string) bool {
+	return wm.SupportsInterfaceID(contractAddr, ERC721EnumerableInterfaceID)
+}
+
+// GetNFTListByOwner 查询地址在ERC721合约下拥有的NFT列表，
+// 合约支持ERC721Enumerable时通过tokenOfOwnerByIndex枚举，否则从配置的logScanStartBlock开始通过Transfer日志重建
+func (decoder *NFTContractDecoder) GetNFTListByOwner(contract *openwallet.NFT, owner string) ([]openwallet.NFT, *openwallet.Error) {
+	return decoder.GetNFTListByOwnerFromBlock(contract, owner, -1)
+}
+
+// GetNFTListByOwnerFromBlock 查询地址在ERC721合约下拥有的NFT列表，
+// fromBlock为重建Transfer日志的起始区块，一般为合约部署区块，小于0使用配置的logScanStartBlock
+func (decoder *NFTContractDecoder) GetNFTListByOwnerFromBlock(contract *openwallet.NFT, owner string, fromBlock int64) ([]openwallet.NFT, *openwallet.Error) {
+
+	if contract == nil || len(contract.Address) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT contract address is empty")
+	}
+
+	protocol := contract.Protocol
+	if len(protocol) == 0 {
+		protocol = decoder.wm.SupportsInterface(contract.Address)
+	}
+	if protocol != openwallet.InterfaceTypeERC721 {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT interface type is not support")
+	}
+
+	var (
+		tokenIDs []*big.Int
+		err      *openwallet.Error
+	)
+	if decoder.wm.SupportsERC721Enumerable(contract.Address) {
+		tokenIDs, err = decoder.enumerateTokenOfOwner(contract.Address, owner)
+	} else {
+		tokenIDs, err = decoder.reconstructTokenOfOwner(contract.Address, owner, fromBlock)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nfts := make([]openwallet.NFT, 0, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		nfts = append(nfts, openwallet.NFT{
+			Symbol:   contract.Symbol,
+			Address:  contract.Address,
+			Token:    contract.Token,
+			Protocol: protocol,
+			Name:     contract.Name,
+			TokenID:  tokenID.String(),
+		})
+	}
+	return nfts, nil
+}
+
+// enumerateTokenOfOwner 通过tokenOfOwnerByIndex枚举
+func (decoder *NFTContractDecoder) enumerateTokenOfOwner(contractAddr, owner string) ([]*big.Int, *openwallet.Error) {
+
+	result, err := decoder.wm.CallABI(contractAddr, ERC721_ABI, "balanceOf", owner)
+	if err != nil {
+		return nil, err
+	}
+	balance, ok := result["balance"].(*big.Int)
+	if !ok {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT balanceOf result is invalid")
+	}
+	if balance.Cmp(big.NewInt(maxNFTEnumerateCount)) > 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT balance %s exceeds enumerate limit %d", balance.String(), maxNFTEnumerateCount)
+	}
+
+	tokenIDs := make([]*big.Int, 0, balance.Int64())
+	for i := int64(0); i < balance.Int64(); i++ {
+		result, err = decoder.wm.CallABI(contractAddr, ERC721_ABI, "tokenOfOwnerByIndex", owner, big.NewInt(i).String())
+		if err != nil {
+			return nil, err
+		}
+		tokenID, ok := result["tokenId"].(*big.Int)
+		if !ok {
+			return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT tokenOfOwnerByIndex result is invalid")
+		}
+		tokenIDs = append(tokenIDs, tokenID)
+	}
+	return tokenIDs, nil
+}
+
+// reconstructTokenOfOwner 通过转入地址的Transfer日志找出候选tokenID，再用ownerOf确认当前拥有者
+func (decoder *NFTContractDecoder) reconstructTokenOfOwner(contractAddr, owner string, fromBlock int64) ([]*big.Int, *openwallet.Error) {
+
+	ownerTopic := ethcom.BytesToHash(ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(owner)).Bytes())
+	logs, logErr := decoder.wm.GetLogsInRange(contractAddr, [][]ethcom.Hash{{transferEventTopic}, nil, {ownerTopic}}, fromBlock)
+	if logErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "get NFT transfer logs failed, err: %v", logErr)
+	}
+
+	candidates := make(map[string]*big.Int)
+	for _, log := range logs {
+		//ERC721的Transfer事件tokenId为indexed，ERC20的Transfer只有3个topics
+		if len(log.Topics) != 4 {
+			continue
+		}
+		tokenID := log.Topics[3].Big()
+		candidates[tokenID.String()] = tokenID
+	}
+	if len(candidates) > maxNFTEnumerateCount {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT transfer logs exceed enumerate limit %d", maxNFTEnumerateCount)
+	}
+
+	ownerAddr := strings.ToLower(decoder.wm.CustomAddressDecodeFunc(owner))
+	tokenIDs := make([]*big.Int, 0)
+	for _, tokenID := range candidates {
+		nftOwner, err := decoder.GetNFTOwnerByTokenID(&openwallet.NFT{
+			Address:  contractAddr,
+			Protocol: openwallet.InterfaceTypeERC721,
+			TokenID:  tokenID.String(),
+		})
+		if err != nil {
+			//已销毁的token，ownerOf会回滚
+			continue
+		}
+		if nftOwner.Owner == ownerAddr {
+			tokenIDs = append(tokenIDs, tokenID)
+		}
+	}
+
+	sort.Slice(tokenIDs, func(i, j int) bool {
+		return tokenIDs[i].Cmp(tokenIDs[j]) < 0
+	})
+	return tokenIDs, nil
+}
+
+// GetLogs 查询日志
+func (wm *WalletManager) GetLogs(address string, topics [][]ethcom.Hash, fromBlock, toBlock string) ([]types.Log, error) {
+
+	topicsParam := make([]interface{}, 0, len(topics))
+	for _, t := range topics {
+		if len(t) == 0 {
+			topicsParam = append(topicsParam, nil)
+		} else {
+			topicsParam = append(topicsParam, t)
+		}
+	}
+
+	params := []interface{}{
+		map[string]interface{}{
+			"address":   AppendOxToAddress(wm.CustomAddressDecodeFunc(address)),
+			"topics":    topicsParam,
+			"fromBlock": fromBlock,
+			"toBlock":   toBlock,
+		},
+	}
+
+	result, err := wm.WalletClient.Call("eth_getLogs", params)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]types.Log, 0)
+	if err = json.Unmarshal([]byte(result.Raw), &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// GetLogsInRange 从起始区块到最新区块分段查询日志，避免单次查询范围过大被节点拒绝。
+// fromBlock小于0使用配置的logScanStartBlock，都没有设置返回错误，避免从创世区块开始扫描
+func (wm *WalletManager) GetLogsInRange(address string, topics [][]ethcom.Hash, fromBlock int64) ([]types.Log, error) {
+
+	if fromBlock < 0 {
+		fromBlock = wm.Config.LogScanStartBlock
+	}
+	if fromBlock < 0 {
+		return nil, fmt.Errorf("log scan start block is not set, pass the contract deployment block or config logScanStartBlock")
+	}
+	startBlock := uint64(fromBlock)
+
+	latest, err := wm.GetBlockNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	step := uint64(DefaultLogScanBlockRange)
+	if wm.Config.LogScanBlockRange > 0 {
+		step = uint64(wm.Config.LogScanBlockRange)
+	}
+	logs := make([]types.Log, 0)
+	for from := startBlock; from <= latest; from += step {
+		to := from + step - 1
+		if to > latest {
+			to = latest
+		}
+		rangeLogs, rangeErr := wm.GetLogs(address, topics, hexutil.EncodeUint64(from), hexutil.EncodeUint64(to))
+		if rangeErr != nil {
+			return nil, rangeErr
+		}
+		logs = append(logs, rangeLogs...)
+	}
+	return logs, nil
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 默认IPFS网关
+	DefaultIPFSGateway = "https://ipfs.io/ipfs/"
+	// 默认Arweave网关
+	DefaultArweaveGateway = "https://arweave.net/"
+	// 默认NFT元数据最大字节数
+	DefaultNFTMetadataMaxSize = 1 << 20
+
+	nftMetadataCacheSize = 1000
+	nftMetadataCacheTTL  = 30 * time.Minute
+	nftMetadataTimeout   = 15 * time.Second
+)
+
+// 运营商级NAT地址段，net.IP.IsPrivate不包含
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// NFTMetadataAttribute NFT元数据属性
+type NFTMetadataAttribute struct {
+	TraitType   string      `json:"trait_type"`
+	Value       interface{} `json:"value"`
+	DisplayType string      `json:"display_type,omitempty"`
+}
+
+// NFTMetadata 解析后的NFT元数据
+type NFTMetadata struct {
+	NFT          *openwallet.NFT         `json:"-"`
+	URI          string                  `json:"uri"`         //合约返回的URI，已替换{id}
+	ResolvedURI  string                  `json:"resolvedURI"` //通过网关解析后的URI
+	Name         string                  `json:"name"`
+	Description  string                  `json:"description"`
+	Image        string                  `json:"image"`    //原始图片地址
+	ImageURL     string                  `json:"imageURL"` //通过网关解析后的图片地址
+	ExternalURL  string                  `json:"externalURL"`
+	AnimationURL string                  `json:"animationURL"`
+	Attributes   []*NFTMetadataAttribute `json:"attributes"`
+	Raw          string                  `json:"raw"` //原始JSON
+}
+
+// GetNFTMetadata 查询并解析NFT的元数据JSON
+func (decoder *NFTContractDecoder) GetNFTMetadata(nft *openwallet.NFT) (*NFTMetadata, *openwallet.Error) {
+
+	nftMetaData, err := decoder.GetMetaDataOfNFT(nft)
+	if err != nil {
+		return nil, err
+	}
+	if len(nftMetaData.URI) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT [%s] uri is empty", nft.TokenID)
+	}
+
+	metadata, fetchErr := decoder.wm.FetchNFTMetadata(nftMetaData.URI)
+	if fetchErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "fetch NFT metadata failed, err: %v", fetchErr)
+	}
+
+	metadata.NFT = nft
+	return metadata, nil
+}
+
+// FetchNFTMetadata 获取并解析元数据JSON，支持http(s)，ipfs://，ar://，data:application/json
+func (wm *WalletManager) FetchNFTMetadata(uri string) (*NFTMetadata, error) {
+
+	if cached, ok := wm.nftMetadataCache.Get(uri); ok {
+		return cached.(*NFTMetadata).clone(), nil
+	}
+
+	var (
+		content     []byte
+		resolvedURI string
+		err         error
+	)
+
+	if strings.HasPrefix(uri, "data:") {
+		content, err = decodeDataURI(uri)
+	} else {
+		resolvedURI, err = wm.ResolveNFTURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		content, err = wm.httpGetWithLimit(resolvedURI)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := parseNFTMetadata(content)
+	if err != nil {
+		return nil, err
+	}
+	metadata.URI = uri
+	metadata.ResolvedURI = resolvedURI
+	if len(metadata.Image) > 0 && !strings.HasPrefix(metadata.Image, "data:") {
+		metadata.ImageURL, _ = wm.ResolveNFTURI(metadata.Image)
+	} else {
+		metadata.ImageURL = metadata.Image
+	}
+
+	wm.nftMetadataCache.Add(uri, metadata)
+	return metadata.clone(), nil
+}
+
+// clone 复制元数据，缓存的对象不能被调用方修改
+func (metadata *NFTMetadata) clone() *NFTMetadata {
+	cp := *metadata
+	cp.Attributes = make([]*NFTMetadataAttribute, 0, len(metadata.Attributes))
+	for _, attr := range metadata.Attributes {
+		a := *attr
+		cp.Attributes = append(cp.Attributes, &a)
+	}
+	return &cp
+}
+
+// ResolveNFTURI 通过配置的网关把ipfs://，ar://转为http地址
+func (wm *WalletManager) ResolveNFTURI(uri string) (string, error) {
+
+	uri = strings.TrimSpace(uri)
+	lower := strings.ToLower(uri)
+
+	switch {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+		return uri, nil
+	case strings.HasPrefix(lower, "ipfs://"):
+		path := uri[len("ipfs://"):]
+		//兼容ipfs://ipfs/<cid>的写法
+		path = strings.TrimPrefix(path, "ipfs/")
+		return joinGateway(wm.Config.NFTIPFSGateway, DefaultIPFSGateway, path), nil
+	case strings.HasPrefix(lower, "ar://"):
+		return joinGateway(wm.Config.NFTArweaveGateway, DefaultArweaveGateway, uri[len("ar://"):]), nil
+	default:
+		return "", fmt.Errorf("unsupported uri scheme: %s", uri)
+	}
+}
+
+// httpGetWithLimit http获取内容，超过最大字节数返回错误
+func (wm *WalletManager) httpGetWithLimit(uri string) ([]byte, error) {
+
+	maxSize := wm.Config.NFTMetadataMaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultNFTMetadataMaxSize
+	}
+
+	client := &http.Client{Timeout: nftMetadataTimeout}
+	//合约返回的地址不可信，只有配置的网关可以是内网地址
+	if !wm.isNFTGatewayURI(uri) {
+		dialer := &net.Dialer{Timeout: nftMetadataTimeout, Control: publicAddressControl}
+		client.Transport = &http.Transport{DialContext: dialer.DialContext}
+	}
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http get %s failed, status: %s", uri, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("content length %d exceeds limit %d", resp.ContentLength, maxSize)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("content exceeds limit %d", maxSize)
+	}
+	return content, nil
+}
+
+// isNFTGatewayURI uri是否指向配置的IPFS或Arweave网关
+func (wm *WalletManager) isNFTGatewayURI(uri string) bool {
+	target, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	for _, gateway := range []string{wm.Config.NFTIPFSGateway, wm.Config.NFTArweaveGateway} {
+		if g, parseErr := url.Parse(gateway); parseErr == nil && len(g.Host) > 0 && strings.EqualFold(g.Host, target.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicAddressControl 连接前检查解析后的IP，拒绝回环、内网、链路本地等地址，重定向和DNS重绑定同样会被检查
+func publicAddressControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("address %s is not allowed", host)
+	}
+	return nil
+}
+
+// isPublicIP 是否公网地址
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil && carrierGradeNAT.Contains(ip4) {
+		return false
+	}
+	return true
+}
+
+// decodeDataURI 解析data URI，支持base64和url编码
+func decodeDataURI(uri string) ([]byte, error) {
+
+	comma := strings.Index(uri, ",")
+	if comma < 0 {
+		return nil, fmt.Errorf("data uri format is invalid")
+	}
+	mediaType := strings.ToLower(uri[len("data:"):comma])
+	payload := uri[comma+1:]
+
+	if !strings.HasPrefix(mediaType, "application/json") {
+		return nil, fmt.Errorf("unsupported data uri media type: %s", mediaType)
+	}
+
+	if strings.HasSuffix(mediaType, ";base64") {
+		return base64.StdEncoding.DecodeString(payload)
+	}
+
+	decoded, err := url.PathUnescape(payload)
+	if err != nil {
+		//部分合约直接拼接未编码的JSON
+		return []byte(payload), nil
+	}
+	return []byte(decoded), nil
+}
+
+// parseNFTMetadata 解析元数据JSON
+func parseNFTMetadata(content []byte) (*NFTMetadata, error) {
+
+	if !gjson.ValidBytes(content) {
+		return nil, fmt.Errorf("metadata is not valid json")
+	}
+
+	obj := gjson.ParseBytes(content)
+	if !obj.IsObject() {
+		return nil, fmt.Errorf("metadata is not json object")
+	}
+
+	metadata := &NFTMetadata{
+		Name:         obj.Get("name").String(),
+		Description:  obj.Get("description").String(),
+		Image:        obj.Get("image").String(),
+		ExternalURL:  obj.Get("external_url").String(),
+		AnimationURL: obj.Get("animation_url").String(),
+		Attributes:   make([]*NFTMetadataAttribute, 0),
+		Raw:          string(content),
+	}
+	if len(metadata.Image) == 0 {
+		metadata.Image = obj.Get("image_url").String()
+	}
+
+	for _, attr := range obj.Get("attributes").Array() {
+		metadata.Attributes = append(metadata.Attributes, &NFTMetadataAttribute{
+			TraitType:   attr.Get("trait_type").String(),
+			Value:       attr.Get("value").Value(),
+			DisplayType: attr.Get("display_type").String(),
+		})
+	}
+
+	return metadata, nil
+}
+
+// replaceERC1155ID 替换ERC1155 URI中的{id}，id为64位小写16进制，不带0x
+func replaceERC1155ID(uri string, tokenID string) string {
+	if !strings.Contains(uri, "{id}") {
+		return uri
+	}
+	id, err := parseNumParam(tokenID)
+	if err != nil {
+		return uri
+	}
+	return strings.ReplaceAll(uri, "{id}", fmt.Sprintf("%064x", id))
+}
+
+func joinGateway(gateway, defaultGateway, path string) string {
+	if len(gateway) == 0 {
+		gateway = defaultGateway
+	}
+	if !strings.HasSuffix(gateway, "/") {
+		gateway = gateway + "/"
+	}
+	return gateway + path
+}
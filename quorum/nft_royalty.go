@@ -0,0 +1,82 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// ERC2981 接口ID
+	ERC2981InterfaceID = "0x2a55205a"
+)
+
+// NFTRoyalty ERC2981版税信息
+type NFTRoyalty struct {
+	NFT           *openwallet.NFT `json:"-"`
+	SalePrice     string          `json:"salePrice"`     //成交价格，最小单位
+	Receiver      string          `json:"receiver"`      //版税接收地址
+	RoyaltyAmount string          `json:"royaltyAmount"` //版税金额，与成交价格同单位
+}
+
+// SupportsRoyalty 合约是否支持ERC2981，结果会被缓存
+func (wm *WalletManager) SupportsRoyalty(contractAddr string) bool {
+	return wm.SupportsInterfaceID(contractAddr, ERC2981InterfaceID)
+}
+
+// GetNFTRoyaltyInfo 查询NFT按成交价格应付的版税，salePrice为最小单位的整数
+func (decoder *NFTContractDecoder) GetNFTRoyaltyInfo(nft *openwallet.NFT, salePrice string) (*NFTRoyalty, *openwallet.Error) {
+
+	if nft == nil || len(nft.TokenID) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT token id is empty")
+	}
+	price, numErr := parseNumParam(salePrice)
+	if numErr != nil || price.Sign() < 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT sale price is invalid")
+	}
+	if !decoder.wm.SupportsRoyalty(nft.Address) {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT contract is not support ERC2981")
+	}
+
+	result, err := decoder.wm.CallABI(nft.Address, ERC2981_ABI, "royaltyInfo", nft.TokenID, price.String())
+	if err != nil {
+		return nil, err
+	}
+	receiver, ok := result["receiver"].(ethcom.Address)
+	if !ok {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT royaltyInfo result is invalid")
+	}
+	amount, ok := result["royaltyAmount"].(*big.Int)
+	if !ok {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT royaltyInfo result is invalid")
+	}
+	//版税不应超过成交价格
+	if amount.Cmp(price) > 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "NFT royalty amount %s exceeds sale price %s", amount.String(), price.String())
+	}
+
+	royalty := &NFTRoyalty{
+		NFT:           nft,
+		SalePrice:     price.String(),
+		Receiver:      strings.ToLower(receiver.String()),
+		RoyaltyAmount: amount.String(),
+	}
+	return royalty, nil
+}
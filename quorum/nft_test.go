@@ -16,10 +16,17 @@
 package quorum
 
 import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 func TestWalletManager_erc721_GetNFTBalanceByAddress(t *testing.T) {
@@ -179,3 +186,353 @@ func TestWalletManager_erc1155_GetNFTTransfer(t *testing.T) {
 	}
 	log.Infof("tx: %v", tx)
 }
+
+func TestWalletManager_FetchNFTMetadata(t *testing.T) {
+	wm := testNewWalletManager()
+
+	uri := replaceERC1155ID("https://example.com/{id}.json", "314")
+	if uri != "https://example.com/000000000000000000000000000000000000000000000000000000000000013a.json" {
+		t.Errorf("replaceERC1155ID unexpected: %s", uri)
+	}
+
+	resolved, err := wm.ResolveNFTURI("ipfs://ipfs/QmYDvPAXtiJg7s8JdRBSLWdgSphQdac8j1YuQNNxcGE1hg/1")
+	if err != nil || resolved != wm.Config.NFTIPFSGateway+"QmYDvPAXtiJg7s8JdRBSLWdgSphQdac8j1YuQNNxcGE1hg/1" {
+		t.Errorf("ResolveNFTURI unexpected: %s, err: %v", resolved, err)
+	}
+
+	//data:application/json;base64,{"name":"Test","image":"ipfs://QmImage","attributes":[{"trait_type":"Level","value":5}]}
+	metadata, err := wm.FetchNFTMetadata("data:application/json;base64,eyJuYW1lIjoiVGVzdCIsImltYWdlIjoiaXBmczovL1FtSW1hZ2UiLCJhdHRyaWJ1dGVzIjpbeyJ0cmFpdF90eXBlIjoiTGV2ZWwiLCJ2YWx1ZSI6NX1dfQ==")
+	if err != nil {
+		t.Errorf("FetchNFTMetadata failed, err: %v", err)
+		return
+	}
+	if metadata.Name != "Test" || len(metadata.Attributes) != 1 || metadata.ImageURL != wm.Config.NFTIPFSGateway+"QmImage" {
+		t.Errorf("FetchNFTMetadata unexpected: %+v", metadata)
+	}
+	log.Infof("metadata: %+v", metadata)
+}
+
+func TestWalletManager_FetchNFTMetadata_PrivateAddress(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name":"Local","attributes":[{"trait_type":"Level","value":1}]}`)
+	}))
+	defer srv.Close()
+
+	wm := NewWalletManager()
+
+	//合约返回内网地址时拒绝请求
+	if _, err := wm.FetchNFTMetadata(srv.URL + "/1.json"); err == nil {
+		t.Errorf("metadata on loopback address should be rejected")
+	}
+
+	//配置的网关可以是内网地址
+	wm.Config.NFTIPFSGateway = srv.URL + "/ipfs/"
+	metadata, err := wm.FetchNFTMetadata("ipfs://QmLocal/1.json")
+	if err != nil {
+		t.Errorf("metadata on configured gateway should be fetched, err: %v", err)
+		return
+	}
+
+	//修改返回值不影响缓存
+	metadata.Name = "Changed"
+	metadata.Attributes[0].Value = 2
+	cached, _ := wm.FetchNFTMetadata("ipfs://QmLocal/1.json")
+	if cached.Name != "Local" || cached.Attributes[0].Value != float64(1) {
+		t.Errorf("cached metadata should not be modified by caller")
+	}
+}
+
+func TestWalletManager_GetLogsInRange(t *testing.T) {
+
+	ranges := make([]string, 0)
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		switch method {
+		case "eth_blockNumber":
+			return testRPCResult(`"0x2710"`)
+		case "eth_getLogs":
+			filter := params[0].(map[string]interface{})
+			ranges = append(ranges, fmt.Sprintf("%v-%v", filter["fromBlock"], filter["toBlock"]))
+			return testRPCResult(`[]`)
+		}
+		return ""
+	})
+
+	//没有起始区块不扫描
+	if _, err := wm.GetLogsInRange("0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", nil, -1); err == nil {
+		t.Errorf("GetLogsInRange without start block should fail")
+		return
+	}
+
+	//默认按区块范围分段查询
+	if _, err := wm.GetLogsInRange("0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", nil, 0); err != nil {
+		t.Errorf("GetLogsInRange error: %v", err)
+		return
+	}
+	expected := []string{"0x0-0x1387", "0x1388-0x270f", "0x2710-0x2710"}
+	if strings.Join(ranges, ",") != strings.Join(expected, ",") {
+		t.Errorf("log ranges: %v, expected: %v", ranges, expected)
+	}
+
+	//未指定起始区块时使用配置
+	ranges = ranges[:0]
+	wm.Config.LogScanStartBlock = 9000
+	if _, err := wm.GetLogsInRange("0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", nil, -1); err != nil {
+		t.Errorf("GetLogsInRange error: %v", err)
+		return
+	}
+	if strings.Join(ranges, ",") != "0x2328-0x2710" {
+		t.Errorf("log ranges from config start block: %v", ranges)
+	}
+}
+
+func TestEncodeNFTTransferData(t *testing.T) {
+	from := "0xe275c5f1714cc65ac667fb1be124aebd2d1ea5f9"
+	to := "0xd6b8ec0775abdce1e385c763b71eacff3991bad7"
+	extData := []byte{0xca, 0xfe}
+
+	//ERC721 safeTransferFrom(address,address,uint256,bytes)
+	data, err := encodeNFTTransferData(openwallet.InterfaceTypeERC721, from, to, []*big.Int{big.NewInt(5493)}, nil, extData)
+	if err != nil {
+		t.Errorf("encode ERC721 transfer failed, err: %v", err)
+		return
+	}
+	if method := hexutil.Encode(data[:4]); method != "0xb88d4fde" {
+		t.Errorf("ERC721 method id: %s, expected: 0xb88d4fde", method)
+	}
+	args, _ := ERC721_ABI.Methods["safeTransferFrom0"].Inputs.Unpack(data[4:])
+	if args[0].(ethcom.Address) != ethcom.HexToAddress(from) || args[1].(ethcom.Address) != ethcom.HexToAddress(to) ||
+		args[2].(*big.Int).Int64() != 5493 || hexutil.Encode(args[3].([]byte)) != "0xcafe" {
+		t.Errorf("ERC721 transfer args unexpected: %v", args)
+	}
+
+	//ERC1155 safeTransferFrom(address,address,uint256,uint256,bytes)
+	data, err = encodeNFTTransferData(openwallet.InterfaceTypeERC1155, from, to, []*big.Int{big.NewInt(17)}, []*big.Int{big.NewInt(3)}, nil)
+	if err != nil {
+		t.Errorf("encode ERC1155 transfer failed, err: %v", err)
+		return
+	}
+	if method := hexutil.Encode(data[:4]); method != "0xf242432a" {
+		t.Errorf("ERC1155 method id: %s, expected: 0xf242432a", method)
+	}
+	args, _ = ERC1155_ABI.Methods["safeTransferFrom"].Inputs.Unpack(data[4:])
+	if args[2].(*big.Int).Int64() != 17 || args[3].(*big.Int).Int64() != 3 || len(args[4].([]byte)) != 0 {
+		t.Errorf("ERC1155 transfer args unexpected: %v", args)
+	}
+
+	//ERC1155多个token使用safeBatchTransferFrom
+	data, _ = encodeNFTTransferData(openwallet.InterfaceTypeERC1155, from, to, []*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(1), big.NewInt(1)}, nil)
+	if method := hexutil.Encode(data[:4]); method != "0x2eb2c2d6" {
+		t.Errorf("ERC1155 batch method id: %s, expected: 0x2eb2c2d6", method)
+	}
+}
+
+func TestNFTContractDecoder_CreateNFTTransferRawTransaction_ContractAddress(t *testing.T) {
+	wm := NewWalletManager()
+	decoder := &NFTContractDecoder{wm: wm}
+	param := &NFTTransferParam{
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		To:      "0xd6b8ec0775abdce1e385c763b71eacff3991bad7",
+		Tokens: []*openwallet.NFT{
+			{Address: "0x5BABc381C7E9EdCF02654a9C30d384dFE54dd4A1", Protocol: openwallet.InterfaceTypeERC1155, TokenID: "1"},
+			{Address: "0x5babc381c7e9edcf02654a9c30d384dfe54dd4a1", Protocol: openwallet.InterfaceTypeERC1155, TokenID: "2"},
+		},
+	}
+
+	//合约地址大小写不同视为同一合约，继续查找账户地址
+	_, err := decoder.CreateNFTTransferRawTransaction(&testSequentialWallet{}, param)
+	if err == nil || err.Code() != openwallet.ErrAccountNotAddress {
+		t.Errorf("mixed case contract address should be accepted, err: %v", err)
+	}
+
+	param.Tokens[1].Address = "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"
+	_, err = decoder.CreateNFTTransferRawTransaction(&testSequentialWallet{}, param)
+	if err == nil || err.Code() != openwallet.ErrCreateRawSmartContractTransactionFailed {
+		t.Errorf("different contract address should be rejected, err: %v", err)
+	}
+}
+
+func TestWalletManager_erc721_GetNFTListByOwner(t *testing.T) {
+	wm := testNewWalletManager()
+
+	nft := &openwallet.NFT{
+		Symbol:   "ETH",
+		Address:  "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D",
+		Token:    "BoredApe",
+		Name:     "BoredApeYachtClub",
+		Protocol: openwallet.InterfaceTypeERC721,
+	}
+	owner := "0xe275c5f1714cc65ac667fb1be124aebd2d1ea5f9"
+
+	decoder := wm.NFTContractDecoder.(*NFTContractDecoder)
+	//BAYC合约部署区块
+	nfts, err := decoder.GetNFTListByOwnerFromBlock(nft, owner, 12287507)
+	if err != nil {
+		t.Errorf("erc721_GetNFTListByOwner error: %v", err)
+		return
+	}
+	for _, n := range nfts {
+		log.Infof("tokenID: %s", n.TokenID)
+	}
+}
+
+func TestWalletManager_GetNFTRoyaltyInfo(t *testing.T) {
+	wm := testNewWalletManager()
+
+	nft := &openwallet.NFT{
+		Symbol:   "ETH",
+		Address:  "0x34d85c9CDeB23FA97cb08333b511ac86E1C4E258",
+		Token:    "OTHR",
+		Name:     "Otherdeed",
+		Protocol: openwallet.InterfaceTypeERC721,
+		TokenID:  "1",
+	}
+	if !wm.SupportsRoyalty(nft.Address) {
+		log.Infof("contract is not support ERC2981")
+		return
+	}
+
+	decoder := wm.NFTContractDecoder.(*NFTContractDecoder)
+	royalty, err := decoder.GetNFTRoyaltyInfo(nft, "1000000000000000000")
+	if err != nil {
+		t.Errorf("GetNFTRoyaltyInfo error: %v", err)
+		return
+	}
+	log.Infof("receiver: %s, royaltyAmount: %s", royalty.Receiver, royalty.RoyaltyAmount)
+}
+
+func TestWalletManager_GetNFTBalanceByAddressMulti(t *testing.T) {
+	wm := testNewWalletManager()
+
+	nfts := []*openwallet.NFT{
+		{
+			Symbol:   "ETH",
+			Address:  "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D",
+			Protocol: openwallet.InterfaceTypeERC721,
+			TokenID:  "5493",
+		},
+		{
+			Symbol:   "ETH",
+			Address:  "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D",
+			Protocol: openwallet.InterfaceTypeERC721,
+		},
+		{
+			Symbol:   "ETH",
+			Address:  "0x5BABc381C7E9EdCF02654a9C30d384dFE54dd4A1",
+			Protocol: openwallet.InterfaceTypeERC1155,
+			TokenID:  "17",
+		},
+		{
+			Symbol:   "ETH",
+			Address:  "0x5BABc381C7E9EdCF02654a9C30d384dFE54dd4A1",
+			Protocol: openwallet.InterfaceTypeERC1155,
+		},
+	}
+	owners := []string{
+		"0xe275c5f1714cc65ac667fb1be124aebd2d1ea5f9",
+		"0xe275c5f1714cc65ac667fb1be124aebd2d1ea5f9",
+		"0xd6b8ec0775abdce1e385c763b71eacff3991bad7",
+		"0xd6b8ec0775abdce1e385c763b71eacff3991bad7",
+	}
+
+	decoder := wm.NFTContractDecoder.(*NFTContractDecoder)
+	results, err := decoder.GetNFTBalanceByAddressMulti(nfts, owners)
+	if err != nil {
+		t.Errorf("GetNFTBalanceByAddressMulti error: %v", err)
+		return
+	}
+	for i, result := range results {
+		if result.Error != nil {
+			log.Infof("[%d] error: %v", i, result.Error)
+			continue
+		}
+		log.Infof("[%d] balance: %s", i, result.Balance.Balance)
+	}
+	//ERC1155未指定tokenID应返回单项错误
+	if results[3].Error == nil {
+		t.Errorf("ERC1155 without token id should return error")
+	}
+}
+
+func TestNFTContractDecoder_GetNFTBalanceByAddressMulti_CallError(t *testing.T) {
+
+	var multicallResponse string
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		call := params[0].(map[string]interface{})
+		switch {
+		case strings.EqualFold(call["to"].(string), DefaultMulticallAddress):
+			return multicallResponse
+		case strings.HasSuffix(call["data"].(string), "01"):
+			//tokenID 1 不存在，合约回滚
+			return testRPCError(3, "execution reverted")
+		default:
+			return testRPCError(-32005, "limit exceeded")
+		}
+	})
+	decoder := &NFTContractDecoder{wm: wm}
+	nfts := []*openwallet.NFT{
+		{Address: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", Protocol: openwallet.InterfaceTypeERC721, TokenID: "1"},
+		{Address: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D", Protocol: openwallet.InterfaceTypeERC721, TokenID: "2"},
+	}
+	owners := []string{"0xe275c5f1714cc65ac667fb1be124aebd2d1ea5f9", "0xe275c5f1714cc65ac667fb1be124aebd2d1ea5f9"}
+
+	//Multicall未部署时逐个调用，回滚视为不拥有，节点错误返回单项错误
+	multicallResponse = `{"jsonrpc":"2.0","id":1,"result":"0x"}`
+	results, err := decoder.GetNFTBalanceByAddressMulti(nfts, owners)
+	if err != nil {
+		t.Errorf("GetNFTBalanceByAddressMulti error: %v", err)
+		return
+	}
+	if results[0].Error != nil || results[0].Balance.Balance != "0" {
+		t.Errorf("reverted ownerOf should be balance 0, got: %+v", results[0])
+	}
+	if results[1].Error == nil || results[1].Balance != nil {
+		t.Errorf("node error should not be reported as balance 0")
+	}
+
+	//Multicall结果正常解析
+	owner := ethcom.HexToAddress(owners[0])
+	ownerData, _ := ERC721_ABI.Methods["ownerOf"].Outputs.Pack(owner)
+	returnData, _ := MULTICALL3_ABI.Methods["aggregate3"].Outputs.Pack([]struct {
+		Success    bool
+		ReturnData []byte
+	}{{Success: false, ReturnData: []byte{}}, {Success: true, ReturnData: ownerData}})
+	multicallResponse = fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"result":"%s"}`, hexutil.Encode(returnData))
+	results, err = decoder.GetNFTBalanceByAddressMulti(nfts, owners)
+	if err != nil {
+		t.Errorf("GetNFTBalanceByAddressMulti error: %v", err)
+		return
+	}
+	if results[0].Balance.Balance != "0" || results[1].Balance == nil || results[1].Balance.Balance != big.NewInt(1).String() {
+		t.Errorf("multicall balances unexpected: %+v, %+v", results[0], results[1])
+	}
+}
+
+func TestWalletManager_erc721_GetNFTApproved(t *testing.T) {
+	wm := testNewWalletManager()
+
+	nft := &openwallet.NFT{
+		Symbol:   "ETH",
+		Address:  "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D",
+		Token:    "BoredApe",
+		Name:     "BoredApeYachtClub",
+		Protocol: openwallet.InterfaceTypeERC721,
+		TokenID:  "5493",
+	}
+	decoder := wm.NFTContractDecoder.(*NFTContractDecoder)
+	approved, err := decoder.GetNFTApproved(nft)
+	if err != nil {
+		t.Errorf("GetNFTApproved error: %v", err)
+		return
+	}
+	log.Infof("approved: %s", approved)
+
+	owner := "0xe275c5f1714cc65ac667fb1be124aebd2d1ea5f9"
+	operator := "0x1E0049783F008A0085193E00003D00cd54003c71"
+	approvedForAll, err := decoder.IsNFTApprovedForAll(nft, owner, operator)
+	if err != nil {
+		t.Errorf("IsNFTApprovedForAll error: %v", err)
+		return
+	}
+	log.Infof("approvedForAll: %v", approvedForAll)
+}
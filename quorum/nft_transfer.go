@@ -0,0 +1,224 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// NFTTransferParam NFT转账参数
+type NFTTransferParam struct {
+	Account *openwallet.AssetsAccount //发送账户
+	To      string                    //接收地址
+	Tokens  []*openwallet.NFT         //转账的NFT，必须属于同一合约，ERC721只支持一个
+	Amounts []string                  //ERC1155转账数量，与Tokens一一对应，为空默认1，ERC721忽略
+	Data    string                    //附带数据，hex编码
+	FeeRate string                    //gasPrice，为空自动估算
+}
+
+// CreateNFTTransferRawTransaction 创建ERC721/ERC1155 safeTransferFrom/safeBatchTransferFrom交易单，
+// 检查NFT所有权/余额和手续费，返回待签名的交易单
+func (decoder *NFTContractDecoder) CreateNFTTransferRawTransaction(wrapper openwallet.WalletDAI, param *NFTTransferParam) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if param == nil || param.Account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT transfer account is empty")
+	}
+	if len(param.Tokens) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT transfer tokens is empty")
+	}
+	if len(param.Amounts) > 0 && len(param.Amounts) != len(param.Tokens) {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT amounts length is not equal to tokens length")
+	}
+	if len(param.To) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT receiver address is empty")
+	}
+
+	//检查合约，地址不区分大小写
+	contractAddress := param.Tokens[0].Address
+	for _, token := range param.Tokens {
+		if !strings.EqualFold(token.Address, contractAddress) {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFTs contract address is inconsistent")
+		}
+		if len(token.TokenID) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT token id is empty")
+		}
+	}
+
+	contractDecoder := decoder.contractDecoder()
+	defAddress, addrErr := contractDecoder.GetAssetsAccountDefAddress(wrapper, param.Account.AccountID)
+	if addrErr != nil {
+		return nil, addrErr
+	}
+	from := strings.ToLower(decoder.wm.CustomAddressDecodeFunc(defAddress.Address))
+	to := decoder.wm.CustomAddressDecodeFunc(param.To)
+
+	//检查协议
+	protocol := param.Tokens[0].Protocol
+	if len(protocol) == 0 {
+		protocol = decoder.wm.SupportsInterface(contractAddress)
+	}
+
+	extData, err := hexutil.Decode(AppendOxToAddress(param.Data))
+	if len(param.Data) > 0 && err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT transfer data is not hex")
+	}
+
+	var (
+		data    []byte
+		abiJSON string
+	)
+	switch protocol {
+	case openwallet.InterfaceTypeERC721:
+		if len(param.Tokens) > 1 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "ERC721 only support transfer one token")
+		}
+		nft := *param.Tokens[0]
+		nft.Protocol = protocol
+		tokenID, numErr := parseNumParam(nft.TokenID)
+		if numErr != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT token id is invalid")
+		}
+
+		//检查NFT所有权
+		nftOwner, ownerErr := decoder.GetNFTOwnerByTokenID(&nft)
+		if ownerErr != nil {
+			return nil, ownerErr
+		}
+		if nftOwner.Owner != from {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientTokenBalanceOfAddress, "the NFT [%s] is not owned by %s", nft.TokenID, from)
+		}
+
+		data, err = encodeNFTTransferData(protocol, from, to, []*big.Int{tokenID}, nil, extData)
+		abiJSON = ERC721_ABI_JSON
+	case openwallet.InterfaceTypeERC1155:
+		ids := make([]*big.Int, 0, len(param.Tokens))
+		amounts := make([]*big.Int, 0, len(param.Tokens))
+		for i, token := range param.Tokens {
+			nft := *token
+			nft.Protocol = protocol
+			tokenID, numErr := parseNumParam(nft.TokenID)
+			if numErr != nil {
+				return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT token id is invalid")
+			}
+			amount := big.NewInt(1)
+			if len(param.Amounts) > 0 {
+				amount, numErr = parseNumParam(param.Amounts[i])
+				if numErr != nil || amount.Sign() <= 0 {
+					return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT amount is invalid")
+				}
+			}
+
+			//检查NFT余额
+			nftBalance, balanceErr := decoder.GetNFTBalanceByAddress(&nft, from)
+			if balanceErr != nil {
+				return nil, balanceErr
+			}
+			balance, _ := new(big.Int).SetString(nftBalance.Balance, 10)
+			if balance == nil || balance.Cmp(amount) < 0 {
+				return nil, openwallet.Errorf(openwallet.ErrInsufficientTokenBalanceOfAddress, "the NFT [%s] balance: %s is not enough", nft.TokenID, nftBalance.Balance)
+			}
+			ids = append(ids, tokenID)
+			amounts = append(amounts, amount)
+		}
+
+		data, err = encodeNFTTransferData(protocol, from, to, ids, amounts, extData)
+		abiJSON = ERC1155_ABI_JSON
+	default:
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT interface type is not support")
+	}
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "NFT transfer abi pack failed, err: %v", err)
+	}
+
+	callMsg := CallMsg{
+		From:  ethcom.HexToAddress(from),
+		To:    ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(contractAddress)),
+		Data:  data,
+		Value: big.NewInt(0),
+	}
+	raw, err := callMsg.MarshalJSON()
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+
+	contract := openwallet.SmartContract{
+		ContractID: openwallet.GenContractID(decoder.wm.Symbol(), contractAddress),
+		Symbol:     decoder.wm.Symbol(),
+		Address:    contractAddress,
+		Token:      param.Tokens[0].Token,
+		Protocol:   protocol,
+		Name:       param.Tokens[0].Name,
+	}
+	contract.SetABI(abiJSON)
+
+	rawTx := &openwallet.SmartContractRawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     decoder.wm.Symbol(),
+			IsContract: true,
+			ContractID: contract.ContractID,
+			Contract:   contract,
+		},
+		Account: param.Account,
+		Raw:     string(raw),
+		RawType: openwallet.TxRawTypeJSON,
+		Value:   "0",
+		FeeRate: param.FeeRate,
+	}
+
+	//估算手续费，检查手续费余额，生成待签名消息
+	if createErr := contractDecoder.CreateSmartContractRawTransaction(wrapper, rawTx); createErr != nil {
+		return nil, createErr
+	}
+
+	return rawTx, nil
+}
+
+// encodeNFTTransferData 编码NFT转账的调用数据，ERC721只转一个token，ERC1155多个token使用safeBatchTransferFrom
+func encodeNFTTransferData(protocol, from, to string, ids, amounts []*big.Int, extData []byte) ([]byte, error) {
+	if extData == nil {
+		extData = []byte{}
+	}
+	switch protocol {
+	case openwallet.InterfaceTypeERC721:
+		//safeTransferFrom(address,address,uint256,bytes) 重载方法名为safeTransferFrom0
+		return ERC721_ABI.Pack("safeTransferFrom0", ethcom.HexToAddress(from), ethcom.HexToAddress(to), ids[0], extData)
+	case openwallet.InterfaceTypeERC1155:
+		if len(ids) == 1 {
+			return ERC1155_ABI.Pack("safeTransferFrom", ethcom.HexToAddress(from), ethcom.HexToAddress(to), ids[0], amounts[0], extData)
+		}
+		return ERC1155_ABI.Pack("safeBatchTransferFrom", ethcom.HexToAddress(from), ethcom.HexToAddress(to), ids, amounts, extData)
+	}
+	return nil, fmt.Errorf("NFT interface type is not support")
+}
+
+// SubmitNFTTransferRawTransaction 广播已签名的NFT转账交易单
+func (decoder *NFTContractDecoder) SubmitNFTTransferRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractReceipt, *openwallet.Error) {
+	return decoder.contractDecoder().SubmitSmartContractRawTransaction(wrapper, rawTx)
+}
+
+// contractDecoder 智能合约解释器
+func (decoder *NFTContractDecoder) contractDecoder() *EthContractDecoder {
+	if contractDecoder, ok := decoder.wm.ContractDecoder.(*EthContractDecoder); ok {
+		return contractDecoder
+	}
+	return &EthContractDecoder{wm: decoder.wm}
+}
@@ -0,0 +1,449 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 默认nonce预留有效期，秒，超时未广播自动释放
+	DefaultNonceReservationTTL = 10 * 60
+)
+
+// NonceGap 地址的nonce缺口，缺口之后的交易不会被打包
+type NonceGap struct {
+	Address      string   `json:"address"`
+	LatestNonce  uint64   `json:"latestNonce"`  //已上链的交易数
+	PendingNonce uint64   `json:"pendingNonce"` //节点交易池中连续可执行的下一个nonce
+	Missing      []uint64 `json:"missing"`      //没有广播的nonce，需要补发交易
+	Blocked      []uint64 `json:"blocked"`      //已广播但节点未计入pending的nonce，第一个通常已被节点丢弃
+	Reserved     []uint64 `json:"reserved"`     //已预留还未广播的nonce
+}
+
+// HasGap 是否存在阻塞后续交易的缺口
+func (gap *NonceGap) HasGap() bool {
+	return len(gap.Blocked) > 0
+}
+
+// addressNonceState 地址的nonce分配状态
+type addressNonceState struct {
+	Address     string            `json:"address"`
+	Reserved    map[uint64]int64  `json:"reserved"`    //预留的nonce -> 预留时间
+	Submitted   map[uint64]string `json:"submitted"`   //已广播的nonce -> txid
+	SubmittedAt map[uint64]int64  `json:"submittedAt"` //已广播的nonce -> 广播时间
+	mu          sync.Mutex
+}
+
+// NonceManager 地址nonce管理，按地址原子地预留nonce，并持久化未完成的预留和已广播的记录
+type NonceManager struct {
+	dir       string //为空则只保存在内存
+	ttl       time.Duration
+	wm        *WalletManager
+	addresses map[string]*addressNonceState
+	mu        sync.Mutex
+}
+
+// NewNonceManager 创建nonce管理，dir为空则不持久化
+func NewNonceManager(wm *WalletManager, dir string, ttl time.Duration) (*NonceManager, error) {
+
+	if len(dir) > 0 {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	m := &NonceManager{
+		dir:       dir,
+		ttl:       ttl,
+		wm:        wm,
+		addresses: make(map[string]*addressNonceState),
+	}
+	return m, nil
+}
+
+// state 获取地址状态，内存没有则从文件加载，文件无法解析返回错误，避免重复分配已使用的nonce
+func (m *NonceManager) state(address string) (*addressNonceState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	address = strings.ToLower(address)
+	if s, exist := m.addresses[address]; exist {
+		return s, nil
+	}
+
+	s := &addressNonceState{
+		Address:     address,
+		Reserved:    make(map[uint64]int64),
+		Submitted:   make(map[uint64]string),
+		SubmittedAt: make(map[uint64]int64),
+	}
+	if len(m.dir) > 0 {
+		content, err := ioutil.ReadFile(filepath.Join(m.dir, address+".json"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read address %s nonce state failed, err: %v", address, err)
+		}
+		if err == nil {
+			if err = json.Unmarshal(content, s); err != nil {
+				return nil, fmt.Errorf("parse address %s nonce state failed, err: %v", address, err)
+			}
+			if s.Reserved == nil {
+				s.Reserved = make(map[uint64]int64)
+			}
+			if s.Submitted == nil {
+				s.Submitted = make(map[uint64]string)
+			}
+			if s.SubmittedAt == nil {
+				s.SubmittedAt = make(map[uint64]int64)
+			}
+		}
+	}
+	m.addresses[address] = s
+	return s, nil
+}
+
+func (m *NonceManager) save(s *addressNonceState) {
+	if len(m.dir) == 0 {
+		return
+	}
+	content, err := json.Marshal(s)
+	if err != nil {
+		m.wm.Log.Errorf("marshal address %s nonce state failed, err: %v", s.Address, err)
+		return
+	}
+	if err = writeFileAtomic(filepath.Join(m.dir, s.Address+".json"), content); err != nil {
+		m.wm.Log.Errorf("save address %s nonce state failed, err: %v", s.Address, err)
+	}
+}
+
+// prune 清理已被链上或交易池使用的nonce、过期的预留和已被节点丢弃的广播记录
+func (m *NonceManager) prune(s *addressNonceState, pending uint64) {
+	now := time.Now().Unix()
+	expired := func(at int64) bool {
+		return m.ttl > 0 && now-at > int64(m.ttl/time.Second)
+	}
+	for nonce, reservedAt := range s.Reserved {
+		if nonce < pending || expired(reservedAt) {
+			delete(s.Reserved, nonce)
+		}
+	}
+	for nonce, txid := range s.Submitted {
+		if nonce < pending {
+			delete(s.Submitted, nonce)
+			delete(s.SubmittedAt, nonce)
+			continue
+		}
+		//超时仍未被计入pending，且节点已查不到该交易或没有记录txid，视为被丢弃，nonce可重新分配
+		if expired(s.SubmittedAt[nonce]) && (len(txid) == 0 || m.transactionDropped(txid)) {
+			m.wm.Log.Warningf("address %s nonce %d transaction %s was dropped, nonce released", s.Address, nonce, txid)
+			delete(s.Submitted, nonce)
+			delete(s.SubmittedAt, nonce)
+		}
+	}
+}
+
+// transactionDropped 节点交易池和链上都查不到交易，查询失败时视为未丢弃
+func (m *NonceManager) transactionDropped(txid string) bool {
+	if m.wm.WalletClient == nil {
+		return false
+	}
+	result, err := m.wm.WalletClient.Call("eth_getTransactionByHash", []interface{}{AppendOxToAddress(txid)})
+	if err != nil {
+		return false
+	}
+	return !result.IsObject()
+}
+
+// Reserve 为地址预留下一个可用nonce，优先复用已释放的nonce以避免产生缺口
+func (m *NonceManager) Reserve(address string) (uint64, error) {
+
+	s, err := m.state(address)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, err := m.wm.GetPendingTransactionCount(address)
+	if err != nil {
+		return 0, err
+	}
+	m.prune(s, pending)
+
+	nonce := m.nextNonce(s, pending)
+	s.Reserved[nonce] = time.Now().Unix()
+	m.save(s)
+	return nonce, nil
+}
+
+// ReserveSequence 为地址预留count个连续的nonce，返回第一个nonce，批量交易需要按顺序上链
+func (m *NonceManager) ReserveSequence(address string, count int) (uint64, error) {
+
+	if count <= 0 {
+		return 0, fmt.Errorf("reserve nonce count must be greater than 0")
+	}
+
+	s, err := m.state(address)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, err := m.wm.GetPendingTransactionCount(address)
+	if err != nil {
+		return 0, err
+	}
+	m.prune(s, pending)
+
+	//从第一个可用nonce开始，遇到已占用的nonce则从其后重新查找
+	start := m.nextNonce(s, pending)
+	for i := 0; i < count; i++ {
+		nonce := start + uint64(i)
+		if !m.nonceAvailable(s, nonce) {
+			start = m.nextNonce(s, nonce+1)
+			i = -1
+		}
+	}
+
+	now := time.Now().Unix()
+	for i := 0; i < count; i++ {
+		s.Reserved[start+uint64(i)] = now
+	}
+	m.save(s)
+	return start, nil
+}
+
+// Commit 交易广播成功，记录nonce对应的txid
+func (m *NonceManager) Commit(address string, nonce uint64, txid string) {
+	s, err := m.state(address)
+	if err != nil {
+		m.wm.Log.Errorf("commit address %s nonce %d failed, err: %v", address, nonce, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.Reserved, nonce)
+	s.Submitted[nonce] = txid
+	s.SubmittedAt[nonce] = time.Now().Unix()
+	m.save(s)
+}
+
+// Release 交易放弃构建或广播失败，释放预留的nonce
+func (m *NonceManager) Release(address string, nonce uint64) {
+	s, err := m.state(address)
+	if err != nil {
+		m.wm.Log.Errorf("release address %s nonce %d failed, err: %v", address, nonce, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exist := s.Reserved[nonce]; !exist {
+		return
+	}
+	delete(s.Reserved, nonce)
+	m.save(s)
+}
+
+// Peek 查询地址下一个可分配的nonce，不预留
+func (m *NonceManager) Peek(address string) (uint64, error) {
+	s, err := m.state(address)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, err := m.wm.GetPendingTransactionCount(address)
+	if err != nil {
+		return 0, err
+	}
+	return m.nextNonce(s, pending), nil
+}
+
+// nextNonce 从pending nonce开始查找第一个未预留且未广播的nonce
+func (m *NonceManager) nextNonce(s *addressNonceState, pending uint64) uint64 {
+	nonce := pending
+	for {
+		if m.nonceAvailable(s, nonce) {
+			return nonce
+		}
+		nonce++
+	}
+}
+
+// nonceAvailable nonce是否未预留且未广播
+func (m *NonceManager) nonceAvailable(s *addressNonceState, nonce uint64) bool {
+	_, reserved := s.Reserved[nonce]
+	_, submitted := s.Submitted[nonce]
+	//NonceComputeMode = 1时，只以节点的pending nonce为准
+	if m.wm.Config.NonceComputeMode == 1 {
+		submitted = false
+	}
+	return !reserved && !submitted
+}
+
+// DetectGap 检查地址已广播的交易是否存在nonce缺口
+func (m *NonceManager) DetectGap(address string) (*NonceGap, error) {
+
+	s, err := m.state(address)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latest, err := m.wm.GetTransactionCount(address)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := m.wm.GetPendingTransactionCount(address)
+	if err != nil {
+		return nil, err
+	}
+	m.prune(s, pending)
+	m.save(s)
+
+	gap := &NonceGap{
+		Address:      s.Address,
+		LatestNonce:  latest,
+		PendingNonce: pending,
+		Missing:      make([]uint64, 0),
+		Blocked:      make([]uint64, 0),
+		Reserved:     make([]uint64, 0),
+	}
+
+	//prune后剩下的记录都不小于pending nonce，已广播的都未被节点计入pending
+	maxSubmitted := uint64(0)
+	for nonce := range s.Submitted {
+		gap.Blocked = append(gap.Blocked, nonce)
+		if nonce > maxSubmitted {
+			maxSubmitted = nonce
+		}
+	}
+	for nonce := range s.Reserved {
+		gap.Reserved = append(gap.Reserved, nonce)
+	}
+	if len(gap.Blocked) > 0 {
+		for nonce := pending; nonce < maxSubmitted; nonce++ {
+			if _, submitted := s.Submitted[nonce]; !submitted {
+				gap.Missing = append(gap.Missing, nonce)
+			}
+		}
+	}
+
+	sortNonces(gap.Blocked)
+	sortNonces(gap.Reserved)
+	if gap.HasGap() {
+		m.wm.Log.Warningf("address %s nonce gap detected, pending: %d, missing: %v, blocked: %v", s.Address, pending, gap.Missing, gap.Blocked)
+	}
+	return gap, nil
+}
+
+func sortNonces(nonces []uint64) {
+	sort.Slice(nonces, func(i, j int) bool {
+		return nonces[i] < nonces[j]
+	})
+}
+
+// GetPendingTransactionCount 查询地址包含交易池的交易数
+func (wm *WalletManager) GetPendingTransactionCount(addr string) (uint64, error) {
+	addr = wm.CustomAddressDecodeFunc(addr)
+	params := []interface{}{
+		AppendOxToAddress(addr),
+		"pending",
+	}
+
+	if wm.WalletClient == nil {
+		return 0, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "wallet client is not initialized")
+	}
+
+	result, err := wm.WalletClient.Call("eth_getTransactionCount", params)
+	if err != nil {
+		return 0, err
+	}
+	return hexutil.DecodeUint64(result.String())
+}
+
+// selectTxNonce 选择构建交易使用的nonce，扩展参数指定nonce时作为第一个nonce，
+// 否则通过NonceManager原子地预留count个连续的nonce，reserved为true表示构建失败时需要释放
+func (wm *WalletManager) selectTxNonce(address string, extParam gjson.Result, count int) (start uint64, reserved bool, err error) {
+	if nonce := extParam.Get("nonce"); nonce.Exists() {
+		return nonce.Uint(), false, nil
+	}
+	if count == 1 {
+		start, err = wm.NonceManager.Reserve(address)
+	} else {
+		start, err = wm.NonceManager.ReserveSequence(address, count)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return start, true, nil
+}
+
+// releaseTxNonce 释放selectTxNonce预留的nonce，扩展参数指定的nonce不处理
+func (wm *WalletManager) releaseTxNonce(address string, start uint64, count int, reserved bool) {
+	if !reserved {
+		return
+	}
+	for i := 0; i < count; i++ {
+		wm.NonceManager.Release(address, start+uint64(i))
+	}
+}
+
+// releaseKeySignatureNonce 释放交易单签名信息中预留的nonce
+func (wm *WalletManager) releaseKeySignatureNonce(keySignatures []*openwallet.KeySignature) {
+	for _, keySignature := range keySignatures {
+		if keySignature == nil || keySignature.Address == nil || len(keySignature.Nonce) == 0 {
+			continue
+		}
+		nonce, err := hexutil.DecodeUint64(keySignature.Nonce)
+		if err != nil {
+			continue
+		}
+		wm.NonceManager.Release(keySignature.Address.Address, nonce)
+	}
+}
+
+// ReleaseRawTransactionNonce 放弃已构建但不广播的交易单，释放预留的nonce
+func (decoder *EthTransactionDecoder) ReleaseRawTransactionNonce(rawTx *openwallet.RawTransaction) {
+	if rawTx == nil || rawTx.Account == nil || rawTx.IsSubmit {
+		return
+	}
+	decoder.wm.releaseKeySignatureNonce(rawTx.Signatures[rawTx.Account.AccountID])
+}
+
+// ReleaseSmartContractRawTransactionNonce 放弃已构建但不广播的合约交易单，释放预留的nonce
+func (decoder *EthContractDecoder) ReleaseSmartContractRawTransactionNonce(rawTx *openwallet.SmartContractRawTransaction) {
+	if rawTx == nil || rawTx.Account == nil || rawTx.IsSubmit {
+		return
+	}
+	decoder.wm.releaseKeySignatureNonce(rawTx.Signatures[rawTx.Account.AccountID])
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/tidwall/gjson"
+)
+
+func TestNonceManager_Reserve(t *testing.T) {
+	wm := testNewWalletManager()
+	manager, _ := NewNonceManager(wm, t.TempDir(), time.Minute)
+	address := "0xd35c2cd2d6e6e1b8a4c4e3a9f8b2e5bd1a4e5c37"
+
+	//并发预留的nonce不重复
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		nonces = make(map[uint64]bool)
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce, err := manager.Reserve(address)
+			if err != nil {
+				t.Errorf("Reserve error: %v", err)
+				return
+			}
+			mu.Lock()
+			nonces[nonce] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(nonces) != 5 {
+		t.Errorf("reserved nonces duplicated: %v", nonces)
+		return
+	}
+
+	//释放的nonce被优先复用
+	pending, _ := wm.GetPendingTransactionCount(address)
+	manager.Release(address, pending)
+	nonce, err := manager.Reserve(address)
+	if err != nil || nonce != pending {
+		t.Errorf("released nonce should be reused, expect %d, got %d, err: %v", pending, nonce, err)
+		return
+	}
+
+	gap, err := manager.DetectGap(address)
+	if err != nil {
+		t.Errorf("DetectGap error: %v", err)
+		return
+	}
+	log.Infof("nonce gap: %+v", gap)
+}
+
+func TestNonceManager_ReserveDroppedNonce(t *testing.T) {
+
+	var (
+		mu     sync.Mutex
+		inPool = map[string]bool{}
+	)
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch method {
+		case "eth_getTransactionByHash":
+			if inPool[params[0].(string)] {
+				return testRPCResult(`{"blockNumber":null}`)
+			}
+			return ""
+		default:
+			return testRPCResult(`"0x5"`)
+		}
+	})
+	manager, _ := NewNonceManager(wm, "", time.Minute)
+	address := "0xd35c2cd2d6e6e1b8a4c4e3a9f8b2e5bd1a4e5c37"
+	droppedTxID := "0x1111111111111111111111111111111111111111111111111111111111111111"
+	queuedTxID := "0x2222222222222222222222222222222222222222222222222222222222222222"
+	inPool[queuedTxID] = true
+
+	manager.Commit(address, 5, droppedTxID)
+	manager.Commit(address, 6, queuedTxID)
+
+	//刚广播的交易即使节点查不到也不重新分配
+	if nonce, _ := manager.Reserve(address); nonce != 7 {
+		t.Errorf("recently submitted nonce should not be reused, got: %d", nonce)
+	}
+
+	s, _ := manager.state(address)
+	s.mu.Lock()
+	s.SubmittedAt[5] = time.Now().Add(-2 * time.Minute).Unix()
+	s.SubmittedAt[6] = time.Now().Add(-2 * time.Minute).Unix()
+	s.mu.Unlock()
+
+	//超时且被节点丢弃的nonce重新分配，仍在交易池中的保留
+	if nonce, _ := manager.Reserve(address); nonce != 5 {
+		t.Errorf("dropped nonce should be reused, got: %d", nonce)
+	}
+	if nonce, _ := manager.Reserve(address); nonce != 8 {
+		t.Errorf("next nonce: %d, expected: 8", nonce)
+	}
+}
+
+func TestNonceManager_State(t *testing.T) {
+
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		return testRPCResult(`"0x5"`)
+	})
+	dir := t.TempDir()
+	manager, _ := NewNonceManager(wm, dir, time.Minute)
+	address := "0xd35c2cd2d6e6e1b8a4c4e3a9f8b2e5bd1a4e5c37"
+
+	//旧接口记录已使用的nonce
+	wm.NonceManager = manager
+	wm.UpdateAddressNonce(nil, address, 6)
+	reloaded, _ := NewNonceManager(wm, dir, time.Minute)
+	if nonce, err := reloaded.Reserve(address); err != nil || nonce != 6 {
+		t.Errorf("nonce recorded by UpdateAddressNonce should be skipped, got: %d, err: %v", nonce, err)
+	}
+
+	//状态文件无法解析时返回错误，不从空状态开始分配
+	ioutil.WriteFile(filepath.Join(dir, address+".json"), []byte("{"), 0644)
+	corrupted, _ := NewNonceManager(wm, dir, time.Minute)
+	if _, err := corrupted.Reserve(address); err == nil {
+		t.Errorf("corrupted nonce state should return error")
+	}
+}
+
+func TestWalletManager_selectTxNonce(t *testing.T) {
+
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		return testRPCResult(`"0x5"`)
+	})
+	wm.NonceManager, _ = NewNonceManager(wm, "", time.Minute)
+	address := "0xd35c2cd2d6e6e1b8a4c4e3a9f8b2e5bd1a4e5c37"
+
+	//扩展参数指定的nonce不预留
+	nonce, reserved, err := wm.selectTxNonce(address, gjson.Parse(`{"nonce":9}`), 1)
+	if err != nil || nonce != 9 || reserved {
+		t.Errorf("ext param nonce unexpected: %d, reserved: %v, err: %v", nonce, reserved, err)
+	}
+
+	nonce, reserved, err = wm.selectTxNonce(address, gjson.Parse(`{}`), 2)
+	if err != nil || nonce != 5 || !reserved {
+		t.Errorf("reserved nonce unexpected: %d, reserved: %v, err: %v", nonce, reserved, err)
+	}
+	if next, _ := wm.NonceManager.Peek(address); next != 7 {
+		t.Errorf("next nonce after reserving sequence: %d, expected: 7", next)
+	}
+
+	//释放后重新分配
+	wm.releaseTxNonce(address, nonce, 2, reserved)
+	if next, _ := wm.NonceManager.Peek(address); next != 5 {
+		t.Errorf("next nonce after release: %d, expected: 5", next)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// PersonalMessageHash 计算EIP-191 personal_sign摘要，keccak256("\x19Ethereum Signed Message:\n" + len(message) + message)
+func PersonalMessageHash(message []byte) []byte {
+	rawData := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), string(message))
+	return crypto.Keccak256([]byte(rawData))
+}
+
+// ParsePersonalMessage 解析personal_sign的消息，0x开头的合法hex按字节解码，否则按UTF-8原文处理
+func ParsePersonalMessage(message string) []byte {
+	if strings.HasPrefix(message, "0x") {
+		if data, err := hexutil.Decode(message); err == nil {
+			return data
+		}
+	}
+	return []byte(message)
+}
+
+// SignPersonalMessage 使用地址的HD私钥对消息进行EIP-191签名，签名格式与EIP-712一致
+func (decoder *EthTransactionDecoder) SignPersonalMessage(wrapper openwallet.WalletDAI, address *openwallet.Address, message []byte) (*TypedDataSignature, error) {
+
+	hash := PersonalMessageHash(message)
+	signature, err := decoder.wm.signHashWithHDKey(wrapper, address, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTypedDataSignature(decoder.wm.CustomAddressDecodeFunc(address.Address), hash, signature), nil
+}
+
+// RecoverSignatureAddress 从32字节摘要和r+s+v签名恢复签名地址，v支持0/1和27/28
+func (wm *WalletManager) RecoverSignatureAddress(hash []byte, signature string) (string, error) {
+
+	sig, err := hexutil.Decode(AppendOxToAddress(signature))
+	if err != nil || len(sig) != 65 {
+		return "", openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "signature is invalid")
+	}
+	if len(hash) != 32 {
+		return "", openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "message hash is invalid")
+	}
+
+	//复制一份，避免修改调用方的数据
+	sig = append([]byte{}, sig...)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	if sig[64] > 1 {
+		return "", openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "signature recovery id is invalid")
+	}
+
+	pubkey, ret := owcrypt.RecoverPubkey(sig, hash, owcrypt.ECC_CURVE_SECP256K1)
+	if ret != owcrypt.SUCCESS {
+		return "", openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "recover public key failed")
+	}
+
+	//与签名交易一致，用恢复的公钥再校验一次签名
+	if owcrypt.Verify(pubkey, nil, hash, sig[:64], owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+		return "", openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "signature verify failed")
+	}
+
+	compressed := owcrypt.PointCompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)
+	address, err := wm.Decoder.AddressEncode(compressed)
+	if err != nil {
+		return "", openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "encode address failed, err: %v", err)
+	}
+	return address, nil
+}
+
+// RecoverPersonalMessageAddress 从EIP-191签名恢复签名地址
+func (wm *WalletManager) RecoverPersonalMessageAddress(message []byte, signature string) (string, error) {
+	return wm.RecoverSignatureAddress(PersonalMessageHash(message), signature)
+}
+
+// VerifyPersonalMessage 校验EIP-191签名是否由address签出
+func (wm *WalletManager) VerifyPersonalMessage(address string, message []byte, signature string) (bool, error) {
+
+	signer, err := wm.RecoverPersonalMessageAddress(message, signature)
+	if err != nil {
+		return false, err
+	}
+
+	//两边使用相同的地址解码，兼容自定义地址格式
+	normalize := func(addr string) string {
+		return strings.ToLower(AppendOxToAddress(wm.CustomAddressDecodeFunc(addr)))
+	}
+	return normalize(signer) == normalize(address), nil
+}
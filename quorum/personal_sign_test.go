@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/quorum-adapter/quorum_addrdec"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestWalletManager_VerifyPersonalMessage(t *testing.T) {
+	wm := testNewWalletManager()
+
+	//web3.eth.accounts.sign("Some data", "0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	address := "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+	message := ParsePersonalMessage("Some data")
+	signature := "0xb91467e570a6466aa9e9876cbcd013baba02900b8979d43fe208a4a4f339f5fd6007e74cd82e037b800186422fc2da167c747ef045e5d18a5f5d4300f8e1a0291c"
+
+	ok, err := wm.VerifyPersonalMessage(address, message, signature)
+	if err != nil {
+		t.Errorf("VerifyPersonalMessage error: %v", err)
+		return
+	}
+	if !ok {
+		t.Errorf("VerifyPersonalMessage should be valid")
+		return
+	}
+
+	ok, _ = wm.VerifyPersonalMessage(address, []byte("Other data"), signature)
+	if ok {
+		t.Errorf("VerifyPersonalMessage should be invalid for other message")
+		return
+	}
+
+	//owcrypt签名的v为0或1，同样可以校验
+	prikey := hexutil.MustDecode("0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	hash := PersonalMessageHash(ParsePersonalMessage("0x48656c6c6f"))
+	sig, v, ret := owcrypt.Signature(prikey, nil, hash, owcrypt.ECC_CURVE_SECP256K1)
+	if ret != owcrypt.SUCCESS {
+		t.Errorf("owcrypt sign failed")
+		return
+	}
+	ok, err = wm.VerifyPersonalMessage(address, []byte("Hello"), hexutil.Encode(append(sig, v)))
+	if err != nil || !ok {
+		t.Errorf("VerifyPersonalMessage owcrypt signature failed, err: %v", err)
+		return
+	}
+
+	//自定义地址格式，恢复的地址和传入的地址使用相同的解码
+	wm.Decoder = &testPrefixAddressDecoder{AddressDecoderV2: quorum_addrdec.NewAddressDecoderV2()}
+	wm.CustomAddressDecodeFunc = func(address string) string {
+		if strings.HasPrefix(address, "xdc") {
+			return "0x" + address[3:]
+		}
+		return address
+	}
+	ok, err = wm.VerifyPersonalMessage("xdc2c7536e3605d9c16a7a3d7b1898e529396a65c23", message, signature)
+	if err != nil || !ok {
+		t.Errorf("VerifyPersonalMessage custom address failed, err: %v", err)
+	}
+}
+
+// testPrefixAddressDecoder 编码为xdc前缀的地址
+type testPrefixAddressDecoder struct {
+	*quorum_addrdec.AddressDecoderV2
+}
+
+func (dec *testPrefixAddressDecoder) AddressEncode(hash []byte, opts ...interface{}) (string, error) {
+	address, err := dec.AddressDecoderV2.AddressEncode(hash, opts...)
+	if err != nil {
+		return "", err
+	}
+	return "xdc" + strings.TrimPrefix(address, "0x"), nil
+}
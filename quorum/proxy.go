@@ -0,0 +1,275 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	// EIP-1967 逻辑合约存储槽 bytes32(uint256(keccak256('eip1967.proxy.implementation')) - 1)
+	EIP1967ImplementationSlot = "0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc"
+	// EIP-1967 信标合约存储槽 bytes32(uint256(keccak256('eip1967.proxy.beacon')) - 1)
+	EIP1967BeaconSlot = "0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeaee59ff6cb3582b35133d50"
+	// EIP-1822 逻辑合约存储槽 keccak256("PROXIABLE")
+	EIP1822ProxiableSlot = "0xc5f16f0fcc639fa48a6947836d9850f504798523bf8c9a3a87d5876cf622bcf7"
+
+	ProxyStandardEIP1967       = "EIP1967"
+	ProxyStandardEIP1967Beacon = "EIP1967Beacon"
+	ProxyStandardEIP1822       = "EIP1822"
+
+	proxyContractCacheSize = 10000
+	proxyContractCacheTTL  = 10 * time.Minute //代理合约可升级，检测结果定期刷新
+)
+
+var (
+	// 信标合约 implementation() 方法选择器
+	beaconImplementationSelector = hexutil.MustDecode("0x5c60da1b")
+)
+
+// ProxyContract 代理合约信息
+type ProxyContract struct {
+	Address        string //代理合约地址
+	Implementation string //逻辑合约地址
+	Beacon         string //信标合约地址，仅EIP1967Beacon
+	Standard       string //代理标准
+}
+
+// GetStorageAt 获取合约存储槽的值
+func (wm *WalletManager) GetStorageAt(address string, slot string, sign string) (string, error) {
+	params := []interface{}{
+		AppendOxToAddress(wm.CustomAddressDecodeFunc(address)),
+		slot,
+		sign,
+	}
+
+	result, err := wm.WalletClient.Call("eth_getStorageAt", params)
+	if err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
+
+// getAddressAtSlot 读取存储槽中的地址，空槽返回空字符串
+func (wm *WalletManager) getAddressAtSlot(address string, slot string) (string, error) {
+	value, err := wm.GetStorageAt(address, slot, "latest")
+	if err != nil {
+		return "", err
+	}
+	return slotValueToAddress(value), nil
+}
+
+// slotValueToAddress 存储槽的值取低20字节作为地址
+func slotValueToAddress(value string) string {
+	num, ok := new(big.Int).SetString(removeOxFromHex(value), 16)
+	if !ok || num.Sign() == 0 {
+		return ""
+	}
+	return strings.ToLower(ethcom.BigToAddress(num).String())
+}
+
+// DetectProxyContract 通过EIP-1967，EIP-1822存储槽检测代理合约，非代理合约返回nil
+func (wm *WalletManager) DetectProxyContract(address string) (*ProxyContract, error) {
+
+	address = strings.ToLower(address)
+
+	//读取缓存，非代理合约也缓存nil结果
+	if cached, ok := wm.proxyContracts.Get(address); ok {
+		proxy, _ := cached.(*ProxyContract)
+		return proxy, nil
+	}
+
+	proxy, err := wm.loadProxyContract(address)
+	if err != nil {
+		return nil, err
+	}
+
+	wm.proxyContracts.Add(address, proxy)
+	return proxy, nil
+}
+
+func (wm *WalletManager) loadProxyContract(address string) (*ProxyContract, error) {
+
+	//EIP-1967 逻辑合约
+	implementation, err := wm.getAddressAtSlot(address, EIP1967ImplementationSlot)
+	if err != nil {
+		return nil, err
+	}
+	if len(implementation) > 0 {
+		return &ProxyContract{
+			Address:        address,
+			Implementation: implementation,
+			Standard:       ProxyStandardEIP1967,
+		}, nil
+	}
+
+	//EIP-1967 信标合约
+	beacon, err := wm.getAddressAtSlot(address, EIP1967BeaconSlot)
+	if err != nil {
+		return nil, err
+	}
+	if len(beacon) > 0 {
+		callMsg := CallMsg{
+			From:  ethcom.HexToAddress("0x00"),
+			To:    ethcom.HexToAddress(beacon),
+			Data:  beaconImplementationSelector,
+			Value: big.NewInt(0),
+		}
+		result, callErr := wm.EthCall(callMsg, "latest")
+		if callErr != nil {
+			return nil, fmt.Errorf("beacon %s implementation() call failed, err: %v", beacon, callErr)
+		}
+		return &ProxyContract{
+			Address:        address,
+			Implementation: slotValueToAddress(result),
+			Beacon:         beacon,
+			Standard:       ProxyStandardEIP1967Beacon,
+		}, nil
+	}
+
+	//EIP-1822
+	implementation, err = wm.getAddressAtSlot(address, EIP1822ProxiableSlot)
+	if err != nil {
+		return nil, err
+	}
+	if len(implementation) > 0 {
+		return &ProxyContract{
+			Address:        address,
+			Implementation: implementation,
+			Standard:       ProxyStandardEIP1822,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// GetProxyImplementation 获取代理合约的逻辑合约地址，非代理合约返回空
+func (wm *WalletManager) GetProxyImplementation(address string) string {
+	proxy, err := wm.DetectProxyContract(address)
+	if err != nil {
+		wm.Log.Debugf("detect proxy contract %s failed, err: %v", address, err)
+		return ""
+	}
+	if proxy == nil {
+		return ""
+	}
+	return proxy.Implementation
+}
+
+// contractImplementation 代理合约的逻辑合约地址，优先使用合约信息中记录的地址
+func (wm *WalletManager) contractImplementation(address string) string {
+	if info := wm.getContractCapability(address).Info; info != nil && info.Valid {
+		return info.Implementation
+	}
+	return wm.GetProxyImplementation(address)
+}
+
+// resolveProxyContractABI 代理合约优先使用逻辑合约的ABI，依次从外部数据源和ABI注册表查找。
+// 合约没有ABI或只有通用代币ABI时替换为逻辑合约ABI，否则合并两者，找不到时返回原合约
+func (bs *BlockScanner) resolveProxyContractABI(tx *BlockTransaction, contract *openwallet.SmartContract) *openwallet.SmartContract {
+
+	implementation := bs.wm.contractImplementation(contract.Address)
+	if len(implementation) == 0 {
+		return contract
+	}
+
+	implABI, implProtocol := "", ""
+	targetResult := tx.FilterFunc(openwallet.ScanTargetParam{
+		ScanTarget:     implementation,
+		Symbol:         bs.wm.Symbol(),
+		ScanTargetType: openwallet.ScanTargetTypeContractAddress})
+	if targetResult.Exist {
+		if implContract, ok := targetResult.TargetInfo.(*openwallet.SmartContract); ok {
+			implABI = implContract.GetABI()
+			implProtocol = implContract.Protocol
+		}
+	}
+	if len(implABI) == 0 && bs.wm.ABIRegistry != nil {
+		implABI = bs.wm.ABIRegistry.GetByAddress(implementation)
+	}
+	if len(implABI) == 0 {
+		return contract
+	}
+
+	//复制代理合约信息，避免修改外部对象
+	resolved := *contract
+	proxyABI := contract.GetABI()
+	if len(proxyABI) == 0 || isGenericTokenABI(proxyABI) {
+		resolved.SetABI(implABI)
+	} else {
+		resolved.SetABI(mergeABIJSON(implABI, proxyABI))
+	}
+	if len(resolved.Protocol) == 0 {
+		resolved.Protocol = implProtocol
+	}
+	return &resolved
+}
+
+// abiEntryKey ABI条目的唯一标识，类型+名称+参数类型
+func abiEntryKey(entry json.RawMessage) string {
+	var item struct {
+		Type   string `json:"type"`
+		Name   string `json:"name"`
+		Inputs []struct {
+			Type string `json:"type"`
+		} `json:"inputs"`
+	}
+	if json.Unmarshal(entry, &item) != nil {
+		return ""
+	}
+	types := make([]string, 0, len(item.Inputs))
+	for _, input := range item.Inputs {
+		types = append(types, input.Type)
+	}
+	return fmt.Sprintf("%s:%s(%s)", item.Type, item.Name, strings.Join(types, ","))
+}
+
+// mergeABIJSON 合并两个ABI，相同的方法或事件使用primary的定义，解析失败返回primary
+func mergeABIJSON(primary, secondary string) string {
+	var primaryEntries, secondaryEntries []json.RawMessage
+	if json.Unmarshal([]byte(primary), &primaryEntries) != nil || json.Unmarshal([]byte(secondary), &secondaryEntries) != nil {
+		return primary
+	}
+
+	exist := make(map[string]bool, len(primaryEntries))
+	for _, entry := range primaryEntries {
+		exist[abiEntryKey(entry)] = true
+	}
+	merged := primaryEntries
+	for _, entry := range secondaryEntries {
+		key := abiEntryKey(entry)
+		if len(key) == 0 || exist[key] {
+			continue
+		}
+		exist[key] = true
+		merged = append(merged, entry)
+	}
+
+	content, err := json.Marshal(merged)
+	if err != nil {
+		return primary
+	}
+	return string(content)
+}
@@ -16,6 +16,7 @@
 package quorum
 
 import (
+	"fmt"
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
@@ -23,6 +24,8 @@ import (
 	"github.com/blocktree/quorum-adapter/quorum_rpc"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"math/big"
+	"path/filepath"
+	"time"
 )
 
 // FullName 币种全名
@@ -65,7 +68,16 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.Config.ServerAPI = c.String("serverAPI")
 	wm.Config.BroadcastAPI = c.String("broadcastAPI")
 	//client := &quorum_rpc.Client{BaseURL: wm.Config.ServerAPI, BroadcastURL: wm.Config.BroadcastAPI, Debug: false}
+	if policy := c.String("broadcastPolicy"); len(policy) > 0 {
+		wm.Config.BroadcastPolicy = policy
+	}
+	if wm.Config.BroadcastPolicy != quorum_rpc.BroadcastPolicyParallel && wm.Config.BroadcastPolicy != quorum_rpc.BroadcastPolicySequential {
+		return fmt.Errorf("broadcastPolicy %s is not supported", wm.Config.BroadcastPolicy)
+	}
 	client, _ := quorum_rpc.Dial(wm.Config.ServerAPI, wm.Config.BroadcastAPI, false)
+	if client != nil {
+		client.BroadcastPolicy = wm.Config.BroadcastPolicy
+	}
 	wm.WalletClient = client
 	wm.Config.DataDir = c.String("dataDir")
 	fixGasLimit := c.String("fixGasLimit")
@@ -80,10 +92,108 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.Config.NonceComputeMode, _ = c.Int64("nonceComputeMode")
 	wm.Config.UseQNSingleFlightRPC, _ = c.Int64("useQNSingleFlightRPC")
 	wm.Config.DetectUnknownContracts, _ = c.Int64("detectUnknownContracts")
+	wm.Config.SimulateBeforeBroadcast, _ = c.Int64("simulateBeforeBroadcast")
+	wm.Config.ABICacheSize, _ = c.Int64("abiCacheSize")
+	wm.Config.ABIPreloadDir = c.String("abiPreloadDir")
+	wm.Config.SignatureDBFile = c.String("signatureDBFile")
+	if gateway := c.String("nftIPFSGateway"); len(gateway) > 0 {
+		wm.Config.NFTIPFSGateway = gateway
+	}
+	if gateway := c.String("nftArweaveGateway"); len(gateway) > 0 {
+		wm.Config.NFTArweaveGateway = gateway
+	}
+	if maxSize, _ := c.Int64("nftMetadataMaxSize"); maxSize > 0 {
+		wm.Config.NFTMetadataMaxSize = maxSize
+	}
+	if startBlock, err := c.Int64("logScanStartBlock"); err == nil {
+		wm.Config.LogScanStartBlock = startBlock
+	}
+	if blockRange, _ := c.Int64("logScanBlockRange"); blockRange > 0 {
+		wm.Config.LogScanBlockRange = blockRange
+	}
+	if multicall := c.String("multicallAddress"); len(multicall) > 0 {
+		wm.Config.MulticallAddress = multicall
+	}
+	if ttl, _ := c.Int64("contractCapabilityTTL"); ttl > 0 {
+		wm.Config.ContractCapabilityTTL = ttl
+	}
+	if bump, _ := c.Int64("replacementPriceBump"); bump > 0 {
+		wm.Config.ReplacementPriceBump = bump
+	}
+	if ttl, _ := c.Int64("nonceReservationTTL"); ttl > 0 {
+		wm.Config.NonceReservationTTL = ttl
+	}
+	wm.Config.EnableTxJournal, _ = c.Int64("enableTxJournal")
+	if interval, err := c.Int64("txRebroadcastInterval"); err == nil {
+		wm.Config.TxRebroadcastInterval = interval
+	}
+	if timeout, _ := c.Int64("txRebroadcastTimeout"); timeout > 0 {
+		wm.Config.TxRebroadcastTimeout = timeout
+	}
+	if timeout, err := c.Int64("txDropTimeout"); err == nil {
+		wm.Config.TxDropTimeout = timeout
+	}
+	if confirmations, _ := c.Int64("awaitConfirmations"); confirmations > 0 {
+		wm.Config.AwaitConfirmations = uint64(confirmations)
+	}
+	wm.Config.DisperseAddress = c.String("disperseAddress")
 
 	//数据文件夹
 	wm.Config.makeDataDir()
 
+	//ABI注册表
+	registry, err := NewABIRegistry(filepath.Join(wm.Config.DBPath, "abi"), int(wm.Config.ABICacheSize))
+	if err != nil {
+		return fmt.Errorf("create abi registry failed, err: %v", err)
+	}
+	wm.ABIRegistry = registry
+	if len(wm.Config.ABIPreloadDir) > 0 {
+		count, loadErr := registry.LoadDir(wm.Config.ABIPreloadDir)
+		if loadErr != nil {
+			return fmt.Errorf("preload abi files failed, err: %v", loadErr)
+		}
+		wm.Log.Infof("preload %d abi files from %s", count, wm.Config.ABIPreloadDir)
+	}
+
+	//合约能力缓存
+	capabilities, err := NewContractCapabilityCache(filepath.Join(wm.Config.DBPath, "capability"), time.Duration(wm.Config.ContractCapabilityTTL)*time.Second)
+	if err != nil {
+		return fmt.Errorf("create contract capability cache failed, err: %v", err)
+	}
+	wm.ContractCapabilities = capabilities
+
+	//替换交易记录
+	replacements, err := NewTxReplacementStore(filepath.Join(wm.Config.DBPath, "replacement"))
+	if err != nil {
+		return fmt.Errorf("create transaction replacement store failed, err: %v", err)
+	}
+	wm.TxReplacements = replacements
+
+	//地址nonce管理
+	nonceManager, err := NewNonceManager(wm, filepath.Join(wm.Config.DBPath, "nonce"), time.Duration(wm.Config.NonceReservationTTL)*time.Second)
+	if err != nil {
+		return fmt.Errorf("create nonce manager failed, err: %v", err)
+	}
+	wm.NonceManager = nonceManager
+
+	//已广播交易日志，重新广播的后台任务由调用方通过StartTxJournalWorker启动
+	if wm.Config.EnableTxJournal == 1 {
+		journal, openErr := OpenTxJournal(filepath.Join(wm.Config.DBPath, "journal.db"))
+		if openErr != nil {
+			return fmt.Errorf("open transaction journal failed, err: %v", openErr)
+		}
+		wm.TxJournal = journal
+	}
+
+	//签名库
+	if len(wm.Config.SignatureDBFile) > 0 {
+		count, loadErr := wm.SignatureDB.LoadFile(wm.Config.SignatureDBFile)
+		if loadErr != nil {
+			return fmt.Errorf("load signature file failed, err: %v", loadErr)
+		}
+		wm.Log.Infof("load %d signatures from %s", count, wm.Config.SignatureDBFile)
+	}
+
 	chainID, err := c.Int64("chainID")
 	if err != nil {
 		//设置网络chainID
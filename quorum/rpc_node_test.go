@@ -0,0 +1,65 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/blocktree/quorum-adapter/quorum_rpc"
+)
+
+// testRPCHandler 按方法和参数返回完整的JSON-RPC响应，返回空字符串时响应null结果
+type testRPCHandler func(method string, params []interface{}) string
+
+// newTestRPCNode 模拟JSON-RPC节点，测试结束时关闭
+func newTestRPCNode(t *testing.T, handler testRPCHandler) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		response := handler(body.Method, body.Params)
+		if len(response) == 0 {
+			response = testRPCResult("null")
+		}
+		fmt.Fprint(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestRPCWalletManager 创建连接模拟节点的钱包管理器
+func newTestRPCWalletManager(t *testing.T, handler testRPCHandler) *WalletManager {
+	srv := newTestRPCNode(t, handler)
+	wm := NewWalletManager()
+	wm.WalletClient, _ = quorum_rpc.Dial(srv.URL, "", false)
+	return wm
+}
+
+// testRPCResult JSON-RPC成功响应，result为JSON值
+func testRPCResult(result string) string {
+	return `{"jsonrpc":"2.0","id":1,"result":` + result + `}`
+}
+
+// testRPCError JSON-RPC错误响应
+func testRPCError(code int, message string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"error":{"code":%d,"message":"%s"}}`, code, message)
+}
@@ -0,0 +1,407 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 内置常用事件和方法签名
+var builtinSignatures = []string{
+	//ERC20, ERC721, ERC1155
+	"Transfer(address,address,uint256)",
+	"Approval(address,address,uint256)",
+	"ApprovalForAll(address,address,bool)",
+	"TransferSingle(address,address,address,uint256,uint256)",
+	"TransferBatch(address,address,address,uint256[],uint256[])",
+	"URI(string,uint256)",
+	"transfer(address,uint256)",
+	"approve(address,uint256)",
+	"transferFrom(address,address,uint256)",
+	"balanceOf(address)",
+	"allowance(address,address)",
+	"safeTransferFrom(address,address,uint256)",
+	"safeTransferFrom(address,address,uint256,bytes)",
+	"safeTransferFrom(address,address,uint256,uint256,bytes)",
+	"safeBatchTransferFrom(address,address,uint256[],uint256[],bytes)",
+	"setApprovalForAll(address,bool)",
+	"permit(address,address,uint256,uint256,uint8,bytes32,bytes32)",
+	//WETH
+	"Deposit(address,uint256)",
+	"Withdrawal(address,uint256)",
+	"deposit()",
+	"withdraw(uint256)",
+	//Ownable, AccessControl, Pausable
+	"OwnershipTransferred(address,address)",
+	"RoleGranted(bytes32,address,address)",
+	"RoleRevoked(bytes32,address,address)",
+	"Paused(address)",
+	"Unpaused(address)",
+	"transferOwnership(address)",
+	"renounceOwnership()",
+	//代理合约
+	"Upgraded(address)",
+	"AdminChanged(address,address)",
+	"BeaconUpgraded(address)",
+	"upgradeTo(address)",
+	"upgradeToAndCall(address,bytes)",
+	//Uniswap
+	"Swap(address,uint256,uint256,uint256,uint256,address)",
+	"Swap(address,address,int256,int256,uint160,uint128,int24)",
+	"Sync(uint112,uint112)",
+	"Mint(address,uint256,uint256)",
+	"Burn(address,uint256,uint256,address)",
+	"swapExactTokensForTokens(uint256,uint256,address[],address,uint256)",
+	"swapTokensForExactTokens(uint256,uint256,address[],address,uint256)",
+	"swapExactETHForTokens(uint256,address[],address,uint256)",
+	"swapExactTokensForETH(uint256,uint256,address[],address,uint256)",
+	//Multicall
+	"multicall(bytes[])",
+	"aggregate((address,bytes)[])",
+}
+
+// DecodedCallData 解码后的交易输入数据
+type DecodedCallData struct {
+	Method    string                 //方法名
+	Signature string                 //方法签名
+	Args      map[string]interface{} //参数
+	ArgsJSON  string                 //参数JSON
+}
+
+// SignatureDB 事件topic0和方法4字节选择器对应的签名库
+type SignatureDB struct {
+	events  map[ethcom.Hash][]string
+	methods map[[4]byte][]string
+	sync.RWMutex
+}
+
+// NewSignatureDB 创建签名库，包含内置常用签名
+func NewSignatureDB() *SignatureDB {
+	db := &SignatureDB{
+		events:  make(map[ethcom.Hash][]string),
+		methods: make(map[[4]byte][]string),
+	}
+	for _, sig := range builtinSignatures {
+		db.Add(sig)
+	}
+	return db
+}
+
+// Add 添加签名，签名同时作为事件和方法记录
+func (db *SignatureDB) Add(signature string) error {
+
+	signature = strings.ReplaceAll(strings.TrimSpace(signature), " ", "")
+	if _, _, err := parseSignature(signature); err != nil {
+		return err
+	}
+
+	hash := crypto.Keccak256Hash([]byte(signature))
+	var selector [4]byte
+	copy(selector[:], hash[:4])
+
+	db.Lock()
+	defer db.Unlock()
+	db.events[hash] = appendSignature(db.events[hash], signature)
+	db.methods[selector] = appendSignature(db.methods[selector], signature)
+	return nil
+}
+
+// LoadFile 从本地文件导入签名，支持JSON字符串数组，或每行一个签名（可带hash前缀，#开头为注释）
+func (db *SignatureDB) LoadFile(path string) (int, error) {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	signatures := make([]string, 0)
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err = json.Unmarshal(trimmed, &signatures); err != nil {
+			return 0, err
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			//格式：0x<hash> <signature>，hash以签名重新计算为准
+			if fields := strings.Fields(line); len(fields) > 1 && strings.HasPrefix(fields[0], "0x") {
+				line = strings.Join(fields[1:], "")
+			}
+			signatures = append(signatures, line)
+		}
+	}
+
+	count := 0
+	for _, sig := range signatures {
+		if addErr := db.Add(sig); addErr != nil {
+			return count, fmt.Errorf("signature %s is invalid, err: %v", sig, addErr)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// EventSignatures 通过topic0查找事件签名
+func (db *SignatureDB) EventSignatures(topic ethcom.Hash) []string {
+	db.RLock()
+	defer db.RUnlock()
+	return db.events[topic]
+}
+
+// MethodSignatures 通过4字节选择器查找方法签名
+func (db *SignatureDB) MethodSignatures(selector []byte) []string {
+	if len(selector) < 4 {
+		return nil
+	}
+	var key [4]byte
+	copy(key[:], selector[:4])
+	db.RLock()
+	defer db.RUnlock()
+	return db.methods[key]
+}
+
+// DecodeLogBySignature 通过签名库解码日志，参数名为arg0，arg1...，topics数量决定前N个参数为indexed
+func (wm *WalletManager) DecodeLogBySignature(log types.Log) (map[string]interface{}, string, string, error) {
+
+	if wm.SignatureDB == nil {
+		return nil, "", "", fmt.Errorf("signature database is not initialized")
+	}
+	if len(log.Topics) == 0 {
+		return nil, "", "", fmt.Errorf("log topics is empty")
+	}
+
+	for _, sig := range wm.SignatureDB.EventSignatures(log.Topics[0]) {
+		abiInstance, err := signatureToABI(sig, "event", len(log.Topics)-1)
+		if err != nil {
+			continue
+		}
+		result, eventName, logJSON, err := wm.DecodeReceiptLogResult(abiInstance, log)
+		if err == nil {
+			return result, eventName, logJSON, nil
+		}
+	}
+
+	return nil, "", "", fmt.Errorf("event signature of topic %s not found", log.Topics[0].Hex())
+}
+
+// DecodeCallData 通过签名库解码交易输入数据
+func (db *SignatureDB) DecodeCallData(data []byte) (*DecodedCallData, error) {
+
+	if len(data) < 4 {
+		return nil, fmt.Errorf("call data length is less than 4")
+	}
+
+	for _, sig := range db.MethodSignatures(data) {
+		abiInstance, err := signatureToABI(sig, "function", 0)
+		if err != nil {
+			continue
+		}
+		decoded, err := decodeCallDataWithABI(abiInstance, data)
+		if err == nil {
+			decoded.Signature = sig
+			return decoded, nil
+		}
+	}
+
+	return nil, fmt.Errorf("method signature of selector %s not found", hexutil.Encode(data[:4]))
+}
+
+// DecodeCallData 解码交易输入数据，优先使用ABI注册表中合约的ABI，其次使用签名库
+func (wm *WalletManager) DecodeCallData(contractAddress string, input string) (*DecodedCallData, error) {
+
+	data, err := hexutil.Decode(AppendOxToAddress(input))
+	if err != nil {
+		return nil, fmt.Errorf("call data is not hex, err: %v", err)
+	}
+
+	if abiJSON := wm.ResolveContractABI(contractAddress); len(abiJSON) > 0 {
+		abiInstance, abiErr := abi.JSON(strings.NewReader(abiJSON))
+		if abiErr == nil {
+			if decoded, decodeErr := decodeCallDataWithABI(abiInstance, data); decodeErr == nil {
+				return decoded, nil
+			}
+		}
+	}
+
+	if wm.SignatureDB == nil {
+		return nil, fmt.Errorf("signature database is not initialized")
+	}
+	return wm.SignatureDB.DecodeCallData(data)
+}
+
+// decodeCallDataWithABI 解码并重新编码校验，避免选择器碰撞时误解码
+func decodeCallDataWithABI(abiInstance abi.ABI, data []byte) (*DecodedCallData, error) {
+
+	if len(data) < 4 {
+		return nil, fmt.Errorf("call data length is less than 4")
+	}
+
+	method, err := abiInstance.MethodById(data[:4])
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	packed, err := method.Inputs.Pack(values...)
+	if err != nil || !bytes.Equal(packed, data[4:]) {
+		return nil, fmt.Errorf("call data does not match method %s", method.Sig)
+	}
+
+	args := make(CallResult)
+	if err = method.Inputs.UnpackIntoMap(args, data[4:]); err != nil {
+		return nil, err
+	}
+	argsJSON, err := args.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DecodedCallData{
+		Method:    method.Name,
+		Signature: method.Sig,
+		Args:      args,
+		ArgsJSON:  string(argsJSON),
+	}, nil
+}
+
+// signatureToABI 签名转为只有一个事件或方法的ABI
+func signatureToABI(signature, kind string, indexed int) (abi.ABI, error) {
+
+	name, inputs, err := parseSignature(signature)
+	if err != nil {
+		return abi.ABI{}, err
+	}
+	if indexed > len(inputs) {
+		return abi.ABI{}, fmt.Errorf("indexed arguments more than inputs")
+	}
+	for i := range inputs {
+		inputs[i].Name = fmt.Sprintf("arg%d", i)
+		inputs[i].Indexed = kind == "event" && i < indexed
+	}
+
+	entry := map[string]interface{}{
+		"type":   kind,
+		"name":   name,
+		"inputs": inputs,
+	}
+	if kind == "function" {
+		entry["outputs"] = []interface{}{}
+		entry["stateMutability"] = "nonpayable"
+	}
+	abiJSON, err := json.Marshal([]interface{}{entry})
+	if err != nil {
+		return abi.ABI{}, err
+	}
+	return abi.JSON(bytes.NewReader(abiJSON))
+}
+
+// parseSignature 解析规范签名，例如：aggregate((address,bytes)[])
+func parseSignature(signature string) (string, []abi.ArgumentMarshaling, error) {
+
+	start := strings.Index(signature, "(")
+	if start <= 0 || !strings.HasSuffix(signature, ")") {
+		return "", nil, fmt.Errorf("signature format is invalid")
+	}
+
+	inputs, err := parseSignatureTypes(signature[start+1 : len(signature)-1])
+	if err != nil {
+		return "", nil, err
+	}
+	return signature[:start], inputs, nil
+}
+
+func parseSignatureTypes(list string) ([]abi.ArgumentMarshaling, error) {
+
+	args := make([]abi.ArgumentMarshaling, 0)
+	if len(list) == 0 {
+		return args, nil
+	}
+
+	//按顶层逗号分割
+	depth, begin := 0, 0
+	parts := make([]string, 0)
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, list[begin:i])
+				begin = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+	parts = append(parts, list[begin:])
+
+	for _, part := range parts {
+		if len(part) == 0 {
+			return nil, fmt.Errorf("empty argument type")
+		}
+		arg := abi.ArgumentMarshaling{Type: part}
+		if part[0] == '(' {
+			end := strings.LastIndex(part, ")")
+			components, err := parseSignatureTypes(part[1:end])
+			if err != nil {
+				return nil, err
+			}
+			for i := range components {
+				components[i].Name = fmt.Sprintf("field%d", i)
+			}
+			arg.Type = "tuple" + part[end+1:]
+			arg.Components = components
+		}
+		if _, err := abi.NewType(arg.Type, "", arg.Components); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func appendSignature(list []string, signature string) []string {
+	for _, s := range list {
+		if s == signature {
+			return list
+		}
+	}
+	return append(list, signature)
+}
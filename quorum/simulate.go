@@ -0,0 +1,180 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync/atomic"
+
+	"github.com/blocktree/quorum-adapter/quorum_rpc"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	// Panic(uint256) 的方法选择器
+	panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+
+	// solidity panic错误码说明
+	panicReasons = map[uint64]string{
+		0x00: "generic compiler inserted panic",
+		0x01: "assert(false)",
+		0x11: "arithmetic underflow or overflow",
+		0x12: "division or modulo by zero",
+		0x21: "enum overflow",
+		0x22: "invalid encoded storage byte array accessed",
+		0x31: "pop on empty array",
+		0x32: "array index out of bounds",
+		0x41: "out of memory",
+		0x51: "uninitialized function",
+	}
+)
+
+// SimulationError 交易模拟执行失败
+type SimulationError struct {
+	Reason     string //解码后的回滚原因
+	RevertData string //原始回滚数据
+	Err        error  //节点返回的错误
+}
+
+func (e *SimulationError) Error() string {
+	if len(e.Reason) > 0 {
+		return fmt.Sprintf("execution reverted: %s", e.Reason)
+	}
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return "execution reverted"
+}
+
+// needSimulate 是否广播前模拟执行，扩展参数simulate优先于配置
+func (wm *WalletManager) needSimulate(extParam gjson.Result) bool {
+	if simulate := extParam.Get("simulate"); simulate.Exists() {
+		return simulate.Bool()
+	}
+	return wm.Config.SimulateBeforeBroadcast == 1
+}
+
+// SimulateTransaction 在pending状态下通过eth_call重放已签名交易，执行回滚返回*SimulationError，其他错误只记录日志
+func (wm *WalletManager) SimulateTransaction(tx *types.Transaction) error {
+
+	//兼容EIP-1559和EIP-2930替换交易，传统交易与EIP155签名一致
+	signer := types.LatestSignerForChainID(big.NewInt(int64(wm.Config.ChainID)))
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return fmt.Errorf("recover transaction sender failed, err: %v", err)
+	}
+
+	callMsg := CallMsg{
+		From:     from,
+		Value:    tx.Value(),
+		Gas:      tx.Gas(),
+		GasPrice: tx.GasPrice(),
+		Data:     tx.Data(),
+	}
+	if tx.To() != nil {
+		callMsg.To = *tx.To()
+	} else {
+		callMsg.Create = true
+	}
+
+	_, callErr := wm.EthCallWithOptions(callMsg, &EthCallOptions{BlockNumber: "pending"})
+	if callErr == nil {
+		return nil
+	}
+
+	rpcErr, ok := callErr.(*quorum_rpc.Error)
+	if !ok || !quorum_rpc.IsExecutionReverted(callErr) {
+		//网络异常、节点不支持pending等原因无法完成模拟，不阻止广播
+		wm.Log.Warningf("simulate transaction failed, broadcast anyway, err: %v", callErr)
+		return nil
+	}
+
+	simErr := &SimulationError{Err: callErr}
+	revertData, decodeErr := hexutil.Decode(rpcErr.Data)
+	if decodeErr != nil || len(revertData) == 0 {
+		//eth_call没有返回回滚数据，尝试通过debug_traceCall获取
+		revertData, simErr.Reason = wm.traceCallRevert(callMsg)
+	}
+	if len(revertData) > 0 {
+		simErr.RevertData = hexutil.Encode(revertData)
+		if reason := decodeRevertReason(revertData); len(reason) > 0 {
+			simErr.Reason = reason
+		}
+	}
+	if len(simErr.Reason) == 0 {
+		simErr.Reason = strings.TrimPrefix(rpcErr.Message, "execution reverted: ")
+	}
+
+	return simErr
+}
+
+// traceCallRevert 通过debug_traceCall获取回滚数据，节点不支持时返回空
+func (wm *WalletManager) traceCallRevert(callMsg CallMsg) ([]byte, string) {
+
+	if atomic.LoadInt32(&wm.traceCallUnsupported) == 1 {
+		return nil, ""
+	}
+
+	params := []interface{}{
+		callMsg.toCallParam(),
+		"pending",
+		map[string]interface{}{
+			"tracer": "callTracer",
+		},
+	}
+	result, err := wm.WalletClient.Call("debug_traceCall", params)
+	if err != nil {
+		if rpcErr, ok := err.(*quorum_rpc.Error); ok && rpcErr.Code == -32601 {
+			wm.Log.Infof("node does not support debug_traceCall, skip it")
+			atomic.StoreInt32(&wm.traceCallUnsupported, 1)
+		}
+		return nil, ""
+	}
+
+	output, _ := hexutil.Decode(result.Get("output").String())
+	reason := result.Get("revertReason").String()
+	if len(reason) == 0 {
+		reason = result.Get("error").String()
+	}
+	return output, reason
+}
+
+// decodeRevertReason 解码合约回滚数据，支持Error(string)，Panic(uint256)，其他自定义错误返回选择器
+func decodeRevertReason(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return reason
+	}
+
+	if bytes.Equal(data[:4], panicSelector) && len(data) == 36 {
+		code := new(big.Int).SetBytes(data[4:])
+		if desc, ok := panicReasons[code.Uint64()]; ok && code.IsUint64() {
+			return fmt.Sprintf("panic: %s (0x%x)", desc, code)
+		}
+		return fmt.Sprintf("panic: 0x%x", code)
+	}
+
+	return fmt.Sprintf("custom error %s", hexutil.Encode(data[:4]))
+}
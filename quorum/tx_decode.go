@@ -288,6 +288,13 @@ func (decoder *EthTransactionDecoder) CreateErc20TokenRawTransaction(wrapper ope
 
 // CreateRawTransaction 创建交易单
 func (decoder *EthTransactionDecoder) CreateRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	//多个接收方通过disperse合约批量转账，没有配置disperse合约或指定sequential时拆分为连续nonce的多笔交易
+	if len(rawTx.To) > 1 {
+		if len(decoder.wm.Config.DisperseAddress) == 0 || isSequentialRawTransaction(rawTx) {
+			return decoder.CreateSequentialRawTransaction(wrapper, rawTx)
+		}
+		return decoder.CreateDisperseRawTransaction(wrapper, rawTx)
+	}
 	if !rawTx.Coin.IsContract {
 		return decoder.CreateSimpleRawTransaction(wrapper, rawTx, nil)
 	}
@@ -317,35 +324,37 @@ func (decoder *EthTransactionDecoder) SignRawTransaction(wrapper openwallet.Wall
 		return openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "wallet signature not found ")
 	}
 
-	if len(rawTx.Signatures[rawTx.Account.AccountID]) != 1 {
+	//连续nonce的多笔交易每笔一个签名
+	if len(rawTx.Signatures[rawTx.Account.AccountID]) != rawTransactionCount(rawTx) {
 		decoder.wm.Log.Error("signature failed in account[%v].", rawTx.Account.AccountID)
 		return openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "signature failed in account.")
 	}
 
-	signnode := rawTx.Signatures[rawTx.Account.AccountID][0]
-	fromAddr := signnode.Address
+	for _, signnode := range rawTx.Signatures[rawTx.Account.AccountID] {
+		fromAddr := signnode.Address
 
-	childKey, _ := key.DerivedKeyWithPath(fromAddr.HDPath, owcrypt.ECC_CURVE_SECP256K1)
-	keyBytes, err := childKey.GetPrivateKeyBytes()
-	if err != nil {
-		//decoder.wm.Log.Error("get private key bytes, err=", err)
-		return openwallet.NewError(openwallet.ErrSignRawTransactionFailed, err.Error())
-	}
-	//prikeyStr := common.ToHex(keyBytes)
-	//decoder.wm.Log.Debugf("pri:%v", common.ToHex(keyBytes))
+		childKey, _ := key.DerivedKeyWithPath(fromAddr.HDPath, owcrypt.ECC_CURVE_SECP256K1)
+		keyBytes, err := childKey.GetPrivateKeyBytes()
+		if err != nil {
+			//decoder.wm.Log.Error("get private key bytes, err=", err)
+			return openwallet.NewError(openwallet.ErrSignRawTransactionFailed, err.Error())
+		}
+		//prikeyStr := common.ToHex(keyBytes)
+		//decoder.wm.Log.Debugf("pri:%v", common.ToHex(keyBytes))
 
-	message, err := hex.DecodeString(signnode.Message)
-	if err != nil {
-		return err
-	}
+		message, err := hex.DecodeString(signnode.Message)
+		if err != nil {
+			return err
+		}
 
-	signature, v, sigErr := owcrypt.Signature(keyBytes, nil, message, decoder.wm.CurveType())
-	if sigErr != owcrypt.SUCCESS {
-		return fmt.Errorf("transaction hash sign failed")
-	}
-	signature = append(signature, v)
+		signature, v, sigErr := owcrypt.Signature(keyBytes, nil, message, decoder.wm.CurveType())
+		if sigErr != owcrypt.SUCCESS {
+			return fmt.Errorf("transaction hash sign failed")
+		}
+		signature = append(signature, v)
 
-	signnode.Signature = hex.EncodeToString(signature)
+		signnode.Signature = hex.EncodeToString(signature)
+	}
 
 	//decoder.wm.Log.Debug("** pri:", hex.EncodeToString(keyBytes))
 	//decoder.wm.Log.Debug("** message:", signnode.Message)
@@ -367,12 +376,18 @@ func (decoder *EthTransactionDecoder) SubmitRawTransaction(wrapper openwallet.Wa
 		return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "wallet signature not found ")
 	}
 
+	//多个接收方拆分的连续nonce交易逐笔广播
+	if isSequentialRawTransaction(rawTx) {
+		return decoder.submitSequentialRawTransaction(wrapper, rawTx)
+	}
+
 	from := rawTx.Signatures[rawTx.Account.AccountID][0].Address.Address
 	sig := rawTx.Signatures[rawTx.Account.AccountID][0].Signature
 
 	//decoder.wm.Log.Debug("rawTx.ExtParam:", rawTx.ExtParam)
 
-	signer := types.NewEIP155Signer(big.NewInt(int64(decoder.wm.Config.ChainID)))
+	//兼容替换交易的EIP-1559交易类型，传统交易与EIP155签名一致
+	signer := types.LatestSignerForChainID(big.NewInt(int64(decoder.wm.Config.ChainID)))
 
 	rawHex, err := hex.DecodeString(rawTx.RawHex)
 	if err != nil {
@@ -381,7 +396,7 @@ func (decoder *EthTransactionDecoder) SubmitRawTransaction(wrapper openwallet.Wa
 	}
 
 	tx := &types.Transaction{}
-	err = rlp.DecodeBytes(rawHex, tx)
+	err = tx.UnmarshalBinary(rawHex)
 	if err != nil {
 		decoder.wm.Log.Error("transaction RLP decode failed, err:", err)
 		return nil, err
@@ -395,10 +410,19 @@ func (decoder *EthTransactionDecoder) SubmitRawTransaction(wrapper openwallet.Wa
 		return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "tx with signature failed. ")
 	}
 
+	//广播前模拟执行，执行失败不广播
+	if decoder.wm.needSimulate(rawTx.GetExtParam()) {
+		err = decoder.wm.SimulateTransaction(tx)
+		if err != nil {
+			decoder.wm.Log.Std.Error("simulate tx failed, err=%v", err)
+			return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "simulate tx failed. %v", err)
+		}
+	}
+
 	//txstr, _ := json.MarshalIndent(tx, "", " ")
 	//decoder.wm.Log.Debug("**after signed txStr:", string(txstr))
 
-	rawTxPara, err := rlp.EncodeToBytes(tx)
+	rawTxPara, err := tx.MarshalBinary()
 	if err != nil {
 		decoder.wm.Log.Std.Error("encode tx to rlp failed, err=%v ", err)
 		return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "encode tx to rlp failed. ")
@@ -407,13 +431,23 @@ func (decoder *EthTransactionDecoder) SubmitRawTransaction(wrapper openwallet.Wa
 	txid, err := decoder.wm.SendRawTransaction(hexutil.Encode(rawTxPara))
 	if err != nil {
 		decoder.wm.Log.Std.Error("sent raw tx faild, err=%v", err)
-		//交易失败重置地址nonce
-		decoder.wm.UpdateAddressNonce(wrapper, from, 0)
+		//交易失败释放预留的nonce，替换交易没有预留不受影响
+		decoder.wm.NonceManager.Release(from, tx.Nonce())
 		return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "sent raw tx faild. unexpected error: %v", err)
 	}
 
-	//交易成功，地址nonce+1并记录到缓存
-	decoder.wm.UpdateAddressNonce(wrapper, from, tx.Nonce()+1)
+	//交易成功，记录nonce已使用
+	decoder.wm.NonceManager.Commit(from, tx.Nonce(), txid)
+	decoder.wm.journalTransaction(from, tx, txid)
+
+	if originTxID, isReplacement := isReplacementRawTransaction(rawTx); isReplacement {
+		//原交易和替换交易记录在同一交易组，扫描时以上链的交易为准
+		if isCancelRawTransaction(rawTx) {
+			decoder.wm.TxReplacements.TrackCancel(from, tx.Nonce(), originTxID, txid)
+		} else {
+			decoder.wm.TxReplacements.Track(from, tx.Nonce(), originTxID, txid)
+		}
+	}
 
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
@@ -446,6 +480,23 @@ func (decoder *EthTransactionDecoder) SubmitRawTransaction(wrapper openwallet.Wa
 
 	owtx.WxID = openwallet.GenTransactionWxID(owtx)
 
+	//扩展参数awaitResult为true时，等待交易达到确认数
+	if extParam := rawTx.GetExtParam(); extParam.Get("awaitResult").Bool() {
+		event, awaitErr := decoder.wm.awaitTransaction(txid, extParam.Get("awaitTimeout").Uint())
+		if awaitErr != nil {
+			decoder.wm.Log.Errorf("await transaction %s failed, err: %v", txid, awaitErr)
+			//交易已广播，标记为待确认，不能当作失败重新发送
+			owtx.Status = TxStatusPending
+			owtx.Reason = fmt.Sprintf("await transaction result failed: %v", awaitErr)
+			return owtx, nil
+		}
+		owtx.BlockHash = event.BlockHash
+		owtx.BlockHeight = event.BlockHeight
+		owtx.Confirm = int64(event.Confirmations)
+		owtx.ConfirmTime = time.Now().Unix()
+		owtx.Status = txStatus(event.Success)
+	}
+
 	return owtx, nil
 }
 
@@ -468,22 +519,28 @@ func (decoder *EthTransactionDecoder) VerifyRawTransaction(wrapper openwallet.Wa
 		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "transaction signature is empty")
 	}
 
-	sig := accountSig[0].Signature
-	msg := accountSig[0].Message
-	pubkey := accountSig[0].Address.PublicKey
-	//curveType := rawTx.Signatures[rawTx.Account.AccountID][0].EccType
+	if len(accountSig) != rawTransactionCount(rawTx) {
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "len of signatures error. ")
+	}
 
-	decoder.wm.Log.Debug("-- pubkey:", pubkey)
-	decoder.wm.Log.Debug("-- message:", msg)
-	decoder.wm.Log.Debug("-- Signature:", sig)
-	signature := ethcom.FromHex(sig)
-	publickKey := owcrypt.PointDecompress(ethcom.FromHex(pubkey), owcrypt.ECC_CURVE_SECP256K1)
-	publickKey = publickKey[1:len(publickKey)]
-	ret := owcrypt.Verify(publickKey, nil, ethcom.FromHex(msg), signature[0:len(signature)-1], owcrypt.ECC_CURVE_SECP256K1)
-	if ret != owcrypt.SUCCESS {
-		errinfo := fmt.Sprintf("verify error, ret:%v\n", "0x"+strconv.FormatUint(uint64(ret), 16))
-		//fmt.Println(errinfo)
-		return errors.New(errinfo)
+	for _, keySignature := range accountSig {
+		sig := keySignature.Signature
+		msg := keySignature.Message
+		pubkey := keySignature.Address.PublicKey
+		//curveType := rawTx.Signatures[rawTx.Account.AccountID][0].EccType
+
+		decoder.wm.Log.Debug("-- pubkey:", pubkey)
+		decoder.wm.Log.Debug("-- message:", msg)
+		decoder.wm.Log.Debug("-- Signature:", sig)
+		signature := ethcom.FromHex(sig)
+		publickKey := owcrypt.PointDecompress(ethcom.FromHex(pubkey), owcrypt.ECC_CURVE_SECP256K1)
+		publickKey = publickKey[1:len(publickKey)]
+		ret := owcrypt.Verify(publickKey, nil, ethcom.FromHex(msg), signature[0:len(signature)-1], owcrypt.ECC_CURVE_SECP256K1)
+		if ret != owcrypt.SUCCESS {
+			errinfo := fmt.Sprintf("verify error, ret:%v\n", "0x"+strconv.FormatUint(uint64(ret), 16))
+			//fmt.Println(errinfo)
+			return errors.New(errinfo)
+		}
 	}
 
 	return nil
@@ -629,7 +686,6 @@ func (decoder *EthTransactionDecoder) CreateErc20TokenSummaryRawTransaction(wrap
 		minTransfer        *big.Int
 		retainedBalance    *big.Int
 		feesSupportAccount *openwallet.AssetsAccount
-		tmpNonce           uint64
 	)
 
 	// 如果有提供手续费账户，检查账户是否存在
@@ -652,12 +708,6 @@ func (decoder *EthTransactionDecoder) CreateErc20TokenSummaryRawTransaction(wrap
 			return nil, openwallet.Errorf(openwallet.ErrAccountNotAddress, "fees support account have not addresses")
 		}
 
-		nonce := decoder.wm.GetAddressNonce(wrapper, feesAddresses[0].Address)
-		//nonce, feesSupportErr := decoder.wm.GetTransactionCount(feesAddresses[0].Address)
-		//if feesSupportErr != nil {
-		//	return nil, openwallet.NewError(openwallet.ErrNonceInvaild, "fees support account get nonce failed")
-		//}
-		tmpNonce = nonce
 	}
 	//tokenCoin := sumRawTx.Coin.Contract.Token
 	tokenDecimals := int32(sumRawTx.Coin.Contract.Decimals)
@@ -780,7 +830,8 @@ func (decoder *EthTransactionDecoder) CreateErc20TokenSummaryRawTransaction(wrap
 					Required: 1,
 				}
 
-				createTxErr := decoder.CreateSimpleRawTransaction(wrapper, rawTx, &tmpNonce)
+				//nonce由NonceManager按发送地址依次预留，保证连续递增
+				createTxErr := decoder.CreateSimpleRawTransaction(wrapper, rawTx, nil)
 				rawTxWithErr := &openwallet.RawTransactionWithError{
 					RawTx: rawTx,
 					Error: openwallet.ConvertError(createTxErr),
@@ -789,9 +840,6 @@ func (decoder *EthTransactionDecoder) CreateErc20TokenSummaryRawTransaction(wrap
 				//创建成功，添加到队列
 				rawTxArray = append(rawTxArray, rawTxWithErr)
 
-				//需要手续费支持的地址会有很多个，nonce要连续递增以保证交易广播生效
-				tmpNonce++
-
 				//汇总下一个
 				continue
 			}
@@ -838,10 +886,8 @@ func (decoder *EthTransactionDecoder) createRawTransaction(wrapper openwallet.Wa
 		accountTotalSent = decimal.Zero
 		txFrom           = make([]string, 0)
 		txTo             = make([]string, 0)
-		keySignList      = make([]*openwallet.KeySignature, 0)
 		amountStr        string
 		destination      string
-		tx               *types.Transaction
 	)
 
 	isContract := rawTx.Coin.IsContract
@@ -885,25 +931,13 @@ func (decoder *EthTransactionDecoder) createRawTransaction(wrapper openwallet.Wa
 		return openwallet.NewError(openwallet.ErrAccountNotAddress, err.Error())
 	}
 
-	var nonce uint64
-	if tmpNonce == nil {
-		//使用外部传入的扩展字段填充nonce
-		if rawTx.GetExtParam().Exists() {
-			nonce = rawTx.GetExtParam().Get("nonce").Uint()
-		} else {
-			txNonce := decoder.wm.GetAddressNonce(wrapper, addrBalance.Address)
-			//decoder.wm.Log.Debugf("txNonce: %d", txNonce)
-			nonce = txNonce
-		}
-	} else {
-		nonce = *tmpNonce
-	}
-
-	//decoder.wm.Log.Debug("chainID:", decoder.wm.GetConfig().ChainID)
-	signer := types.NewEIP155Signer(big.NewInt(int64(decoder.wm.Config.ChainID)))
-
 	gasLimit := fee.GasLimit.Uint64()
 
+	var (
+		toAddr  ethcom.Address
+		txValue *big.Int
+		txData  []byte
+	)
 	if isContract {
 		//构建合约交易
 		amount := common.StringNumToBigIntWithExp(amountStr, tokenDecimals)
@@ -918,8 +952,9 @@ func (decoder *EthTransactionDecoder) createRawTransaction(wrapper openwallet.Wa
 			//return openwallet.Errorf("the [%s] balance: %s is not enough to call smart contract", rawTx.Coin.Symbol, coinBalance)
 		}
 
-		tx = types.NewTransaction(nonce, ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(rawTx.Coin.Contract.Address)),
-			big.NewInt(0), gasLimit, fee.GasPrice, ethcom.FromHex(callData))
+		toAddr = ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(rawTx.Coin.Contract.Address))
+		txValue = big.NewInt(0)
+		txData = ethcom.FromHex(callData)
 	} else {
 		//构建QUORUM交易
 		amount := common.StringNumToBigIntWithExp(amountStr, decoder.wm.Decimal())
@@ -931,13 +966,42 @@ func (decoder *EthTransactionDecoder) createRawTransaction(wrapper openwallet.Wa
 			//return openwallet.Errorf("the [%s] balance: %s is not enough", rawTx.Coin.Symbol, amountStr)
 		}
 
-		tx = types.NewTransaction(nonce, ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(destination)),
-			amount, gasLimit, fee.GasPrice, []byte(""))
+		toAddr = ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(destination))
+		txValue = amount
+		txData = []byte("")
+	}
+
+	return decoder.buildRawTransaction(rawTx, addr, toAddr, txValue, gasLimit, fee.GasPrice, txData, tmpNonce)
+}
+
+// buildRawTransaction 分配nonce，构建待签名的交易单
+func (decoder *EthTransactionDecoder) buildRawTransaction(rawTx *openwallet.RawTransaction, addr *openwallet.Address, toAddr ethcom.Address, txValue *big.Int, gasLimit uint64, gasPrice *big.Int, txData []byte, tmpNonce *uint64) *openwallet.Error {
+
+	//decoder.wm.Log.Debug("chainID:", decoder.wm.GetConfig().ChainID)
+	signer := types.NewEIP155Signer(big.NewInt(int64(decoder.wm.Config.ChainID)))
+
+	//检查通过后再分配nonce，避免预留的nonce因构建失败产生缺口
+	var (
+		nonce    uint64
+		reserved bool
+	)
+	if tmpNonce == nil {
+		//使用外部传入的扩展字段填充nonce
+		txNonce, txReserved, nonceErr := decoder.wm.selectTxNonce(addr.Address, rawTx.GetExtParam(), 1)
+		if nonceErr != nil {
+			return openwallet.Errorf(openwallet.ErrNonceInvaild, "reserve address nonce failed, err: %v", nonceErr)
+		}
+		nonce, reserved = txNonce, txReserved
+	} else {
+		nonce = *tmpNonce
 	}
 
+	tx := types.NewTransaction(nonce, toAddr, txValue, gasLimit, gasPrice, txData)
+
 	rawHex, err := rlp.EncodeToBytes(tx)
 	if err != nil {
 		decoder.wm.Log.Error("Transaction RLP encode failed, err:", err)
+		decoder.wm.releaseTxNonce(addr.Address, nonce, 1, reserved)
 		return openwallet.ConvertError(err)
 	}
 
@@ -956,10 +1020,9 @@ func (decoder *EthTransactionDecoder) createRawTransaction(wrapper openwallet.Wa
 		Message: hex.EncodeToString(msg[:]),
 		RSV:     true,
 	}
-	keySignList = append(keySignList, &signature)
 
 	rawTx.RawHex = hex.EncodeToString(rawHex)
-	rawTx.Signatures[rawTx.Account.AccountID] = keySignList
+	rawTx.Signatures[rawTx.Account.AccountID] = []*openwallet.KeySignature{&signature}
 	rawTx.IsBuilt = true
 
 	return nil
@@ -0,0 +1,276 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Disperse合约ABI，与disperse.app合约接口一致
+	DISPERSE_ABI_JSON = `[{"constant":false,"inputs":[{"name":"token","type":"address"},{"name":"recipients","type":"address[]"},{"name":"values","type":"uint256[]"}],"name":"disperseToken","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":false,"inputs":[{"name":"recipients","type":"address[]"},{"name":"values","type":"uint256[]"}],"name":"disperseEther","outputs":[],"payable":true,"stateMutability":"payable","type":"function"}]`
+
+	// 扩展参数：批量转账使用的disperse合约
+	disperseExtParamKey = "disperse"
+)
+
+var (
+	DISPERSE_ABI, _ = abi.JSON(strings.NewReader(DISPERSE_ABI_JSON))
+)
+
+// disperseRecipient 批量转账的接收方
+type disperseRecipient struct {
+	Address   string
+	AmountStr string
+	Amount    *big.Int
+}
+
+// parseDisperseRecipients 解析rawTx.To，按地址排序保证编码结果稳定
+func (decoder *EthTransactionDecoder) parseDisperseRecipients(to map[string]string, decimals int32) ([]*disperseRecipient, *big.Int, error) {
+
+	recipients := make([]*disperseRecipient, 0, len(to))
+	total := big.NewInt(0)
+	for address, amountStr := range to {
+		if !decoder.wm.Decoder.AddressVerify(AppendOxToAddress(decoder.wm.CustomAddressDecodeFunc(address))) {
+			return nil, nil, fmt.Errorf("recipient address %s is invalid", address)
+		}
+		amount := common.StringNumToBigIntWithExp(amountStr, decimals)
+		if amount.Sign() <= 0 {
+			return nil, nil, fmt.Errorf("recipient %s amount %s is invalid", address, amountStr)
+		}
+		recipients = append(recipients, &disperseRecipient{Address: address, AmountStr: amountStr, Amount: amount})
+		total.Add(total, amount)
+	}
+	sort.Slice(recipients, func(i, j int) bool {
+		return recipients[i].Address < recipients[j].Address
+	})
+	return recipients, total, nil
+}
+
+// encodeDisperseData 编码disperse合约调用，contractAddress为空表示主链币
+func (decoder *EthTransactionDecoder) encodeDisperseData(contractAddress string, recipients []*disperseRecipient) ([]byte, error) {
+	addresses := make([]ethcom.Address, 0, len(recipients))
+	values := make([]*big.Int, 0, len(recipients))
+	for _, r := range recipients {
+		addresses = append(addresses, ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(r.Address)))
+		values = append(values, r.Amount)
+	}
+	if len(contractAddress) == 0 {
+		return DISPERSE_ABI.Pack("disperseEther", addresses, values)
+	}
+	token := ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(contractAddress))
+	return DISPERSE_ABI.Pack("disperseToken", token, addresses, values)
+}
+
+// CreateDisperseRawTransaction 通过disperse合约创建多个接收方的批量转账交易单，
+// 代币批量转账需要先授权disperse合约，授权额度不少于转账总额
+func (decoder *EthTransactionDecoder) CreateDisperseRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	disperseAddress := decoder.wm.Config.DisperseAddress
+	if len(disperseAddress) == 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "disperse contract is not configured, can not send to multiple recipients")
+	}
+	//disperse合约地址不是合约时，主链币会直接转入该地址
+	isContract, err := decoder.wm.IsContract(disperseAddress)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrCallFullNodeAPIFailed, err.Error())
+	}
+	if !isContract {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "disperse address %s is not a contract", disperseAddress)
+	}
+
+	isToken := rawTx.Coin.IsContract
+	contractAddress := ""
+	decimals := decoder.wm.Decimal()
+	if isToken {
+		contractAddress = rawTx.Coin.Contract.Address
+		decimals = int32(rawTx.Coin.Contract.Decimals)
+	}
+
+	recipients, total, err := decoder.parseDisperseRecipients(rawTx.To, decimals)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, err.Error())
+	}
+	data, err := decoder.encodeDisperseData(contractAddress, recipients)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, err.Error())
+	}
+
+	candidates, findErr := decoder.findBatchAddresses(wrapper, rawTx, total, decimals)
+	if findErr != nil {
+		return findErr
+	}
+
+	var (
+		findAddress string
+		feeInfo     *txFeeInfo
+		txValue     = big.NewInt(0)
+		lastErr     *openwallet.Error
+	)
+	if !isToken {
+		txValue = total
+	}
+
+	for _, address := range candidates {
+
+		if isToken {
+			//disperseToken先将总额转入disperse合约，需要足够的授权额度
+			allowance, allowanceErr := decoder.wm.ERC20GetAllowance(contractAddress, address, disperseAddress)
+			if allowanceErr != nil || allowance.Cmp(total) < 0 {
+				lastErr = openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "the allowance of disperse contract %s is not enough", disperseAddress)
+				continue
+			}
+		}
+
+		fee, feeErr := decoder.wm.GetTransactionFeeEstimated(address, disperseAddress, txValue, data)
+		if feeErr != nil {
+			lastErr = openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, feeErr.Error())
+			continue
+		}
+		if rawTx.FeeRate != "" {
+			fee.GasPrice = common.StringNumToBigIntWithExp(rawTx.FeeRate, decoder.wm.Decimal())
+			fee.CalcFee()
+		}
+
+		//总消耗数量 = 转账总额 + 手续费
+		coinBalance, balanceErr := decoder.wm.GetAddrBalance(address, "pending")
+		if balanceErr != nil {
+			lastErr = openwallet.NewError(openwallet.ErrCallFullNodeAPIFailed, balanceErr.Error())
+			continue
+		}
+		totalCost := new(big.Int).Add(txValue, fee.Fee)
+		if coinBalance.Cmp(totalCost) < 0 {
+			coinBalance := common.BigIntToDecimals(coinBalance, decoder.wm.Decimal())
+			lastErr = openwallet.Errorf(openwallet.ErrInsufficientFees, "the [%s] balance: %s is not enough", decoder.wm.Symbol(), coinBalance.String())
+			continue
+		}
+
+		//只要找到一个合适使用的地址余额就停止遍历
+		findAddress = address
+		feeInfo = fee
+		break
+	}
+
+	if len(findAddress) == 0 {
+		return lastErr
+	}
+	addr, err := wrapper.GetAddress(findAddress)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrAccountNotAddress, err.Error())
+	}
+
+	decoder.fillBatchRawTransaction(wrapper, rawTx, findAddress, recipients, total, feeInfo.Fee, feeInfo.GasPrice, decimals)
+
+	extParam := make(map[string]interface{})
+	if len(rawTx.ExtParam) > 0 {
+		json.Unmarshal([]byte(rawTx.ExtParam), &extParam)
+	}
+	extParam[disperseExtParamKey] = strings.ToLower(disperseAddress)
+	extContent, _ := json.Marshal(extParam)
+	rawTx.ExtParam = string(extContent)
+
+	toAddr := ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(disperseAddress))
+	createErr := decoder.buildRawTransaction(rawTx, addr, toAddr, txValue, feeInfo.GasLimit.Uint64(), feeInfo.GasPrice, data, nil)
+	if createErr != nil {
+		return createErr
+	}
+	return nil
+}
+
+// findBatchAddresses 查找余额足够支付转账总额的地址，代币比较代币余额
+func (decoder *EthTransactionDecoder) findBatchAddresses(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, total *big.Int, decimals int32) ([]string, *openwallet.Error) {
+
+	//获取wallet
+	addresses, err := wrapper.GetAddressList(0, -1,
+		"AccountID", rawTx.Account.AccountID)
+	if err != nil {
+		return nil, openwallet.NewError(openwallet.ErrAddressNotFound, err.Error())
+	}
+	if len(addresses) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", rawTx.Account.AccountID)
+	}
+	searchAddrs := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		searchAddrs = append(searchAddrs, address.Address)
+	}
+
+	candidates := make([]string, 0)
+	if rawTx.Coin.IsContract {
+		tokenBalances, balanceErr := decoder.wm.ContractDecoder.GetTokenBalanceByAddress(rawTx.Coin.Contract, searchAddrs...)
+		if balanceErr != nil {
+			return nil, openwallet.NewError(openwallet.ErrCallFullNodeAPIFailed, balanceErr.Error())
+		}
+		for _, b := range tokenBalances {
+			if common.StringNumToBigIntWithExp(b.Balance.Balance, decimals).Cmp(total) >= 0 {
+				candidates = append(candidates, b.Balance.Address)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientTokenBalanceOfAddress, "the token balance of all addresses is not enough")
+		}
+	} else {
+		balances, balanceErr := decoder.wm.Blockscanner.GetBalanceByAddress(searchAddrs...)
+		if balanceErr != nil {
+			return nil, openwallet.NewError(openwallet.ErrCallFullNodeAPIFailed, balanceErr.Error())
+		}
+		for _, b := range balances {
+			if common.StringNumToBigIntWithExp(b.Balance, decimals).Cmp(total) >= 0 {
+				candidates = append(candidates, b.Address)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", common.BigIntToDecimals(total, decimals).String())
+		}
+	}
+	return candidates, nil
+}
+
+// fillBatchRawTransaction 填充批量转账交易单的手续费、转出总额和每个接收方的输出
+func (decoder *EthTransactionDecoder) fillBatchRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, from string, recipients []*disperseRecipient, total, fee, gasPrice *big.Int, decimals int32) {
+
+	//每个接收方一条输出
+	accountTotalSent := decimal.Zero
+	txTo := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		txTo = append(txTo, fmt.Sprintf("%s:%s", r.Address, r.AmountStr))
+		accountAddresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", r.Address)
+		if findErr != nil || len(accountAddresses) == 0 {
+			amountDec, _ := decimal.NewFromString(r.AmountStr)
+			accountTotalSent = accountTotalSent.Add(amountDec)
+		}
+	}
+	totalDec := common.BigIntToDecimals(total, decimals)
+	feesDec := common.BigIntToDecimals(fee, decoder.wm.Decimal())
+	if !rawTx.Coin.IsContract {
+		accountTotalSent = accountTotalSent.Add(feesDec)
+	}
+
+	rawTx.FeeRate = common.BigIntToDecimals(gasPrice, decoder.wm.Decimal()).String()
+	rawTx.Fees = feesDec.String()
+	rawTx.TxAmount = decimal.Zero.Sub(accountTotalSent).String()
+	rawTx.TxFrom = []string{fmt.Sprintf("%s:%s", from, totalDec.String())}
+	rawTx.TxTo = txTo
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestEthTransactionDecoder_encodeDisperseData(t *testing.T) {
+	wm := NewWalletManager()
+	decoder := NewTransactionDecoder(wm)
+
+	to := map[string]string{
+		"0x2222222222222222222222222222222222222222": "0.5",
+		"0x1111111111111111111111111111111111111111": "1.25",
+	}
+	recipients, total, err := decoder.parseDisperseRecipients(to, 18)
+	if err != nil {
+		t.Errorf("parseDisperseRecipients failed, err: %v", err)
+		return
+	}
+	if total.String() != "1750000000000000000" {
+		t.Errorf("total: %s, expected: 1750000000000000000", total.String())
+	}
+	if recipients[0].Address != "0x1111111111111111111111111111111111111111" {
+		t.Errorf("recipients are not sorted by address")
+	}
+
+	data, err := decoder.encodeDisperseData("", recipients)
+	if err != nil {
+		t.Errorf("encodeDisperseData failed, err: %v", err)
+		return
+	}
+	if method := hexutil.Encode(data[:4]); method != hexutil.Encode(DISPERSE_ABI.Methods["disperseEther"].ID) {
+		t.Errorf("disperseEther method id: %s", method)
+	}
+
+	data, err = decoder.encodeDisperseData("0x3333333333333333333333333333333333333333", recipients)
+	if err != nil {
+		t.Errorf("encodeDisperseData failed, err: %v", err)
+		return
+	}
+	if method := hexutil.Encode(data[:4]); method != hexutil.Encode(DISPERSE_ABI.Methods["disperseToken"].ID) {
+		t.Errorf("disperseToken method id: %s", method)
+	}
+
+	if _, _, err = decoder.parseDisperseRecipients(map[string]string{"0x1234": "1"}, 18); err == nil {
+		t.Errorf("parseDisperseRecipients should fail with invalid address")
+	}
+	if _, _, err = decoder.parseDisperseRecipients(map[string]string{"0x1111111111111111111111111111111111111111": "0"}, 18); err == nil {
+		t.Errorf("parseDisperseRecipients should fail with zero amount")
+	}
+}
@@ -0,0 +1,355 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/tidwall/gjson"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// 默认检查交易日志的间隔，秒
+	DefaultTxRebroadcastInterval = 60
+	// 默认交易不在节点交易池多久后重新广播，秒
+	DefaultTxRebroadcastTimeout = 3 * 60
+	// 默认交易提交多久后仍未上链标记为丢弃，秒
+	DefaultTxDropTimeout = 24 * 60 * 60
+
+	// 已结束的交易日志保留时间
+	txJournalRetention = 7 * 24 * time.Hour
+
+	txJournalBucket = "transactions"
+)
+
+const (
+	TxJournalStatusPending  = "pending"  //已广播，未上链
+	TxJournalStatusMined    = "mined"    //已上链
+	TxJournalStatusDropped  = "dropped"  //超时未上链，不再重新广播
+	TxJournalStatusReplaced = "replaced" //nonce已被其他交易使用
+)
+
+// JournalTransaction 已广播的签名交易记录
+type JournalTransaction struct {
+	TxID           string `json:"txid"`
+	From           string `json:"from"`
+	Nonce          uint64 `json:"nonce"`
+	SignedTx       string `json:"signedTx"` //签名后的交易，hex带0x
+	Status         string `json:"status"`
+	ReplacedBy     string `json:"replacedBy,omitempty"` //使用了相同nonce并上链的交易
+	BlockHeight    uint64 `json:"blockHeight,omitempty"`
+	BroadcastCount int    `json:"broadcastCount"`
+	SubmitTime     int64  `json:"submitTime"`
+	LastBroadcast  int64  `json:"lastBroadcast"`
+	UpdatedAt      int64  `json:"updatedAt"`
+}
+
+// TxJournal 已广播交易日志，保存在bolt数据库
+type TxJournal struct {
+	db       *bolt.DB
+	workerMu sync.Mutex
+	worker   *WalletManager //正在运行后台任务的钱包管理器，同一日志只运行一个
+}
+
+var (
+	//同一进程多次加载配置时共用数据库，bolt不支持重复打开同一文件
+	txJournals   = make(map[string]*TxJournal)
+	txJournalsMu sync.Mutex
+)
+
+// OpenTxJournal 打开交易日志数据库
+func OpenTxJournal(path string) (*TxJournal, error) {
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	txJournalsMu.Lock()
+	defer txJournalsMu.Unlock()
+
+	if journal, exist := txJournals[absPath]; exist {
+		return journal, nil
+	}
+
+	db, err := bolt.Open(absPath, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, bucketErr := tx.CreateBucketIfNotExists([]byte(txJournalBucket))
+		return bucketErr
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	journal := &TxJournal{db: db}
+	txJournals[absPath] = journal
+	return journal, nil
+}
+
+// Put 保存交易记录
+func (j *TxJournal) Put(record *JournalTransaction) error {
+	record.UpdatedAt = time.Now().Unix()
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return j.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(txJournalBucket)).Put([]byte(strings.ToLower(record.TxID)), content)
+	})
+}
+
+// Get 获取交易记录，不存在返回nil
+func (j *TxJournal) Get(txid string) (*JournalTransaction, error) {
+	var record *JournalTransaction
+	err := j.db.View(func(tx *bolt.Tx) error {
+		content := tx.Bucket([]byte(txJournalBucket)).Get([]byte(strings.ToLower(AppendOxToAddress(txid))))
+		if content == nil {
+			return nil
+		}
+		record = &JournalTransaction{}
+		return json.Unmarshal(content, record)
+	})
+	return record, err
+}
+
+// List 按条件列出交易记录，按地址和nonce排序
+func (j *TxJournal) List(filter func(record *JournalTransaction) bool) ([]*JournalTransaction, error) {
+	records := make([]*JournalTransaction, 0)
+	err := j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(txJournalBucket)).ForEach(func(k, v []byte) error {
+			var record JournalTransaction
+			if json.Unmarshal(v, &record) != nil {
+				return nil
+			}
+			if filter == nil || filter(&record) {
+				records = append(records, &record)
+			}
+			return nil
+		})
+	})
+	sort.Slice(records, func(a, b int) bool {
+		if records[a].From != records[b].From {
+			return records[a].From < records[b].From
+		}
+		return records[a].Nonce < records[b].Nonce
+	})
+	return records, err
+}
+
+// Delete 删除交易记录
+func (j *TxJournal) Delete(txid string) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(txJournalBucket)).Delete([]byte(strings.ToLower(AppendOxToAddress(txid))))
+	})
+}
+
+// journalTransaction 记录已广播的签名交易
+func (wm *WalletManager) journalTransaction(from string, tx *types.Transaction, txid string) {
+	if wm.TxJournal == nil {
+		return
+	}
+	signedTx, err := tx.MarshalBinary()
+	if err != nil {
+		return
+	}
+	now := time.Now().Unix()
+	record := &JournalTransaction{
+		TxID:           strings.ToLower(AppendOxToAddress(txid)),
+		From:           strings.ToLower(from),
+		Nonce:          tx.Nonce(),
+		SignedTx:       hexutil.Encode(signedTx),
+		Status:         TxJournalStatusPending,
+		BroadcastCount: 1,
+		SubmitTime:     now,
+		LastBroadcast:  now,
+	}
+	if err = wm.TxJournal.Put(record); err != nil {
+		wm.Log.Errorf("save transaction %s to journal failed, err: %v", txid, err)
+	}
+}
+
+// GetInflightTransactions 查询地址已广播未上链的交易，按nonce排序
+func (wm *WalletManager) GetInflightTransactions(address string) ([]*JournalTransaction, error) {
+	if wm.TxJournal == nil {
+		return make([]*JournalTransaction, 0), nil
+	}
+	address = strings.ToLower(address)
+	return wm.TxJournal.List(func(record *JournalTransaction) bool {
+		return record.From == address && record.Status == TxJournalStatusPending
+	})
+}
+
+// StartTxJournalWorker 启动后台任务，定时检查交易日志，重新广播不在交易池的交易。
+// 多个钱包管理器共用同一日志时，只有第一个启动的会运行后台任务
+func (wm *WalletManager) StartTxJournalWorker() {
+	wm.StopTxJournalWorker()
+	journal := wm.TxJournal
+	if journal == nil || wm.Config.TxRebroadcastInterval <= 0 {
+		return
+	}
+
+	journal.workerMu.Lock()
+	defer journal.workerMu.Unlock()
+	if journal.worker != nil {
+		wm.Log.Warningf("transaction journal worker is already running")
+		return
+	}
+	journal.worker = wm
+
+	quit := make(chan struct{})
+	wm.txJournalQuit = quit
+	interval := time.Duration(wm.Config.TxRebroadcastInterval) * time.Second
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				wm.CheckTxJournal()
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+// StopTxJournalWorker 停止交易日志后台任务
+func (wm *WalletManager) StopTxJournalWorker() {
+	if wm.txJournalQuit == nil {
+		return
+	}
+	close(wm.txJournalQuit)
+	wm.txJournalQuit = nil
+
+	if journal := wm.TxJournal; journal != nil {
+		journal.workerMu.Lock()
+		if journal.worker == wm {
+			journal.worker = nil
+		}
+		journal.workerMu.Unlock()
+	}
+}
+
+// CheckTxJournal 检查未上链的交易，更新状态并重新广播
+func (wm *WalletManager) CheckTxJournal() {
+
+	records, err := wm.TxJournal.List(nil)
+	if err != nil {
+		wm.Log.Errorf("list transaction journal failed, err: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, record := range records {
+		if record.Status != TxJournalStatusPending {
+			//清理过期的记录
+			if now.Sub(time.Unix(record.UpdatedAt, 0)) > txJournalRetention {
+				wm.TxJournal.Delete(record.TxID)
+			}
+			continue
+		}
+		if changed := wm.checkJournalTransaction(record, now); changed {
+			if putErr := wm.TxJournal.Put(record); putErr != nil {
+				wm.Log.Errorf("update transaction %s journal failed, err: %v", record.TxID, putErr)
+			}
+		}
+	}
+}
+
+// checkJournalTransaction 检查单笔交易，返回记录是否有变化
+func (wm *WalletManager) checkJournalTransaction(record *JournalTransaction, now time.Time) bool {
+
+	result, err := wm.WalletClient.Call("eth_getTransactionByHash", []interface{}{record.TxID})
+	if err != nil {
+		return false
+	}
+
+	if result.IsObject() {
+		blockNumber := result.Get("blockNumber")
+		if !blockNumber.Exists() || blockNumber.Type == gjson.Null {
+			//在交易池中等待打包
+			return false
+		}
+		height, _ := hexutil.DecodeUint64(blockNumber.String())
+		record.Status = TxJournalStatusMined
+		record.BlockHeight = height
+		return true
+	}
+
+	//节点可能不再索引已上链的交易（txlookup限制或负载均衡后的节点延迟），先查询回执
+	receipt, err := wm.WalletClient.Call("eth_getTransactionReceipt", []interface{}{record.TxID})
+	if err != nil {
+		return false
+	}
+	if receipt.IsObject() {
+		height, _ := hexutil.DecodeUint64(receipt.Get("blockNumber").String())
+		record.Status = TxJournalStatusMined
+		record.BlockHeight = height
+		return true
+	}
+
+	//节点不认识该交易，检查nonce是否已被其他交易使用
+	nonce, err := wm.GetTransactionCount(record.From)
+	if err != nil {
+		return false
+	}
+	if nonce > record.Nonce {
+		record.Status = TxJournalStatusReplaced
+		if group := wm.TxReplacements.Get(record.From, record.Nonce); group != nil && group.MinedTxID != record.TxID {
+			record.ReplacedBy = group.MinedTxID
+		}
+		wm.Log.Infof("transaction %s nonce %d is used by other transaction %s", record.TxID, record.Nonce, record.ReplacedBy)
+		return true
+	}
+
+	dropTimeout := time.Duration(wm.Config.TxDropTimeout) * time.Second
+	if dropTimeout > 0 && now.Sub(time.Unix(record.SubmitTime, 0)) > dropTimeout {
+		record.Status = TxJournalStatusDropped
+		wm.Log.Warningf("transaction %s is not mined after %v, marked as dropped", record.TxID, dropTimeout)
+		return true
+	}
+
+	//已发起取消的原交易不再重新广播，避免取消交易丢失后原交易重新生效
+	if group := wm.TxReplacements.Get(record.From, record.Nonce); group != nil && len(group.CancelTxIDs) > 0 && !group.IsCancel(record.TxID) {
+		return false
+	}
+
+	rebroadcastTimeout := time.Duration(wm.Config.TxRebroadcastTimeout) * time.Second
+	if now.Sub(time.Unix(record.LastBroadcast, 0)) < rebroadcastTimeout {
+		return false
+	}
+
+	record.LastBroadcast = now.Unix()
+	record.BroadcastCount++
+	if _, sendErr := wm.SendRawTransaction(record.SignedTx); sendErr != nil {
+		wm.Log.Errorf("rebroadcast transaction %s failed, err: %v", record.TxID, sendErr)
+	} else {
+		wm.Log.Infof("rebroadcast transaction %s, count: %d", record.TxID, record.BroadcastCount)
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTxJournal(t *testing.T) {
+	journal, err := OpenTxJournal(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Errorf("OpenTxJournal failed, err: %v", err)
+		return
+	}
+
+	from := "0x1234567890abcdef1234567890abcdef12345678"
+	records := []*JournalTransaction{
+		{TxID: "0xbb", From: from, Nonce: 2, Status: TxJournalStatusPending},
+		{TxID: "0xaa", From: from, Nonce: 1, Status: TxJournalStatusPending},
+		{TxID: "0xcc", From: from, Nonce: 0, Status: TxJournalStatusMined},
+		{TxID: "0xdd", From: "0xother", Nonce: 0, Status: TxJournalStatusPending},
+	}
+	for _, record := range records {
+		if err = journal.Put(record); err != nil {
+			t.Errorf("Put failed, err: %v", err)
+			return
+		}
+	}
+
+	record, err := journal.Get("AA")
+	if err != nil || record == nil || record.Nonce != 1 {
+		t.Errorf("Get failed, record: %+v, err: %v", record, err)
+		return
+	}
+
+	pending, err := journal.List(func(record *JournalTransaction) bool {
+		return record.From == from && record.Status == TxJournalStatusPending
+	})
+	if err != nil || len(pending) != 2 || pending[0].TxID != "0xaa" || pending[1].TxID != "0xbb" {
+		t.Errorf("List pending failed, records: %d, err: %v", len(pending), err)
+		return
+	}
+
+	journal.Delete("0xaa")
+	if record, _ = journal.Get("0xaa"); record != nil {
+		t.Errorf("Delete failed")
+		return
+	}
+
+	//同一路径重复打开返回同一个日志
+	reopened, err := OpenTxJournal(filepath.Join(filepath.Dir(journal.db.Path()), "journal.db"))
+	if err != nil || reopened != journal {
+		t.Errorf("reopen journal failed, err: %v", err)
+		return
+	}
+
+	//共用日志的钱包管理器只运行一个后台任务
+	first, second := NewWalletManager(), NewWalletManager()
+	first.TxJournal, second.TxJournal = journal, reopened
+	first.StartTxJournalWorker()
+	second.StartTxJournalWorker()
+	if first.txJournalQuit == nil || second.txJournalQuit != nil {
+		t.Errorf("only one journal worker should be running")
+	}
+	first.StopTxJournalWorker()
+	second.StartTxJournalWorker()
+	if second.txJournalQuit == nil {
+		t.Errorf("journal worker should start after the previous one stopped")
+	}
+	second.StopTxJournalWorker()
+}
+
+func TestWalletManager_checkJournalTransaction(t *testing.T) {
+
+	var (
+		mu        sync.Mutex
+		receipt   = "null"
+		broadcast int
+	)
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch method {
+		case "eth_getTransactionByHash":
+			return ""
+		case "eth_getTransactionReceipt":
+			return testRPCResult(receipt)
+		case "eth_getTransactionCount":
+			return testRPCResult(`"0x2"`)
+		case "eth_sendRawTransaction":
+			broadcast++
+			return testRPCResult(`"0xaa"`)
+		}
+		return ""
+	})
+	from := "0x1234567890abcdef1234567890abcdef12345678"
+	now := time.Now()
+
+	//节点不再索引交易但有回执，记为已上链而不是被替换
+	receipt = `{"transactionHash":"0xaa","blockNumber":"0x10","status":"0x1"}`
+	record := &JournalTransaction{TxID: "0xaa", From: from, Nonce: 1, Status: TxJournalStatusPending, SubmitTime: now.Unix()}
+	if !wm.checkJournalTransaction(record, now) || record.Status != TxJournalStatusMined || record.BlockHeight != 16 {
+		t.Errorf("transaction with receipt should be mined, status: %s, height: %d", record.Status, record.BlockHeight)
+	}
+
+	//已发起取消的原交易不重新广播，取消交易本身仍会重新广播
+	receipt = "null"
+	wm.TxReplacements.TrackCancel(from, 2, "0xbb", "0xcc")
+	record = &JournalTransaction{TxID: "0xbb", From: from, Nonce: 2, Status: TxJournalStatusPending, SubmitTime: now.Unix(), SignedTx: "0xf86b80"}
+	if wm.checkJournalTransaction(record, now) || broadcast != 0 {
+		t.Errorf("cancelled transaction should not be rebroadcast")
+	}
+	record = &JournalTransaction{TxID: "0xcc", From: from, Nonce: 2, Status: TxJournalStatusPending, SubmitTime: now.Unix(), SignedTx: "0xf86b80"}
+	if !wm.checkJournalTransaction(record, now) || broadcast != 1 {
+		t.Errorf("cancel transaction should be rebroadcast, broadcast: %d", broadcast)
+	}
+}
@@ -0,0 +1,662 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 节点默认要求替换交易的手续费至少提高10%
+	DefaultReplacementPriceBump = 10
+
+	// 交易单扩展字段，记录被替换的原交易hash
+	replaceTxIDExtParamKey = "replaceTxID"
+	// 交易单扩展字段，标记为取消交易
+	cancelExtParamKey = "cancel"
+	// 提取的交易单扩展字段，记录被替换而不会上链的交易hash
+	replacedTxIDsExtParamKey = "replacedTxIDs"
+	// 提取的交易单扩展字段，标记上链的是取消交易
+	cancelledExtParamKey = "cancelled"
+
+	// 取消交易是自转账，固定消耗21000gas
+	cancelTransactionGasLimit = 21000
+)
+
+const (
+	TxReplacementStatusPending  = "pending"  //交易组未上链
+	TxReplacementStatusMined    = "mined"    //交易已上链
+	TxReplacementStatusReplaced = "replaced" //同nonce的其他交易已上链
+)
+
+// TxReplacement 同一地址同一nonce的交易组，记录原交易和替换交易的hash
+type TxReplacement struct {
+	From        string   `json:"from"`
+	Nonce       uint64   `json:"nonce"`
+	TxIDs       []string `json:"txids"`                 //按提交顺序，第一个为原交易
+	CancelTxIDs []string `json:"cancelTxIDs,omitempty"` //取消交易的hash
+	MinedTxID   string   `json:"minedTxID"`             //上链的交易hash，为空表示未上链
+	UpdatedAt   int64    `json:"updatedAt"`
+}
+
+// Contains 交易组是否包含txid
+func (r *TxReplacement) Contains(txid string) bool {
+	txid = strings.ToLower(AppendOxToAddress(txid))
+	for _, id := range r.TxIDs {
+		if id == txid {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaced 返回除txid外的其他交易hash，txid上链后它们不会再上链
+func (r *TxReplacement) Replaced(txid string) []string {
+	txid = strings.ToLower(AppendOxToAddress(txid))
+	replaced := make([]string, 0, len(r.TxIDs))
+	for _, id := range r.TxIDs {
+		if id != txid {
+			replaced = append(replaced, id)
+		}
+	}
+	return replaced
+}
+
+// Status 交易在交易组中的状态
+func (r *TxReplacement) Status(txid string) string {
+	txid = strings.ToLower(AppendOxToAddress(txid))
+	switch r.MinedTxID {
+	case "":
+		return TxReplacementStatusPending
+	case txid:
+		return TxReplacementStatusMined
+	default:
+		return TxReplacementStatusReplaced
+	}
+}
+
+// IsCancel txid是否为取消交易
+func (r *TxReplacement) IsCancel(txid string) bool {
+	txid = strings.ToLower(AppendOxToAddress(txid))
+	for _, id := range r.CancelTxIDs {
+		if id == txid {
+			return true
+		}
+	}
+	return false
+}
+
+// Cancelled 上链的是否为取消交易
+func (r *TxReplacement) Cancelled() bool {
+	if len(r.MinedTxID) == 0 {
+		return false
+	}
+	for _, id := range r.CancelTxIDs {
+		if id == r.MinedTxID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *TxReplacement) clone() *TxReplacement {
+	cp := *r
+	cp.TxIDs = append([]string{}, r.TxIDs...)
+	cp.CancelTxIDs = append([]string{}, r.CancelTxIDs...)
+	return &cp
+}
+
+// TxReplacementStore 替换交易记录，按地址和nonce持久化到文件
+type TxReplacementStore struct {
+	dir     string //为空则只保存在内存
+	records map[string]*TxReplacement
+	txIndex map[string]string //txid -> 记录key
+	mu      sync.RWMutex
+}
+
+// NewTxReplacementStore 创建替换交易记录，dir为空则不持久化
+func NewTxReplacementStore(dir string) (*TxReplacementStore, error) {
+
+	s := &TxReplacementStore{
+		dir:     dir,
+		records: make(map[string]*TxReplacement),
+		txIndex: make(map[string]string),
+	}
+	if len(dir) == 0 {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		content, readErr := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if readErr != nil {
+			continue
+		}
+		var record TxReplacement
+		if json.Unmarshal(content, &record) != nil {
+			continue
+		}
+		s.index(&record)
+	}
+	return s, nil
+}
+
+func txReplacementKey(from string, nonce uint64) string {
+	return fmt.Sprintf("%s-%d", strings.ToLower(AppendOxToAddress(from)), nonce)
+}
+
+func (s *TxReplacementStore) index(record *TxReplacement) {
+	key := txReplacementKey(record.From, record.Nonce)
+	s.records[key] = record
+	for _, txid := range record.TxIDs {
+		s.txIndex[txid] = key
+	}
+}
+
+func (s *TxReplacementStore) save(record *TxReplacement) {
+	record.UpdatedAt = time.Now().Unix()
+	s.index(record)
+	if len(s.dir) == 0 {
+		return
+	}
+	key := txReplacementKey(record.From, record.Nonce)
+	content, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("marshal transaction replacement %s failed, err: %v", key, err)
+		return
+	}
+	if err = writeFileAtomic(filepath.Join(s.dir, key+".json"), content); err != nil {
+		log.Errorf("save transaction replacement %s failed, err: %v", key, err)
+	}
+}
+
+// Track 记录地址nonce对应的交易hash，返回更新后的交易组
+func (s *TxReplacementStore) Track(from string, nonce uint64, txids ...string) *TxReplacement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.track(from, nonce, txids...)
+	s.save(record)
+	return record.clone()
+}
+
+// TrackCancel 记录原交易和取消交易的hash，返回更新后的交易组
+func (s *TxReplacementStore) TrackCancel(from string, nonce uint64, originTxID, cancelTxID string) *TxReplacement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.track(from, nonce, originTxID, cancelTxID)
+	cancelTxID = strings.ToLower(AppendOxToAddress(cancelTxID))
+	exist := false
+	for _, id := range record.CancelTxIDs {
+		if id == cancelTxID {
+			exist = true
+			break
+		}
+	}
+	if !exist {
+		record.CancelTxIDs = append(record.CancelTxIDs, cancelTxID)
+	}
+	s.save(record)
+	return record.clone()
+}
+
+func (s *TxReplacementStore) track(from string, nonce uint64, txids ...string) *TxReplacement {
+	key := txReplacementKey(from, nonce)
+	record, exist := s.records[key]
+	if !exist {
+		record = &TxReplacement{
+			From:  strings.ToLower(AppendOxToAddress(from)),
+			Nonce: nonce,
+			TxIDs: make([]string, 0),
+		}
+	}
+	for _, txid := range txids {
+		if len(txid) == 0 || record.Contains(txid) {
+			continue
+		}
+		record.TxIDs = append(record.TxIDs, strings.ToLower(AppendOxToAddress(txid)))
+	}
+	return record
+}
+
+// Get 获取地址nonce对应的交易组
+func (s *TxReplacementStore) Get(from string, nonce uint64) *TxReplacement {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if record, exist := s.records[txReplacementKey(from, nonce)]; exist {
+		return record.clone()
+	}
+	return nil
+}
+
+// GetByTxID 获取txid所在的交易组
+func (s *TxReplacementStore) GetByTxID(txid string) *TxReplacement {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, exist := s.txIndex[strings.ToLower(AppendOxToAddress(txid))]
+	if !exist {
+		return nil
+	}
+	return s.records[key].clone()
+}
+
+// Confirm 记录交易组中上链的交易，txid不在任何交易组返回nil
+func (s *TxReplacementStore) Confirm(txid string) *TxReplacement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	txid = strings.ToLower(AppendOxToAddress(txid))
+	key, exist := s.txIndex[txid]
+	if !exist {
+		return nil
+	}
+	record := s.records[key]
+	if record.MinedTxID != txid {
+		record.MinedTxID = txid
+		s.save(record)
+	}
+	return record.clone()
+}
+
+// Remove 删除地址nonce对应的交易组
+func (s *TxReplacementStore) Remove(from string, nonce uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := txReplacementKey(from, nonce)
+	record, exist := s.records[key]
+	if !exist {
+		return
+	}
+	for _, txid := range record.TxIDs {
+		delete(s.txIndex, txid)
+	}
+	delete(s.records, key)
+	if len(s.dir) > 0 {
+		os.Remove(filepath.Join(s.dir, key+".json"))
+	}
+}
+
+// CreateReplacementRawTransaction 以相同nonce重建已广播的交易单并提高手续费，
+// feeRate为新的gasPrice(主币单位)，为空或低于节点替换要求时自动提高
+func (decoder *EthTransactionDecoder) CreateReplacementRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, feeRate string) (*openwallet.RawTransaction, error) {
+
+	if rawTx == nil || rawTx.Account == nil || len(rawTx.RawHex) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "raw transaction is not built")
+	}
+	if len(rawTx.TxID) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "raw transaction is not submitted")
+	}
+	keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
+	if len(keySignatures) != 1 || keySignatures[0].Address == nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "wallet signature not found")
+	}
+
+	rawHex, err := hex.DecodeString(rawTx.RawHex)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "raw hex is invalid")
+	}
+	origin := &types.Transaction{}
+	if err = origin.UnmarshalBinary(rawHex); err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction decode failed, err: %v", err)
+	}
+
+	from := keySignatures[0].Address.Address
+	tx, err := decoder.wm.newReplacementTransaction(from, origin, origin.To(), origin.Value(), origin.Gas(), origin.Data(), origin.AccessList(), feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement := &openwallet.RawTransaction{
+		Coin:     rawTx.Coin,
+		Account:  rawTx.Account,
+		To:       rawTx.To,
+		TxAmount: rawTx.TxAmount,
+		TxFrom:   rawTx.TxFrom,
+		TxTo:     rawTx.TxTo,
+		ExtParam: rawTx.ExtParam,
+	}
+	if err = decoder.buildReplacementRawTransaction(wrapper, replacement, from, rawTx.TxID, tx); err != nil {
+		return nil, err
+	}
+	return replacement, nil
+}
+
+// CreateReplacementRawTransactionByTxID 通过txid查找交易池中的交易，以相同nonce重建并提高手续费
+func (decoder *EthTransactionDecoder) CreateReplacementRawTransactionByTxID(wrapper openwallet.WalletDAI, txid string, feeRate string) (*openwallet.RawTransaction, error) {
+
+	origin, from, err := decoder.wm.GetPendingTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := decoder.wm.newReplacementTransaction(from, origin, origin.To(), origin.Value(), origin.Gas(), origin.Data(), origin.AccessList(), feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement, err := decoder.newReplacementRawTransaction(wrapper, from, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err = decoder.buildReplacementRawTransaction(wrapper, replacement, from, txid, tx); err != nil {
+		return nil, err
+	}
+	return replacement, nil
+}
+
+// CreateCancelRawTransaction 取消交易池中未上链的交易，以相同nonce创建0金额的自转账并提高手续费，
+// 取消交易上链后原交易标记为已替换
+func (decoder *EthTransactionDecoder) CreateCancelRawTransaction(wrapper openwallet.WalletDAI, txid string, feeRate string) (*openwallet.RawTransaction, error) {
+
+	origin, from, err := decoder.wm.GetPendingTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	self := ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(from))
+	tx, err := decoder.wm.newReplacementTransaction(from, origin, &self, big.NewInt(0), cancelTransactionGasLimit, nil, nil, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	cancel, err := decoder.newReplacementRawTransaction(wrapper, from, tx)
+	if err != nil {
+		return nil, err
+	}
+	cancel.ExtParam = fmt.Sprintf(`{"%s":true}`, cancelExtParamKey)
+	if err = decoder.buildReplacementRawTransaction(wrapper, cancel, from, txid, tx); err != nil {
+		return nil, err
+	}
+	return cancel, nil
+}
+
+// newReplacementRawTransaction 通过发送地址查找资产账户，创建主币交易单
+func (decoder *EthTransactionDecoder) newReplacementRawTransaction(wrapper openwallet.WalletDAI, from string, tx *types.Transaction) (*openwallet.RawTransaction, error) {
+
+	addr, err := wrapper.GetAddress(from)
+	if err != nil {
+		return nil, openwallet.NewError(openwallet.ErrAccountNotAddress, err.Error())
+	}
+	account, err := wrapper.GetAssetsAccountInfo(addr.AccountID)
+	if err != nil {
+		return nil, openwallet.NewError(openwallet.ErrAccountNotFound, err.Error())
+	}
+
+	to := ""
+	if tx.To() != nil {
+		to = decoder.wm.CustomAddressEncodeFunc(strings.ToLower(tx.To().String()))
+	}
+	amount := common.BigIntToDecimals(tx.Value(), decoder.wm.Decimal()).String()
+
+	rawTx := &openwallet.RawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     decoder.wm.Symbol(),
+			IsContract: false,
+		},
+		Account:  account,
+		To:       map[string]string{to: amount},
+		TxAmount: "-" + amount,
+		TxFrom:   []string{fmt.Sprintf("%s:%s", from, amount)},
+		TxTo:     []string{fmt.Sprintf("%s:%s", to, amount)},
+	}
+	return rawTx, nil
+}
+
+// buildReplacementRawTransaction 填充替换交易的签名信息，并记录原交易hash
+func (decoder *EthTransactionDecoder) buildReplacementRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, from, originTxID string, tx *types.Transaction) error {
+
+	addr, err := wrapper.GetAddress(from)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrAccountNotAddress, err.Error())
+	}
+
+	rawHex, err := tx.MarshalBinary()
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction encode failed, err: %v", err)
+	}
+
+	feePrice := tx.GasPrice()
+	fee := new(big.Int).Mul(feePrice, new(big.Int).SetUint64(tx.Gas()))
+
+	extParam := make(map[string]interface{})
+	if len(rawTx.ExtParam) > 0 {
+		json.Unmarshal([]byte(rawTx.ExtParam), &extParam)
+	}
+	extParam["nonce"] = tx.Nonce()
+	extParam[replaceTxIDExtParamKey] = strings.ToLower(AppendOxToAddress(originTxID))
+	extContent, _ := json.Marshal(extParam)
+
+	signer := types.LatestSignerForChainID(new(big.Int).SetUint64(decoder.wm.Config.ChainID))
+	msg := signer.Hash(tx)
+
+	rawTx.FeeRate = common.BigIntToDecimals(feePrice, decoder.wm.Decimal()).String()
+	rawTx.Fees = common.BigIntToDecimals(fee, decoder.wm.Decimal()).String()
+	rawTx.ExtParam = string(extContent)
+	rawTx.RawHex = hex.EncodeToString(rawHex)
+	rawTx.Signatures = map[string][]*openwallet.KeySignature{
+		rawTx.Account.AccountID: {
+			&openwallet.KeySignature{
+				EccType: decoder.wm.Config.CurveType,
+				Nonce:   "0x" + strconv.FormatUint(tx.Nonce(), 16),
+				Address: addr,
+				Message: hex.EncodeToString(msg[:]),
+				RSV:     true,
+			},
+		},
+	}
+	rawTx.IsBuilt = true
+
+	//记录原交易，替换交易广播后加入同一交易组
+	decoder.wm.TxReplacements.Track(from, tx.Nonce(), originTxID)
+	return nil
+}
+
+// GetPendingTransaction 查询交易池中未上链的交易，返回交易和发送地址
+func (wm *WalletManager) GetPendingTransaction(txid string) (*types.Transaction, string, error) {
+
+	result, err := wm.WalletClient.Call("eth_getTransactionByHash", []interface{}{AppendOxToAddress(txid)})
+	if err != nil {
+		return nil, "", err
+	}
+	if !result.IsObject() {
+		return nil, "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction %s not found", txid)
+	}
+	if blockNumber := result.Get("blockNumber"); blockNumber.Exists() && blockNumber.Type != gjson.Null {
+		return nil, "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction %s is already mined", txid)
+	}
+
+	tx := &types.Transaction{}
+	if err = tx.UnmarshalJSON([]byte(result.Raw)); err != nil {
+		return nil, "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction decode failed, err: %v", err)
+	}
+	from := wm.CustomAddressEncodeFunc(strings.ToLower(result.Get("from").String()))
+	return tx, from, nil
+}
+
+// newReplacementTransaction 以原交易的nonce创建新交易，手续费至少提高ReplacementPriceBump百分比，且不低于当前网络价格
+func (wm *WalletManager) newReplacementTransaction(from string, origin *types.Transaction, to *ethcom.Address, value *big.Int, gasLimit uint64, data []byte, accessList types.AccessList, feeRate string) (*types.Transaction, error) {
+
+	//原交易的nonce已被使用，不能再替换
+	nonce, err := wm.GetTransactionCount(from)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "get address nonce failed, err: %v", err)
+	}
+	if nonce > origin.Nonce() {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction nonce %d is already used", origin.Nonce())
+	}
+
+	bump := wm.Config.ReplacementPriceBump
+	if bump <= 0 {
+		bump = DefaultReplacementPriceBump
+	}
+
+	minPrice := big.NewInt(0)
+	if len(feeRate) > 0 {
+		minPrice = common.StringNumToBigIntWithExp(feeRate, wm.Decimal())
+	}
+	networkPrice, err := wm.GetGasPrice()
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "get gas price failed, err: %v", err)
+	}
+	if wm.Config.OffsetsGasPrice != nil {
+		networkPrice.Add(networkPrice, wm.Config.OffsetsGasPrice)
+	}
+
+	var tx *types.Transaction
+	switch origin.Type() {
+	case types.DynamicFeeTxType:
+		tipCap := bumpReplacementPrice(origin.GasTipCap(), bump)
+		feeCap := maxBigInt(bumpReplacementPrice(origin.GasFeeCap(), bump), networkPrice, minPrice)
+		if feeCap.Cmp(tipCap) < 0 {
+			feeCap = tipCap
+		}
+		tx = types.NewTx(&types.DynamicFeeTx{
+			ChainID:    new(big.Int).SetUint64(wm.Config.ChainID),
+			Nonce:      origin.Nonce(),
+			GasTipCap:  tipCap,
+			GasFeeCap:  feeCap,
+			Gas:        gasLimit,
+			To:         to,
+			Value:      value,
+			Data:       data,
+			AccessList: accessList,
+		})
+	case types.AccessListTxType:
+		tx = types.NewTx(&types.AccessListTx{
+			ChainID:    new(big.Int).SetUint64(wm.Config.ChainID),
+			Nonce:      origin.Nonce(),
+			GasPrice:   maxBigInt(bumpReplacementPrice(origin.GasPrice(), bump), networkPrice, minPrice),
+			Gas:        gasLimit,
+			To:         to,
+			Value:      value,
+			Data:       data,
+			AccessList: accessList,
+		})
+	default:
+		tx = types.NewTx(&types.LegacyTx{
+			Nonce:    origin.Nonce(),
+			GasPrice: maxBigInt(bumpReplacementPrice(origin.GasPrice(), bump), networkPrice, minPrice),
+			Gas:      gasLimit,
+			To:       to,
+			Value:    value,
+			Data:     data,
+		})
+	}
+
+	//检查余额是否足够支付转账金额和新的手续费
+	balance, err := wm.GetAddrBalance(from, "latest")
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "get address balance failed, err: %v", err)
+	}
+	if balance.Cmp(tx.Cost()) < 0 {
+		coinBalance := common.BigIntToDecimals(balance, wm.Decimal())
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientFees, "the [%s] balance: %s is not enough to replace transaction", wm.Symbol(), coinBalance)
+	}
+
+	wm.Log.Debugf("replace transaction nonce %d, gasPrice: %s -> %s", origin.Nonce(), origin.GasFeeCap().String(), tx.GasFeeCap().String())
+	return tx, nil
+}
+
+// isReplacementRawTransaction 交易单是否为替换交易，返回原交易hash
+func isReplacementRawTransaction(rawTx *openwallet.RawTransaction) (string, bool) {
+	originTxID := rawTx.GetExtParam().Get(replaceTxIDExtParamKey).String()
+	return originTxID, len(originTxID) > 0
+}
+
+// isCancelRawTransaction 交易单是否为取消交易
+func isCancelRawTransaction(rawTx *openwallet.RawTransaction) bool {
+	return rawTx.GetExtParam().Get(cancelExtParamKey).Bool()
+}
+
+// extractReplacement 扫描到交易组中的交易上链，在提取的交易单中标记被替换的交易
+func (bs *BlockScanner) extractReplacement(tx *BlockTransaction, result *ExtractResult) {
+
+	record := bs.wm.TxReplacements.Confirm(tx.Hash)
+	if record == nil {
+		return
+	}
+	replaced := record.Replaced(tx.Hash)
+	if len(replaced) == 0 {
+		return
+	}
+	cancelled := record.Cancelled()
+	bs.wm.Log.Infof("transaction %s mined, cancelled: %v, replaced transactions: %v", tx.Hash, cancelled, replaced)
+
+	for _, extractDataArray := range result.extractData {
+		for _, data := range extractDataArray {
+			if data.Transaction == nil {
+				continue
+			}
+			extParam := make(map[string]interface{})
+			if len(data.Transaction.ExtParam) > 0 {
+				json.Unmarshal([]byte(data.Transaction.ExtParam), &extParam)
+			}
+			extParam[replacedTxIDsExtParamKey] = replaced
+			if cancelled {
+				extParam[cancelledExtParamKey] = true
+			}
+			extContent, _ := json.Marshal(extParam)
+			data.Transaction.ExtParam = string(extContent)
+		}
+	}
+}
+
+// bumpReplacementPrice 按百分比提高价格，向上取整并至少加1
+func bumpReplacementPrice(price *big.Int, percent int64) *big.Int {
+	bumped := new(big.Int).Mul(price, big.NewInt(100+percent))
+	bumped.Add(bumped, big.NewInt(99))
+	bumped.Div(bumped, big.NewInt(100))
+	if bumped.Cmp(price) <= 0 {
+		bumped.Add(price, big.NewInt(1))
+	}
+	return bumped
+}
+
+func maxBigInt(values ...*big.Int) *big.Int {
+	max := new(big.Int)
+	for _, v := range values {
+		if v != nil && v.Cmp(max) > 0 {
+			max.Set(v)
+		}
+	}
+	return max
+}
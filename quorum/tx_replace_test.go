@@ -0,0 +1,189 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestTxReplacementStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "replacement")
+	store, err := NewTxReplacementStore(dir)
+	if err != nil {
+		t.Errorf("NewTxReplacementStore error: %v", err)
+		return
+	}
+
+	from := "0x8AcCeF1f1a4B4E07cC76b4CB5C8f4e4CE2EFb4B1"
+	origin := "0x1111111111111111111111111111111111111111111111111111111111111111"
+	speedUp := "0x2222222222222222222222222222222222222222222222222222222222222222"
+	store.Track(from, 5, origin)
+	store.Track(from, 5, origin, speedUp)
+
+	//文件原子写入，不留下临时文件
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("replacement files: %d, expected: 1", len(files))
+	}
+
+	//新实例从文件加载
+	reloaded, _ := NewTxReplacementStore(dir)
+	record := reloaded.GetByTxID(speedUp)
+	if record == nil || record.Nonce != 5 || len(record.TxIDs) != 2 || record.TxIDs[0] != origin {
+		t.Errorf("replacement record unexpected: %+v", record)
+		return
+	}
+
+	//原交易上链，替换交易被丢弃
+	confirmed := reloaded.Confirm(origin)
+	if confirmed == nil || confirmed.MinedTxID != origin {
+		t.Errorf("confirm replacement unexpected: %+v", confirmed)
+		return
+	}
+	replaced := confirmed.Replaced(origin)
+	if len(replaced) != 1 || replaced[0] != speedUp {
+		t.Errorf("replaced txids unexpected: %v", replaced)
+		return
+	}
+
+	if reloaded.Confirm("0x3333333333333333333333333333333333333333333333333333333333333333") != nil {
+		t.Errorf("untracked txid should not be confirmed")
+		return
+	}
+
+	reloaded.Remove(from, 5)
+	if reloaded.GetByTxID(origin) != nil || reloaded.Get(from, 5) != nil {
+		t.Errorf("replacement record should be removed")
+		return
+	}
+}
+
+func TestBumpReplacementPrice(t *testing.T) {
+	cases := []struct {
+		price  int64
+		expect int64
+	}{
+		{1000000000, 1100000000},
+		{15, 17}, //16.5向上取整
+		{1, 2},   //至少加1
+		{0, 1},
+	}
+	for _, c := range cases {
+		bumped := bumpReplacementPrice(big.NewInt(c.price), DefaultReplacementPriceBump)
+		if bumped.Int64() != c.expect {
+			t.Errorf("bump %d expect %d, got %s", c.price, c.expect, bumped.String())
+		}
+	}
+}
+
+func TestTxReplacementStore_Cancel(t *testing.T) {
+	store, _ := NewTxReplacementStore("")
+
+	from := "0x8AcCeF1f1a4B4E07cC76b4CB5C8f4e4CE2EFb4B1"
+	origin := "0x1111111111111111111111111111111111111111111111111111111111111111"
+	cancel := "0x4444444444444444444444444444444444444444444444444444444444444444"
+	store.Track(from, 7, origin)
+	store.TrackCancel(from, 7, origin, cancel)
+
+	record := store.GetByTxID(origin)
+	if record.Status(origin) != TxReplacementStatusPending || record.Cancelled() {
+		t.Errorf("cancel record should be pending: %+v", record)
+		return
+	}
+
+	//取消交易上链，原交易标记为已替换
+	record = store.Confirm(cancel)
+	if !record.Cancelled() || record.Status(origin) != TxReplacementStatusReplaced || record.Status(cancel) != TxReplacementStatusMined {
+		t.Errorf("cancel record unexpected: %+v", record)
+		return
+	}
+}
+
+func TestEthTransactionDecoder_SubmitTypedReplacement(t *testing.T) {
+
+	var (
+		mu        sync.Mutex
+		simulated bool
+	)
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch method {
+		case "eth_call":
+			simulated = true
+			return testRPCResult(`"0x"`)
+		case "eth_sendRawTransaction":
+			signed := hexutil.MustDecode(params[0].(string))
+			return testRPCResult(`"` + hexutil.Encode(crypto.Keccak256(signed)) + `"`)
+		default:
+			return ""
+		}
+	})
+	wm.Config.ChainID = 1
+	decoder := NewTransactionDecoder(wm)
+
+	key, _ := crypto.GenerateKey()
+	from := strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).String())
+	to := ethcom.HexToAddress("0x1111111111111111111111111111111111111111")
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     3,
+		GasTipCap: big.NewInt(2000000000),
+		GasFeeCap: big.NewInt(50000000000),
+		Gas:       21000,
+		To:        &to,
+		Value:     big.NewInt(0),
+	})
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	hash := signer.Hash(tx)
+	sig, _ := crypto.Sign(hash[:], key)
+	rawHex, _ := tx.MarshalBinary()
+
+	originTxID := "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b"
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: "ETH"},
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		RawHex:  hex.EncodeToString(rawHex),
+		Signatures: map[string][]*openwallet.KeySignature{"account": {
+			&openwallet.KeySignature{Address: &openwallet.Address{Address: from}, Signature: hex.EncodeToString(sig)},
+		}},
+		ExtParam: fmt.Sprintf(`{"simulate":true,"%s":"%s","%s":true}`, replaceTxIDExtParamKey, originTxID, cancelExtParamKey),
+	}
+
+	//EIP-1559取消交易开启模拟执行时仍可广播
+	if _, err := decoder.SubmitRawTransaction(nil, rawTx); err != nil {
+		t.Errorf("submit typed replacement failed, err: %v", err)
+		return
+	}
+	if !simulated {
+		t.Errorf("typed replacement should be simulated before broadcast")
+	}
+	if group := wm.TxReplacements.Get(from, 3); group == nil || !group.Contains(originTxID) || !group.IsCancel(rawTx.TxID) {
+		t.Errorf("typed replacement should be tracked with the original transaction")
+	}
+}
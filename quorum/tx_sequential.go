@@ -0,0 +1,400 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// 扩展参数：多个接收方拆分为连续nonce的多笔交易
+	sequentialExtParamKey = "sequential"
+	// 扩展参数：连续nonce交易广播后的全部txid
+	txidsExtParamKey = "txids"
+	// 连续nonce交易的多个交易数据以逗号分隔保存在RawHex
+	sequentialRawHexSeparator = ","
+)
+
+// sequentialTx 连续nonce交易中的一笔交易
+type sequentialTx struct {
+	To       ethcom.Address
+	Value    *big.Int
+	Data     []byte
+	GasLimit uint64
+}
+
+// isSequentialRawTransaction 是否连续nonce的多笔交易
+func isSequentialRawTransaction(rawTx *openwallet.RawTransaction) bool {
+	return rawTx.GetExtParam().Get(sequentialExtParamKey).Bool()
+}
+
+// rawTransactionCount 交易单包含的交易数量，每笔交易对应一个签名
+func rawTransactionCount(rawTx *openwallet.RawTransaction) int {
+	if !isSequentialRawTransaction(rawTx) {
+		return 1
+	}
+	return len(strings.Split(rawTx.RawHex, sequentialRawHexSeparator))
+}
+
+// CreateSequentialRawTransaction 没有disperse合约时，多个接收方拆分为同一地址连续nonce的多笔交易，
+// 转账总额和全部手续费检查通过后才预留nonce，每笔交易单独生成待签名消息，签名顺序与nonce顺序一致
+func (decoder *EthTransactionDecoder) CreateSequentialRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	isToken := rawTx.Coin.IsContract
+	decimals := decoder.wm.Decimal()
+	if isToken {
+		decimals = int32(rawTx.Coin.Contract.Decimals)
+	}
+
+	recipients, total, err := decoder.parseDisperseRecipients(rawTx.To, decimals)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, err.Error())
+	}
+
+	candidates, findErr := decoder.findBatchAddresses(wrapper, rawTx, total, decimals)
+	if findErr != nil {
+		return findErr
+	}
+
+	var (
+		findAddress string
+		txs         []*sequentialTx
+		totalFee    *big.Int
+		gasPrice    *big.Int
+		lastErr     *openwallet.Error
+	)
+
+	for _, address := range candidates {
+
+		estimated, fee, price, estimateErr := decoder.estimateSequentialTransactions(address, rawTx, recipients)
+		if estimateErr != nil {
+			lastErr = estimateErr
+			continue
+		}
+
+		//总消耗数量 = 转账总额 + 全部交易的手续费
+		coinBalance, balanceErr := decoder.wm.GetAddrBalance(address, "pending")
+		if balanceErr != nil {
+			lastErr = openwallet.NewError(openwallet.ErrCallFullNodeAPIFailed, balanceErr.Error())
+			continue
+		}
+		totalCost := new(big.Int).Set(fee)
+		if !isToken {
+			totalCost.Add(totalCost, total)
+		}
+		if coinBalance.Cmp(totalCost) < 0 {
+			coinBalance := common.BigIntToDecimals(coinBalance, decoder.wm.Decimal())
+			lastErr = openwallet.Errorf(openwallet.ErrInsufficientFees, "the [%s] balance: %s is not enough", decoder.wm.Symbol(), coinBalance.String())
+			continue
+		}
+
+		//只要找到一个合适使用的地址余额就停止遍历
+		findAddress = address
+		txs = estimated
+		totalFee = fee
+		gasPrice = price
+		break
+	}
+
+	if len(findAddress) == 0 {
+		return lastErr
+	}
+	addr, err := wrapper.GetAddress(findAddress)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrAccountNotAddress, err.Error())
+	}
+
+	//使用外部传入的扩展字段作为第一个nonce，否则原子地预留连续的nonce
+	start, reserved, err := decoder.wm.selectTxNonce(addr.Address, rawTx.GetExtParam(), len(txs))
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrNonceInvaild, "reserve address nonce failed, err: %v", err)
+	}
+
+	signer := types.NewEIP155Signer(big.NewInt(int64(decoder.wm.Config.ChainID)))
+	rawHexes := make([]string, 0, len(txs))
+	keySignatures := make([]*openwallet.KeySignature, 0, len(txs))
+	for i, t := range txs {
+		nonce := start + uint64(i)
+		tx := types.NewTransaction(nonce, t.To, t.Value, t.GasLimit, gasPrice, t.Data)
+		rawHex, encodeErr := rlp.EncodeToBytes(tx)
+		if encodeErr != nil {
+			decoder.wm.Log.Error("Transaction RLP encode failed, err:", encodeErr)
+			decoder.wm.releaseTxNonce(addr.Address, start, len(txs), reserved)
+			return openwallet.ConvertError(encodeErr)
+		}
+		msg := signer.Hash(tx)
+		keySignatures = append(keySignatures, &openwallet.KeySignature{
+			EccType: decoder.wm.Config.CurveType,
+			Nonce:   "0x" + strconv.FormatUint(nonce, 16),
+			Address: addr,
+			Message: hex.EncodeToString(msg[:]),
+			RSV:     true,
+		})
+		rawHexes = append(rawHexes, hex.EncodeToString(rawHex))
+	}
+
+	decoder.fillBatchRawTransaction(wrapper, rawTx, findAddress, recipients, total, totalFee, gasPrice, decimals)
+
+	extParam := make(map[string]interface{})
+	if len(rawTx.ExtParam) > 0 {
+		json.Unmarshal([]byte(rawTx.ExtParam), &extParam)
+	}
+	extParam[sequentialExtParamKey] = true
+	extContent, _ := json.Marshal(extParam)
+	rawTx.ExtParam = string(extContent)
+
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	}
+	rawTx.Signatures[rawTx.Account.AccountID] = keySignatures
+	rawTx.RawHex = strings.Join(rawHexes, sequentialRawHexSeparator)
+	rawTx.IsBuilt = true
+
+	return nil
+}
+
+// estimateSequentialTransactions 估算每个接收方一笔交易的gas，全部交易使用同一gas price，返回手续费合计
+func (decoder *EthTransactionDecoder) estimateSequentialTransactions(from string, rawTx *openwallet.RawTransaction, recipients []*disperseRecipient) ([]*sequentialTx, *big.Int, *big.Int, *openwallet.Error) {
+
+	var gasPrice *big.Int
+	if rawTx.FeeRate != "" {
+		gasPrice = common.StringNumToBigIntWithExp(rawTx.FeeRate, decoder.wm.Decimal())
+	}
+
+	txs := make([]*sequentialTx, 0, len(recipients))
+	totalFee := big.NewInt(0)
+	for _, r := range recipients {
+		var (
+			to    string
+			value *big.Int
+			data  []byte
+		)
+		if rawTx.Coin.IsContract {
+			encoded, encodeErr := decoder.wm.EncodeABIParam(ERC20_ABI, "transfer", decoder.wm.CustomAddressDecodeFunc(r.Address), r.Amount.String())
+			if encodeErr != nil {
+				return nil, nil, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, encodeErr.Error())
+			}
+			to = rawTx.Coin.Contract.Address
+			value = big.NewInt(0)
+			data = encoded
+		} else {
+			to = r.Address
+			value = r.Amount
+		}
+
+		fee, feeErr := decoder.wm.GetTransactionFeeEstimated(from, to, value, data)
+		if feeErr != nil {
+			return nil, nil, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, feeErr.Error())
+		}
+		if gasPrice == nil {
+			gasPrice = fee.GasPrice
+		}
+		fee.GasPrice = gasPrice
+		fee.CalcFee()
+		totalFee.Add(totalFee, fee.Fee)
+
+		txs = append(txs, &sequentialTx{
+			To:       ethcom.HexToAddress(decoder.wm.CustomAddressDecodeFunc(to)),
+			Value:    value,
+			Data:     data,
+			GasLimit: fee.GasLimit.Uint64(),
+		})
+	}
+	return txs, totalFee, gasPrice, nil
+}
+
+// submitSequentialRawTransaction 按nonce顺序逐笔广播，全部模拟执行通过后才广播，
+// 中途广播失败时释放后续交易的nonce，已广播的txid记录在扩展参数txids
+func (decoder *EthTransactionDecoder) submitSequentialRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*openwallet.Transaction, error) {
+
+	keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
+	rawHexes := strings.Split(rawTx.RawHex, sequentialRawHexSeparator)
+	if len(rawHexes) != len(keySignatures) {
+		return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "len of signatures error. ")
+	}
+
+	from := keySignatures[0].Address.Address
+	signer := types.LatestSignerForChainID(big.NewInt(int64(decoder.wm.Config.ChainID)))
+	txs := make([]*types.Transaction, 0, len(rawHexes))
+	for i, rawHex := range rawHexes {
+		txBytes, err := hex.DecodeString(rawHex)
+		if err != nil {
+			decoder.wm.Log.Error("rawTx.RawHex decode failed, err:", err)
+			return nil, err
+		}
+		tx := &types.Transaction{}
+		if err = tx.UnmarshalBinary(txBytes); err != nil {
+			decoder.wm.Log.Error("transaction RLP decode failed, err:", err)
+			return nil, err
+		}
+		tx, err = tx.WithSignature(signer, ethcom.FromHex(keySignatures[i].Signature))
+		if err != nil {
+			decoder.wm.Log.Std.Error("tx with signature failed, err=%v ", err)
+			return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "tx with signature failed. ")
+		}
+		txs = append(txs, tx)
+	}
+
+	//广播前模拟执行，任意一笔失败都不广播
+	if decoder.wm.needSimulate(rawTx.GetExtParam()) {
+		for _, tx := range txs {
+			if err := decoder.wm.SimulateTransaction(tx); err != nil {
+				decoder.wm.Log.Std.Error("simulate tx failed, err=%v", err)
+				return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "simulate tx failed. %v", err)
+			}
+		}
+	}
+
+	txids := make([]string, 0, len(txs))
+	for i, tx := range txs {
+		rawTxPara, err := tx.MarshalBinary()
+		if err == nil {
+			var txid string
+			txid, err = decoder.wm.SendRawTransaction(hexutil.Encode(rawTxPara))
+			if err == nil {
+				//交易成功，记录nonce已使用
+				decoder.wm.NonceManager.Commit(from, tx.Nonce(), txid)
+				decoder.wm.journalTransaction(from, tx, txid)
+				txids = append(txids, txid)
+				continue
+			}
+		}
+
+		decoder.wm.Log.Std.Error("sent raw tx faild, err=%v", err)
+		//后续交易的nonce不再连续，全部释放
+		for _, rest := range txs[i:] {
+			decoder.wm.NonceManager.Release(from, rest.Nonce())
+		}
+		if len(txids) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "sent raw tx faild. unexpected error: %v", err)
+		}
+		//已广播的交易无法撤回，返回已广播部分的交易单，调用方只能对未广播的接收方重新发起转账
+		decoder.setSequentialTxIDs(rawTx, txids)
+		rawTx.IsSubmit = true
+		owtx := decoder.newSequentialTransaction(wrapper, rawTx, from, txs[:len(txids)], txids)
+		return owtx, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "sent raw tx %d of %d faild, submitted txids: %s, unsubmitted outputs: %s, unexpected error: %v",
+			i+1, len(txs), strings.Join(txids, ","), strings.Join(rawTx.TxTo[len(txids):], ","), err)
+	}
+
+	//最后一笔交易上链时前面的交易都已上链
+	decoder.setSequentialTxIDs(rawTx, txids)
+	rawTx.IsSubmit = true
+	owtx := decoder.newSequentialTransaction(wrapper, rawTx, from, txs, txids)
+
+	//扩展参数awaitResult为true时，等待全部交易达到确认数
+	if extParam := rawTx.GetExtParam(); extParam.Get("awaitResult").Bool() {
+		states, awaitErr := decoder.wm.awaitTransactions(extParam.Get("awaitTimeout").Uint(), txids...)
+		if awaitErr != nil {
+			decoder.wm.Log.Errorf("await transactions %s failed, err: %v", strings.Join(txids, ","), awaitErr)
+			owtx.Status = TxStatusPending
+			owtx.Reason = fmt.Sprintf("await transactions result failed: %v", awaitErr)
+			return owtx, nil
+		}
+		last := states[strings.ToLower(AppendOxToAddress(rawTx.TxID))]
+		owtx.BlockHash = last.BlockHash
+		owtx.BlockHeight = last.BlockHeight
+		owtx.Confirm = int64(last.Confirmations)
+		owtx.ConfirmTime = time.Now().Unix()
+		owtx.Status = TxStatusSuccess
+		for _, state := range states {
+			if !state.Success {
+				owtx.Status = TxStatusFail
+				break
+			}
+		}
+	}
+
+	return owtx, nil
+}
+
+// newSequentialTransaction 已广播交易的交易单记录，txs与rawTx.TxTo按接收方顺序一一对应，
+// 部分广播时只统计已广播的接收方和手续费
+func (decoder *EthTransactionDecoder) newSequentialTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, from string, txs []*types.Transaction, txids []string) *openwallet.Transaction {
+
+	decimals := decoder.wm.Decimal()
+	if rawTx.Coin.IsContract {
+		decimals = int32(rawTx.Coin.Contract.Decimals)
+	}
+
+	txTo := rawTx.TxTo[:len(txs)]
+	totalSent := decimal.Zero
+	accountTotalSent := decimal.Zero
+	for _, output := range txTo {
+		index := strings.LastIndex(output, ":")
+		address, amountStr := output[:index], output[index+1:]
+		amountDec, _ := decimal.NewFromString(amountStr)
+		totalSent = totalSent.Add(amountDec)
+		accountAddresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", address)
+		if findErr != nil || len(accountAddresses) == 0 {
+			accountTotalSent = accountTotalSent.Add(amountDec)
+		}
+	}
+
+	//每笔交易的手续费按gas limit和gas price计算，与创建交易单时一致
+	fee := big.NewInt(0)
+	for _, tx := range txs {
+		fee.Add(fee, new(big.Int).Mul(tx.GasPrice(), new(big.Int).SetUint64(tx.Gas())))
+	}
+	fees := common.BigIntToDecimals(fee, decoder.wm.Decimal())
+	if rawTx.Coin.IsContract {
+		fees = decimal.Zero
+	} else {
+		accountTotalSent = accountTotalSent.Add(fees)
+	}
+
+	//记录一个交易单
+	owtx := &openwallet.Transaction{
+		From:       []string{fmt.Sprintf("%s:%s", from, totalSent.String())},
+		To:         txTo,
+		Amount:     decimal.Zero.Sub(accountTotalSent).String(),
+		Coin:       rawTx.Coin,
+		TxID:       txids[len(txids)-1],
+		Decimal:    decimals,
+		AccountID:  rawTx.Account.AccountID,
+		Fees:       fees.String(),
+		SubmitTime: time.Now().Unix(),
+		TxType:     0,
+	}
+
+	owtx.WxID = openwallet.GenTransactionWxID(owtx)
+	return owtx
+}
+
+// setSequentialTxIDs 记录已广播的txid，交易单txid为最后一笔交易
+func (decoder *EthTransactionDecoder) setSequentialTxIDs(rawTx *openwallet.RawTransaction, txids []string) {
+	extParam := make(map[string]interface{})
+	if len(rawTx.ExtParam) > 0 {
+		json.Unmarshal([]byte(rawTx.ExtParam), &extParam)
+	}
+	extParam[txidsExtParamKey] = txids
+	extContent, _ := json.Marshal(extParam)
+	rawTx.ExtParam = string(extContent)
+	rawTx.TxID = txids[len(txids)-1]
+}
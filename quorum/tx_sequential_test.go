@@ -0,0 +1,161 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestNonceManager_ReserveSequence(t *testing.T) {
+
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		return testRPCResult(`"0x5"`)
+	})
+	address := "0xd35c2cd2d6e6e1b8a4c4e3a9f8b2e5bd1a4e5c37"
+
+	//预留5和6，释放5后留下缺口
+	first, _ := wm.NonceManager.Reserve(address)
+	wm.NonceManager.Reserve(address)
+	wm.NonceManager.Release(address, first)
+
+	//缺口不足以容纳3个连续nonce，从7开始
+	start, err := wm.NonceManager.ReserveSequence(address, 3)
+	if err != nil {
+		t.Errorf("ReserveSequence failed, err: %v", err)
+		return
+	}
+	if start != 7 {
+		t.Errorf("sequence start: %d, expected: 7", start)
+	}
+
+	//缺口仍可被单笔交易复用
+	if nonce, _ := wm.NonceManager.Reserve(address); nonce != 5 {
+		t.Errorf("released nonce should be reused, got: %d", nonce)
+	}
+	if nonce, _ := wm.NonceManager.Reserve(address); nonce != 10 {
+		t.Errorf("next nonce: %d, expected: 10", nonce)
+	}
+
+	if _, err = wm.NonceManager.ReserveSequence(address, 0); err == nil {
+		t.Errorf("ReserveSequence should fail with zero count")
+	}
+}
+
+func TestRawTransactionCount(t *testing.T) {
+	rawTx := &openwallet.RawTransaction{RawHex: "e501"}
+	if count := rawTransactionCount(rawTx); count != 1 {
+		t.Errorf("raw transaction count: %d, expected: 1", count)
+	}
+	rawTx = &openwallet.RawTransaction{RawHex: "e501,e502,e503", ExtParam: `{"sequential":true}`}
+	if count := rawTransactionCount(rawTx); count != 3 {
+		t.Errorf("raw transaction count: %d, expected: 3", count)
+	}
+}
+
+// testSequentialWallet 接收方都不属于账户
+type testSequentialWallet struct {
+	openwallet.WalletDAI
+}
+
+func (w *testSequentialWallet) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+	return nil, nil
+}
+
+func TestEthTransactionDecoder_submitSequentialRawTransaction_Partial(t *testing.T) {
+
+	var (
+		mu    sync.Mutex
+		sends int
+	)
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch method {
+		case "eth_sendRawTransaction":
+			sends++
+			if sends > 1 {
+				return testRPCError(-32000, "insufficient funds")
+			}
+			signed := hexutil.MustDecode(params[0].(string))
+			return testRPCResult(`"` + hexutil.Encode(crypto.Keccak256(signed)) + `"`)
+		default:
+			return testRPCResult(`"0x"`)
+		}
+	})
+	wm.Config.ChainID = 1
+	decoder := NewTransactionDecoder(wm)
+
+	key, _ := crypto.GenerateKey()
+	from := strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).String())
+	recipients := []string{"0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222"}
+	signer := types.NewEIP155Signer(big.NewInt(1))
+
+	rawHexes := make([]string, 0)
+	keySignatures := make([]*openwallet.KeySignature, 0)
+	txTo := make([]string, 0)
+	for i, to := range recipients {
+		tx := types.NewTransaction(uint64(i), ethcom.HexToAddress(to), big.NewInt(1), 21000, big.NewInt(1000000000), nil)
+		rawHex, _ := rlp.EncodeToBytes(tx)
+		hash := signer.Hash(tx)
+		sig, _ := crypto.Sign(hash[:], key)
+		rawHexes = append(rawHexes, hex.EncodeToString(rawHex))
+		keySignatures = append(keySignatures, &openwallet.KeySignature{
+			Address:   &openwallet.Address{Address: from},
+			Nonce:     fmt.Sprintf("0x%x", i),
+			Signature: hex.EncodeToString(sig),
+		})
+		txTo = append(txTo, to+":0.000000000000000001")
+	}
+	rawTx := &openwallet.RawTransaction{
+		Coin:       openwallet.Coin{Symbol: "ETH"},
+		Account:    &openwallet.AssetsAccount{AccountID: "account"},
+		RawHex:     strings.Join(rawHexes, sequentialRawHexSeparator),
+		Signatures: map[string][]*openwallet.KeySignature{"account": keySignatures},
+		TxTo:       txTo,
+		ExtParam:   `{"sequential":true}`,
+	}
+
+	//第二笔广播失败，返回第一笔已广播交易的交易单和错误
+	owtx, err := decoder.SubmitRawTransaction(&testSequentialWallet{}, rawTx)
+	if err == nil {
+		t.Errorf("partial broadcast should return error")
+	}
+	if owtx == nil {
+		t.Errorf("partial broadcast should return the submitted transaction")
+		return
+	}
+	if len(owtx.To) != 1 || owtx.To[0] != txTo[0] {
+		t.Errorf("submitted outputs: %v, expected: %v", owtx.To, txTo[:1])
+	}
+	if owtx.Fees != "0.000021" || owtx.Amount != "-0.000021000000000001" {
+		t.Errorf("submitted fees: %s, amount: %s", owtx.Fees, owtx.Amount)
+	}
+	if !rawTx.IsSubmit || rawTx.TxID != owtx.TxID || rawTx.GetExtParam().Get(txidsExtParamKey).Array()[0].String() != owtx.TxID {
+		t.Errorf("raw transaction should record the submitted txid")
+	}
+}
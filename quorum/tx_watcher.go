@@ -0,0 +1,222 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// 默认查询交易回执的间隔
+	DefaultTxWatchPollInterval = 2 * time.Second
+	// 默认等待交易上链的超时时间，秒
+	DefaultAwaitTimeout = 90
+	// 默认交易上链后需要的确认数，打包即返回，不检测区块重组，检测重组需要设置为2以上
+	DefaultAwaitConfirmations = 1
+)
+
+const (
+	// 交易单执行结果，与扫块提取的回执状态一致
+	TxStatusFail    = "0"
+	TxStatusSuccess = "1"
+	// 已广播，等待结果超时或失败，执行结果未知，需要调用方继续查询
+	TxStatusPending = "pending"
+)
+
+const (
+	TxWatchStatusPending   = "pending"   //未查到回执
+	TxWatchStatusMined     = "mined"     //已打包，确认数不足
+	TxWatchStatusConfirmed = "confirmed" //确认数已满足
+	TxWatchStatusRemoved   = "removed"   //回执因区块重组被移除，重新等待打包
+)
+
+// TxWatchEvent 交易状态变化
+type TxWatchEvent struct {
+	TxID          string
+	Status        string
+	BlockHash     string
+	BlockHeight   uint64
+	Confirmations uint64
+	Success       bool //回执执行结果
+	Receipt       *TransactionReceipt
+}
+
+// TxWatchOptions 交易监听参数
+type TxWatchOptions struct {
+	Confirmations uint64                    //需要的确认数，0和1都表示打包即确认
+	PollInterval  time.Duration             //查询间隔，0使用默认值
+	Timeout       time.Duration             //超时时间，0只受ctx控制
+	OnEvent       func(event *TxWatchEvent) //状态变化回调
+	Events        chan<- *TxWatchEvent      //状态变化通道，调用方负责读取
+}
+
+// WatchTransactions 等待交易回执和确认数，状态变化通过回调或通道通知。
+// 所有交易确认后返回各交易最后的状态，超时或ctx取消时返回当前状态和ctx的错误。
+// 交易确认后不再查询，区块重组只在确认前检测，确认数为1时打包即返回，不检测重组；
+// 非即时最终性的链需要检测重组时，确认数应设置为2以上
+func (wm *WalletManager) WatchTransactions(ctx context.Context, opts *TxWatchOptions, txids ...string) (map[string]*TxWatchEvent, error) {
+
+	if opts == nil {
+		opts = &TxWatchOptions{}
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = DefaultTxWatchPollInterval
+	}
+	required := opts.Confirmations
+	if required == 0 {
+		required = 1
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	states := make(map[string]*TxWatchEvent, len(txids))
+	for _, txid := range txids {
+		txid = strings.ToLower(AppendOxToAddress(txid))
+		states[txid] = &TxWatchEvent{TxID: txid, Status: TxWatchStatusPending}
+	}
+
+	emit := func(event *TxWatchEvent) {
+		copied := *event
+		if opts.OnEvent != nil {
+			opts.OnEvent(&copied)
+		}
+		if opts.Events != nil {
+			select {
+			case opts.Events <- &copied:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	for {
+		latest, err := wm.GetBlockNumber()
+		if err == nil {
+			for _, state := range states {
+				if state.Status == TxWatchStatusConfirmed {
+					continue
+				}
+				wm.pollTransactionReceipt(state, latest, required, emit)
+			}
+		}
+
+		confirmed := true
+		for _, state := range states {
+			if state.Status != TxWatchStatusConfirmed {
+				confirmed = false
+				break
+			}
+		}
+		if confirmed {
+			return states, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return states, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// pollTransactionReceipt 查询回执并更新交易状态，已打包的交易回执消失或区块变化视为重组
+func (wm *WalletManager) pollTransactionReceipt(state *TxWatchEvent, latest, required uint64, emit func(event *TxWatchEvent)) {
+
+	result, err := wm.WalletClient.Call("eth_getTransactionReceipt", []interface{}{state.TxID})
+	if err != nil {
+		return
+	}
+
+	mined := state.Status == TxWatchStatusMined
+	if !result.IsObject() {
+		if mined {
+			wm.Log.Warningf("transaction %s receipt in block %s is removed by reorg", state.TxID, state.BlockHash)
+			*state = TxWatchEvent{TxID: state.TxID, Status: TxWatchStatusRemoved}
+			emit(state)
+		}
+		return
+	}
+
+	var ethReceipt types.Receipt
+	if err = ethReceipt.UnmarshalJSON([]byte(result.Raw)); err != nil {
+		return
+	}
+	blockHash := ethReceipt.BlockHash.String()
+	if mined && blockHash != state.BlockHash {
+		wm.Log.Warningf("transaction %s receipt in block %s is removed by reorg", state.TxID, state.BlockHash)
+		*state = TxWatchEvent{TxID: state.TxID, Status: TxWatchStatusRemoved}
+		emit(state)
+	}
+
+	height := ethReceipt.BlockNumber.Uint64()
+	confirmations := uint64(0)
+	if latest >= height {
+		confirmations = latest - height + 1
+	}
+	status := TxWatchStatusMined
+	if confirmations >= required {
+		status = TxWatchStatusConfirmed
+	}
+
+	state.BlockHash = blockHash
+	state.BlockHeight = height
+	state.Confirmations = confirmations
+	state.Success = ethReceipt.Status == types.ReceiptStatusSuccessful
+	state.Receipt = &TransactionReceipt{ETHReceipt: &ethReceipt, Raw: result.Raw}
+	if state.Status != status {
+		state.Status = status
+		emit(state)
+	}
+}
+
+// txStatus 回执执行结果转为交易单状态
+func txStatus(success bool) string {
+	if success {
+		return TxStatusSuccess
+	}
+	return TxStatusFail
+}
+
+// awaitTransaction 等待单笔交易达到配置的确认数
+func (wm *WalletManager) awaitTransaction(txid string, timeout uint64) (*TxWatchEvent, error) {
+	states, err := wm.awaitTransactions(timeout, txid)
+	if err != nil {
+		return nil, err
+	}
+	return states[strings.ToLower(AppendOxToAddress(txid))], nil
+}
+
+// awaitTransactions 等待多笔交易都达到配置的确认数
+func (wm *WalletManager) awaitTransactions(timeout uint64, txids ...string) (map[string]*TxWatchEvent, error) {
+	if timeout == 0 {
+		timeout = DefaultAwaitTimeout
+	}
+	opts := &TxWatchOptions{
+		Confirmations: wm.Config.AwaitConfirmations,
+		Timeout:       time.Duration(timeout) * time.Second,
+		OnEvent: func(event *TxWatchEvent) {
+			wm.Log.Infof("transaction %s status: %s, block height: %d, confirmations: %d", event.TxID, event.Status, event.BlockHeight, event.Confirmations)
+		},
+	}
+	return wm.WatchTransactions(context.Background(), opts, txids...)
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestWalletManager_WatchTransactions(t *testing.T) {
+
+	txid := "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b"
+	receipt := func(blockHash string, blockNumber uint64) string {
+		return fmt.Sprintf(`{"transactionHash":"%s","transactionIndex":"0x0","blockHash":"%s","blockNumber":"0x%x","cumulativeGasUsed":"0x5208","gasUsed":"0x5208","logs":[],"logsBloom":"0x%0512x","status":"0x1","type":"0x0"}`, txid, blockHash, blockNumber, 0)
+	}
+	blockA := "0x000000000000000000000000000000000000000000000000000000000000000a"
+	blockB := "0x000000000000000000000000000000000000000000000000000000000000000b"
+
+	//依次返回: 未打包, 打包在A, 重组移除, 打包在B, B之后出2个块
+	var (
+		mu    sync.Mutex
+		round int
+	)
+	steps := []struct {
+		latest  uint64
+		receipt string
+	}{
+		{10, "null"},
+		{11, receipt(blockA, 11)},
+		{12, "null"},
+		{12, receipt(blockB, 12)},
+		{14, receipt(blockB, 12)},
+	}
+
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		mu.Lock()
+		defer mu.Unlock()
+		step := steps[round]
+		switch method {
+		case "eth_blockNumber":
+			return testRPCResult(fmt.Sprintf(`"0x%x"`, step.latest))
+		case "eth_getTransactionReceipt":
+			if round < len(steps)-1 {
+				round++
+			}
+			return testRPCResult(step.receipt)
+		}
+		return ""
+	})
+
+	statuses := make([]string, 0)
+	opts := &TxWatchOptions{
+		Confirmations: 3,
+		PollInterval:  10 * time.Millisecond,
+		OnEvent: func(event *TxWatchEvent) {
+			statuses = append(statuses, event.Status)
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	states, err := wm.WatchTransactions(ctx, opts, txid)
+	if err != nil {
+		t.Errorf("WatchTransactions failed, err: %v", err)
+		return
+	}
+
+	expected := []string{TxWatchStatusMined, TxWatchStatusRemoved, TxWatchStatusMined, TxWatchStatusConfirmed}
+	if fmt.Sprint(statuses) != fmt.Sprint(expected) {
+		t.Errorf("statuses: %v, expected: %v", statuses, expected)
+		return
+	}
+	state := states[txid]
+	if state.BlockHash != blockB || state.Confirmations != 3 || !state.Success {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
+
+func TestEthTransactionDecoder_SubmitRawTransaction_AwaitTimeout(t *testing.T) {
+
+	wm := newTestRPCWalletManager(t, func(method string, params []interface{}) string {
+		switch method {
+		case "eth_sendRawTransaction":
+			signed := hexutil.MustDecode(params[0].(string))
+			return testRPCResult(`"` + hexutil.Encode(crypto.Keccak256(signed)) + `"`)
+		case "eth_blockNumber":
+			return testRPCResult(`"0xa"`)
+		default:
+			//回执一直查不到
+			return ""
+		}
+	})
+	wm.Config.ChainID = 1
+	decoder := NewTransactionDecoder(wm)
+
+	key, _ := crypto.GenerateKey()
+	from := strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).String())
+	to := ethcom.HexToAddress("0x1111111111111111111111111111111111111111")
+	tx := types.NewTransaction(0, to, big.NewInt(0), 21000, big.NewInt(1000000000), nil)
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	hash := signer.Hash(tx)
+	sig, _ := crypto.Sign(hash[:], key)
+	rawHex, _ := tx.MarshalBinary()
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: "ETH"},
+		Account: &openwallet.AssetsAccount{AccountID: "account"},
+		RawHex:  hex.EncodeToString(rawHex),
+		Signatures: map[string][]*openwallet.KeySignature{"account": {
+			&openwallet.KeySignature{Address: &openwallet.Address{Address: from}, Signature: hex.EncodeToString(sig)},
+		}},
+		ExtParam: `{"awaitResult":true,"awaitTimeout":1}`,
+	}
+
+	//等待超时返回已广播的交易，状态为待确认
+	owtx, err := decoder.SubmitRawTransaction(nil, rawTx)
+	if err != nil {
+		t.Errorf("submit transaction failed, err: %v", err)
+		return
+	}
+	if owtx.Status != TxStatusPending || len(owtx.Reason) == 0 {
+		t.Errorf("await timeout should return pending status, got: %s, reason: %s", owtx.Status, owtx.Reason)
+	}
+}
@@ -0,0 +1,126 @@
+package quorum_addrdec
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	// EIP-1167最小代理合约的部署代码，中间拼接20字节的逻辑合约地址
+	minimalProxyInitCodePrefix = "3d602d80600a3d3981f3363d3d373d3d3d363d73"
+	minimalProxyInitCodeSuffix = "5af43d82803e903d91602b57fd5bf3"
+)
+
+// keccak256 计算keccak256摘要
+func keccak256(data ...[]byte) []byte {
+	buf := make([]byte, 0)
+	for _, d := range data {
+		buf = append(buf, d...)
+	}
+	return owcrypt.Hash(buf, 0, owcrypt.HASH_ALG_KECCAK256)
+}
+
+// decodeHex 解析hex字符串，0x前缀可选
+func decodeHex(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	return hex.DecodeString(s)
+}
+
+// decodeContractAddress 解析20字节地址
+func decodeContractAddress(address string) ([]byte, error) {
+	addr, err := decodeHex(address)
+	if err != nil {
+		return nil, fmt.Errorf("address %s is invalid, err: %v", address, err)
+	}
+	if len(addr) != 20 {
+		return nil, fmt.Errorf("address %s length is not 20 bytes", address)
+	}
+	return addr, nil
+}
+
+// decodeBytes32 解析32字节参数，不足32字节左侧补0
+func decodeBytes32(name, value string) ([]byte, error) {
+	b, err := decodeHex(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s is invalid, err: %v", name, value, err)
+	}
+	if len(b) > 32 {
+		return nil, fmt.Errorf("%s %s is longer than 32 bytes", name, value)
+	}
+	padded := make([]byte, 32)
+	copy(padded[32-len(b):], b)
+	return padded, nil
+}
+
+// CreateAddress 计算sender以nonce通过CREATE部署的合约地址，keccak256(rlp([sender, nonce]))[12:]
+func CreateAddress(sender string, nonce uint64) (string, error) {
+	addr, err := decodeContractAddress(sender)
+	if err != nil {
+		return "", err
+	}
+	data, err := rlp.EncodeToBytes([]interface{}{addr, nonce})
+	if err != nil {
+		return "", err
+	}
+	return "0x" + hex.EncodeToString(keccak256(data)[12:]), nil
+}
+
+// Create2Address 计算deployer通过CREATE2部署的合约地址，keccak256(0xff ++ deployer ++ salt ++ initCodeHash)[12:]
+// salt不足32字节左侧补0，initCodeHash为部署代码的keccak256
+func Create2Address(deployer, salt, initCodeHash string) (string, error) {
+	addr, err := decodeContractAddress(deployer)
+	if err != nil {
+		return "", err
+	}
+	saltBytes, err := decodeBytes32("salt", salt)
+	if err != nil {
+		return "", err
+	}
+	hash, err := decodeHex(initCodeHash)
+	if err != nil || len(hash) != 32 {
+		return "", fmt.Errorf("init code hash %s is invalid", initCodeHash)
+	}
+	return "0x" + hex.EncodeToString(keccak256([]byte{0xff}, addr, saltBytes, hash)[12:]), nil
+}
+
+// Create2AddressWithInitCode 通过部署代码计算CREATE2地址
+func Create2AddressWithInitCode(deployer, salt string, initCode []byte) (string, error) {
+	return Create2Address(deployer, salt, InitCodeHash(initCode))
+}
+
+// InitCodeHash 计算部署代码的keccak256，带0x前缀
+func InitCodeHash(initCode []byte) string {
+	return "0x" + hex.EncodeToString(keccak256(initCode))
+}
+
+// MinimalProxyInitCode EIP-1167最小代理合约的部署代码，代理所有调用到implementation
+func MinimalProxyInitCode(implementation string) ([]byte, error) {
+	impl, err := decodeContractAddress(implementation)
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := hex.DecodeString(minimalProxyInitCodePrefix)
+	suffix, _ := hex.DecodeString(minimalProxyInitCodeSuffix)
+	initCode := make([]byte, 0, len(prefix)+len(impl)+len(suffix))
+	initCode = append(initCode, prefix...)
+	initCode = append(initCode, impl...)
+	initCode = append(initCode, suffix...)
+	return initCode, nil
+}
+
+// MinimalProxyAddress 计算工厂合约以salt通过CREATE2部署的EIP-1167代理合约地址，
+// 与OpenZeppelin Clones.predictDeterministicAddress一致
+func MinimalProxyAddress(factory, implementation, salt string) (string, error) {
+	initCode, err := MinimalProxyInitCode(implementation)
+	if err != nil {
+		return "", err
+	}
+	return Create2AddressWithInitCode(factory, salt, initCode)
+}
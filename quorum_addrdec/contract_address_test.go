@@ -0,0 +1,75 @@
+package quorum_addrdec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestCreateAddress(t *testing.T) {
+	sender := "0x6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0"
+	expected := []string{
+		"0xcd234a471b72ba2f1ccf0a70fcaba648a5eecd8d",
+		"0x343c43a37d37dff08ae8c4a11544c718abb4fcf8",
+		"0xf778b86fa74e846c4f0a1fbd1335fe81c00a0c91",
+	}
+	for nonce, address := range expected {
+		addr, err := CreateAddress(sender, uint64(nonce))
+		if err != nil || addr != address {
+			t.Errorf("nonce %d address: %s, expected: %s, err: %v", nonce, addr, address, err)
+		}
+	}
+	//大nonce与go-ethereum结果一致
+	addr, _ := CreateAddress(sender, 1<<40)
+	if expect := strings.ToLower(crypto.CreateAddress(common.HexToAddress(sender), 1<<40).String()); addr != expect {
+		t.Errorf("address: %s, expected: %s", addr, expect)
+	}
+}
+
+func TestCreate2Address(t *testing.T) {
+	//EIP-1014示例
+	tests := []struct {
+		deployer string
+		salt     string
+		initCode string
+		address  string
+	}{
+		{"0x0000000000000000000000000000000000000000", "0x00", "0x00", "0x4d1a2e2bb4f88f0250f26ffff098b0b30b26bf38"},
+		{"0xdeadbeef00000000000000000000000000000000", "0x00", "0x00", "0xb928f69bb1d91cd65274e3c79d8986362984fda3"},
+		{"0x00000000000000000000000000000000deadbeef", "0xcafebabe", "0xdeadbeef", "0x60f3f640a8508fc6a86d45df051962668e1e8ac7"},
+		{"0x0000000000000000000000000000000000000000", "0x00", "0x", "0xe33c0c7f7df4809055c3eba6c09cfe4baf1bd9e0"},
+	}
+	for i, test := range tests {
+		initCode, _ := decodeHex(test.initCode)
+		addr, err := Create2AddressWithInitCode(test.deployer, test.salt, initCode)
+		if err != nil || addr != test.address {
+			t.Errorf("case %d address: %s, expected: %s, err: %v", i, addr, test.address, err)
+		}
+	}
+}
+
+func TestMinimalProxyAddress(t *testing.T) {
+	factory := "0x5fbdb2315678afecb367f032d93f642f64180aa3"
+	implementation := "0xe7f1725e7734ce288f8367e1bb143e90bb3f0512"
+	salt := "0x0000000000000000000000000000000000000000000000000000000000000001"
+
+	initCode, err := MinimalProxyInitCode(implementation)
+	if err != nil || len(initCode) != 55 {
+		t.Errorf("MinimalProxyInitCode failed, length: %d, err: %v", len(initCode), err)
+		return
+	}
+
+	addr, err := MinimalProxyAddress(factory, implementation, salt)
+	if err != nil {
+		t.Errorf("MinimalProxyAddress failed, err: %v", err)
+		return
+	}
+	var saltBytes [32]byte
+	saltBytes[31] = 1
+	expect := strings.ToLower(crypto.CreateAddress2(common.HexToAddress(factory), saltBytes, crypto.Keccak256(initCode)).String())
+	if addr != expect {
+		t.Errorf("address: %s, expected: %s", addr, expect)
+	}
+}
@@ -17,8 +17,9 @@ package quorum_rpc
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"strings"
+
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/imroc/req"
@@ -28,15 +29,26 @@ import (
 type Client struct {
 	context context.Context
 
-	BaseURL      string
-	BroadcastURL string
-	Debug        bool
-	RawClient    *rpc.Client //原生ETH客户端
+	BaseURL         string
+	BroadcastURL    string
+	BroadcastURLs   []string //多个广播节点，包括私有中继
+	BroadcastPolicy string   //多个广播节点的发送策略，默认并行
+	Debug           bool
+	RawClient       *rpc.Client //原生ETH客户端
 }
 
+// Dial broadcastURL支持逗号分隔的多个节点
 func Dial(baseURL, broadcastURL string, debug bool) (*Client, error) {
 	context := context.Background()
-	client := &Client{BaseURL: baseURL, BroadcastURL: broadcastURL, Debug: debug}
+	client := &Client{BaseURL: baseURL, Debug: debug}
+	for _, url := range strings.Split(broadcastURL, ",") {
+		if url = strings.TrimSpace(url); len(url) > 0 {
+			client.BroadcastURLs = append(client.BroadcastURLs, url)
+		}
+	}
+	if len(client.BroadcastURLs) > 0 {
+		client.BroadcastURL = client.BroadcastURLs[0]
+	}
 	rawClient, err := rpc.DialContext(context, baseURL)
 	if err != nil {
 		return nil, err
@@ -48,15 +60,28 @@ func Dial(baseURL, broadcastURL string, debug bool) (*Client, error) {
 
 func (c *Client) Call(method string, params []interface{}) (*gjson.Result, error) {
 
-	if method == "eth_sendRawTransaction" && len(c.BroadcastURL) != 0 {
-		// 广播交易使用BroadcastURL的节点
-		return c.callByHttpClient(c.BroadcastURL, method, params)
+	if method == "eth_sendRawTransaction" && (len(c.BroadcastURL) != 0 || len(c.BroadcastURLs) != 0) {
+		// 广播交易使用广播节点
+		return c.callBroadcast(params)
 	} else {
 		//return c.callByETHClient(method, params)
 		return c.callByHttpClient(c.BaseURL, method, params)
 	}
 }
 
+func (c *Client) callBroadcast(params []interface{}) (*gjson.Result, error) {
+	signedTx, ok := params[0].(string)
+	if len(params) != 1 || !ok {
+		return nil, fmt.Errorf("eth_sendRawTransaction params is invalid")
+	}
+	broadcast, err := c.SendRawTransaction(signedTx)
+	if err != nil {
+		return nil, err
+	}
+	txid := gjson.Parse(fmt.Sprintf("%q", broadcast.TxID))
+	return &txid, nil
+}
+
 func (c *Client) callByHttpClient(url, method string, params []interface{}) (*gjson.Result, error) {
 	authHeader := req.Header{
 		"Accept":       "application/json",
@@ -122,10 +147,38 @@ func isError(result *gjson.Result) error {
 		return nil
 	}
 
-	errInfo := fmt.Sprintf("[%d]%s",
-		result.Get("error.code").Int(),
-		result.Get("error.message").String())
-	err = errors.New(errInfo)
+	rpcErr := &Error{
+		Code:    result.Get("error.code").Int(),
+		Message: result.Get("error.message").String(),
+	}
+	if data := result.Get("error.data"); data.Exists() {
+		if data.Type == gjson.String {
+			rpcErr.Data = data.String()
+		} else {
+			rpcErr.Data = data.Raw
+		}
+	}
+	err = rpcErr
 
 	return err
 }
+
+// Error 节点返回的JSON-RPC错误
+type Error struct {
+	Code    int64
+	Message string
+	Data    string //错误附带数据，合约执行回滚时为revert数据
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%d]%s", e.Code, e.Message)
+}
+
+// IsExecutionReverted 错误是否为合约执行回滚，限流、区块未找到等节点错误不是回滚
+func IsExecutionReverted(err error) bool {
+	rpcErr, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+	return rpcErr.Code == 3 || strings.Contains(strings.ToLower(rpcErr.Message), "execution reverted")
+}
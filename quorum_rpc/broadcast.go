@@ -0,0 +1,245 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package quorum_rpc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// BroadcastPolicyParallel 同时发送到所有节点，任一节点接受即成功
+	BroadcastPolicyParallel = "parallel"
+	// BroadcastPolicySequential 按顺序逐个发送，直到有节点接受
+	BroadcastPolicySequential = "sequential"
+)
+
+// 节点已有该交易时返回的错误信息，不同客户端的描述不一致
+var alreadyKnownErrors = []string{
+	"already known",
+	"known transaction",
+	"alreadyknown",
+	"already imported",
+	"already exists",
+	"already in pool",
+}
+
+// BroadcastOutcome 单个节点的广播结果
+type BroadcastOutcome struct {
+	URL          string `json:"url"`
+	Accepted     bool   `json:"accepted"`     //节点接受了交易
+	AlreadyKnown bool   `json:"alreadyKnown"` //节点返回已有该交易，视为接受
+	TxID         string `json:"txid,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Elapsed      int64  `json:"elapsed"` //耗时，毫秒
+
+	err error
+}
+
+// BroadcastResult 交易广播结果
+type BroadcastResult struct {
+	TxID       string `json:"txid"`
+	AcceptedBy string `json:"acceptedBy"` //第一个接受交易的节点
+
+	total    int
+	outcomes []*BroadcastOutcome
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+func newBroadcastResult(total int) *BroadcastResult {
+	return &BroadcastResult{
+		total:    total,
+		outcomes: make([]*BroadcastOutcome, 0, total),
+		done:     make(chan struct{}),
+	}
+}
+
+func (r *BroadcastResult) add(outcome *BroadcastOutcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes = append(r.outcomes, outcome)
+	if len(r.outcomes) == r.total {
+		close(r.done)
+	}
+}
+
+// finish 顺序广播提前结束时，未发送的节点不再有结果
+func (r *BroadcastResult) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.outcomes) < r.total {
+		r.total = len(r.outcomes)
+		close(r.done)
+	}
+}
+
+// Outcomes 当前已返回的各节点结果
+func (r *BroadcastResult) Outcomes() []*BroadcastOutcome {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*BroadcastOutcome{}, r.outcomes...)
+}
+
+// Wait 等待所有节点返回，并行广播在第一个节点接受后就返回，其余节点的结果需要等待
+func (r *BroadcastResult) Wait() []*BroadcastOutcome {
+	<-r.done
+	return r.Outcomes()
+}
+
+// broadcastEndpoints 广播交易使用的节点
+func (c *Client) broadcastEndpoints() []string {
+	if len(c.BroadcastURLs) > 0 {
+		return c.BroadcastURLs
+	}
+	if len(c.BroadcastURL) > 0 {
+		return []string{c.BroadcastURL}
+	}
+	return []string{c.BaseURL}
+}
+
+// SendRawTransaction 按广播策略发送签名交易到广播节点
+func (c *Client) SendRawTransaction(signedTx string) (*BroadcastResult, error) {
+
+	rawTx, err := hexutil.Decode(signedTx)
+	if err != nil {
+		return nil, fmt.Errorf("signed transaction is invalid, err: %v", err)
+	}
+	//类型交易和传统交易的hash都是签名后编码数据的keccak256
+	txHash := hexutil.Encode(crypto.Keccak256(rawTx))
+
+	endpoints := c.broadcastEndpoints()
+	result := newBroadcastResult(len(endpoints))
+	accepted := make(chan *BroadcastOutcome, len(endpoints))
+
+	if c.BroadcastPolicy == BroadcastPolicySequential {
+		for _, url := range endpoints {
+			outcome := c.sendToEndpoint(url, signedTx, txHash)
+			result.add(outcome)
+			if outcome.Accepted {
+				result.finish()
+				result.TxID = outcome.TxID
+				result.AcceptedBy = outcome.URL
+				return result, nil
+			}
+		}
+		return result, broadcastError(result.Outcomes())
+	}
+
+	for _, url := range endpoints {
+		go func(url string) {
+			outcome := c.sendToEndpoint(url, signedTx, txHash)
+			//先通知接受再记录结果，避免done关闭时接受的结果还未送达
+			if outcome.Accepted {
+				accepted <- outcome
+			}
+			result.add(outcome)
+		}(url)
+	}
+
+	select {
+	case outcome := <-accepted:
+		result.TxID = outcome.TxID
+		result.AcceptedBy = outcome.URL
+		return result, nil
+	case <-result.done:
+		//所有节点都已返回，再检查一次是否有节点接受
+		outcomes := result.Outcomes()
+		for _, outcome := range outcomes {
+			if outcome.Accepted {
+				result.TxID = outcome.TxID
+				result.AcceptedBy = outcome.URL
+				return result, nil
+			}
+		}
+		return result, broadcastError(outcomes)
+	}
+}
+
+// sendToEndpoint 发送交易到单个节点
+func (c *Client) sendToEndpoint(url, signedTx, txHash string) *BroadcastOutcome {
+
+	start := time.Now()
+	outcome := &BroadcastOutcome{URL: url}
+	defer func() {
+		outcome.Elapsed = time.Since(start).Milliseconds()
+	}()
+
+	result, err := c.callByHttpClient(url, "eth_sendRawTransaction", []interface{}{signedTx})
+	if err == nil {
+		outcome.Accepted = true
+		outcome.TxID = result.String()
+		return outcome
+	}
+
+	outcome.Error = err.Error()
+	outcome.err = err
+	if isAlreadyKnownError(err) {
+		outcome.Accepted = true
+		outcome.AlreadyKnown = true
+		outcome.TxID = txHash
+		return outcome
+	}
+
+	//nonce too low可能是同一笔交易已经上链或在交易池中
+	if strings.Contains(strings.ToLower(err.Error()), "nonce too low") && c.hasTransaction(url, txHash) {
+		outcome.Accepted = true
+		outcome.AlreadyKnown = true
+		outcome.TxID = txHash
+	}
+	return outcome
+}
+
+// hasTransaction 节点是否已有该交易，私有中继可能不支持查询，再查询主节点
+func (c *Client) hasTransaction(url, txHash string) bool {
+	urls := []string{url}
+	if len(c.BaseURL) > 0 && c.BaseURL != url {
+		urls = append(urls, c.BaseURL)
+	}
+	for _, u := range urls {
+		result, err := c.callByHttpClient(u, "eth_getTransactionByHash", []interface{}{txHash})
+		if err == nil && result.IsObject() {
+			return true
+		}
+	}
+	return false
+}
+
+func isAlreadyKnownError(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, known := range alreadyKnownErrors {
+		if strings.Contains(message, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcastError 所有节点都没有接受交易，单个节点保留原始错误
+func broadcastError(outcomes []*BroadcastOutcome) error {
+	if len(outcomes) == 1 {
+		return outcomes[0].err
+	}
+	messages := make([]string, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		messages = append(messages, fmt.Sprintf("%s: %s", outcome.URL, outcome.Error))
+	}
+	return fmt.Errorf("all broadcast endpoints rejected the transaction, %s", strings.Join(messages, "; "))
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package quorum_rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestBroadcastNode(t *testing.T, sendResponse string, known bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body.Method {
+		case "eth_sendRawTransaction":
+			w.Write([]byte(sendResponse))
+		case "eth_getTransactionByHash":
+			if known {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"hash":"0x01"}}`))
+			} else {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+			}
+		}
+	}))
+}
+
+func TestClient_SendRawTransaction(t *testing.T) {
+	signedTx := "0xf86b80"
+	txHash := hexutil.Encode(crypto.Keccak256(hexutil.MustDecode(signedTx)))
+
+	rejected := newTestBroadcastNode(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"insufficient funds"}}`, false)
+	defer rejected.Close()
+	known := newTestBroadcastNode(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"already known"}}`, false)
+	defer known.Close()
+	nonceTooLow := newTestBroadcastNode(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"nonce too low"}}`, true)
+	defer nonceTooLow.Close()
+
+	tests := []struct {
+		name      string
+		policy    string
+		endpoints []string
+		accepted  bool
+		outcomes  int
+	}{
+		{"parallel", BroadcastPolicyParallel, []string{rejected.URL, known.URL}, true, 2},
+		{"sequential stop on accept", BroadcastPolicySequential, []string{nonceTooLow.URL, rejected.URL}, true, 1},
+		{"sequential all rejected", BroadcastPolicySequential, []string{rejected.URL, rejected.URL}, false, 2},
+	}
+
+	for _, test := range tests {
+		client := &Client{BaseURL: rejected.URL, BroadcastURLs: test.endpoints, BroadcastPolicy: test.policy}
+		result, err := client.SendRawTransaction(signedTx)
+		if test.accepted {
+			if err != nil || result.TxID != txHash {
+				t.Errorf("%s: expected accepted, err: %v", test.name, err)
+				continue
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected rejected", test.name)
+			continue
+		}
+		if outcomes := result.Wait(); len(outcomes) != test.outcomes {
+			t.Errorf("%s: expected %d outcomes, got %d", test.name, test.outcomes, len(outcomes))
+		}
+	}
+}
+
+func TestClient_SendRawTransaction_LastEndpointAccepted(t *testing.T) {
+	signedTx := "0xf86b80"
+	txHash := hexutil.Encode(crypto.Keccak256(hexutil.MustDecode(signedTx)))
+
+	rejected := newTestBroadcastNode(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"insufficient funds"}}`, false)
+	defer rejected.Close()
+	known := newTestBroadcastNode(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"already known"}}`, false)
+	defer known.Close()
+
+	//最后返回的节点接受交易时，done关闭不能早于接受结果
+	for i := 0; i < 50; i++ {
+		for _, endpoints := range [][]string{{known.URL}, {rejected.URL, known.URL}} {
+			client := &Client{BaseURL: rejected.URL, BroadcastURLs: endpoints, BroadcastPolicy: BroadcastPolicyParallel}
+			result, err := client.SendRawTransaction(signedTx)
+			if err != nil || result.TxID != txHash {
+				t.Errorf("endpoints %v: expected accepted with txid %s, got: %s, err: %v", endpoints, txHash, result.TxID, err)
+				return
+			}
+		}
+	}
+}